@@ -0,0 +1,478 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package alerting evaluates alerting rules on the metrics of the
+// node_exporter and sends their alerts, so a node raises its own alerts when
+// no Prometheus server can scrape it.
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+const namespace = "node_exporter"
+
+const (
+	defaultEvaluationInterval = 15 * time.Second
+	// resendDelay is how often firing alerts are sent to the Alertmanagers
+	// again, so they don't resolve them.
+	resendDelay = time.Minute
+)
+
+var (
+	alertsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "alerts",
+		Help:      "Number of pending and firing alerts.",
+	}, []string{"state"})
+
+	// defaultRules are the rules added by the default_rules setting.
+	defaultRules = []*utils.AlertRuleConfig{{
+		Alert:       "CollectorFailed",
+		Expr:        "node_scrape_collector_success == 0",
+		Labels:      map[string]string{"severity": "warning"},
+		Annotations: map[string]string{"summary": "Collector {{ $labels.collector }} failed"},
+	}, {
+		Alert:       "SystemdUnitFailed",
+		Expr:        `node_systemd_unit_state{state="failed"} == 1`,
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Systemd unit {{ $labels.name }} failed"},
+	}, {
+		// The supervisord states from EXITED on mean the process exited.
+		Alert:       "SupervisordProcessExited",
+		Expr:        "node_supervisord_state >= 100",
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Supervisord process {{ $labels.name }} exited"},
+	}, {
+		Alert:       "FilesystemDeviceError",
+		Expr:        "node_filesystem_device_error == 1",
+		Labels:      map[string]string{"severity": "critical"},
+		Annotations: map[string]string{"summary": "Filesystem {{ $labels.mountpoint }} on {{ $labels.device }} has a device error"},
+	}}
+
+	// Metrics are the metrics of the alerting rules and the notifiers, to be
+	// registered once.
+	Metrics = []prometheus.Collector{
+		alertsGauge, notificationsSent, notificationsFailed, notificationsDropped, notificationsQueued,
+	}
+)
+
+// State is the state of an alert.
+type State string
+
+// The states of an alert.
+const (
+	StatePending  State = "pending"
+	StateFiring   State = "firing"
+	StateResolved State = "resolved"
+)
+
+// Alert is an alert of a rule, pending until the condition of the rule held
+// for its for duration.
+type Alert struct {
+	Labels      model.LabelSet
+	Annotations model.LabelSet
+	State       State
+	// Value is the value of the sample that triggered the alert.
+	Value    float64
+	ActiveAt time.Time
+	// EndsAt is when a resolved alert resolved, and until when a firing alert
+	// is valid if it isn't sent again.
+	EndsAt time.Time
+
+	lastSent time.Time
+}
+
+// MarshalJSON implements json.Marshaler, following the Prometheus HTTP API.
+func (a Alert) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Labels      model.LabelSet `json:"labels"`
+		Annotations model.LabelSet `json:"annotations"`
+		State       State          `json:"state"`
+		ActiveAt    time.Time      `json:"activeAt"`
+		Value       string         `json:"value"`
+	}{
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+		State:       a.State,
+		ActiveAt:    a.ActiveAt,
+		Value:       strconv.FormatFloat(a.Value, 'e', -1, 64),
+	})
+}
+
+type rule struct {
+	name         string
+	exprString   string
+	expr         *expr
+	holdDuration time.Duration
+	labels       map[model.LabelName]*template.Template
+	annotations  map[model.LabelName]*template.Template
+
+	active map[model.Fingerprint]*Alert
+}
+
+func newRule(cfg *utils.AlertRuleConfig) (*rule, error) {
+	if cfg.Alert == "" || !model.LabelValue(cfg.Alert).IsValid() {
+		return nil, fmt.Errorf("invalid alert name %q", cfg.Alert)
+	}
+	e, err := parseExpr(cfg.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("alert %s: %s", cfg.Alert, err)
+	}
+	r := &rule{
+		name:         cfg.Alert,
+		exprString:   cfg.Expr,
+		expr:         e,
+		holdDuration: cfg.For,
+		labels:       map[model.LabelName]*template.Template{},
+		annotations:  map[model.LabelName]*template.Template{},
+		active:       map[model.Fingerprint]*Alert{},
+	}
+	for name, text := range cfg.Labels {
+		if !model.LabelName(name).IsValid() || name == model.AlertNameLabel {
+			return nil, fmt.Errorf("alert %s: invalid label name %q", cfg.Alert, name)
+		}
+		if r.labels[model.LabelName(name)], err = newTemplate(name, text); err != nil {
+			return nil, fmt.Errorf("alert %s: %s", cfg.Alert, err)
+		}
+	}
+	for name, text := range cfg.Annotations {
+		if !model.LabelName(name).IsValid() {
+			return nil, fmt.Errorf("alert %s: invalid annotation name %q", cfg.Alert, name)
+		}
+		if r.annotations[model.LabelName(name)], err = newTemplate(name, text); err != nil {
+			return nil, fmt.Errorf("alert %s: %s", cfg.Alert, err)
+		}
+	}
+	return r, nil
+}
+
+// newTemplate parses a template of a label or annotation, which can refer to
+// the labels of the sample as $labels and to its value as $value.
+func newTemplate(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Parse("{{$labels := .Labels}}{{$value := .Value}}" + text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of %s: %s", name, err)
+	}
+	return t, nil
+}
+
+func expand(t *template.Template, labels map[string]string, value float64) string {
+	var b bytes.Buffer
+	err := t.Execute(&b, struct {
+		Labels map[string]string
+		Value  float64
+	}{labels, value})
+	if err != nil {
+		return fmt.Sprintf("<error expanding template: %s>", err)
+	}
+	return b.String()
+}
+
+// eval updates the alerts of r with the samples at ts, returning copies of
+// the alerts which started firing or resolved.
+func (r *rule) eval(samples model.Vector, ts time.Time) []Alert {
+	var changed []Alert
+	seen := map[model.Fingerprint]bool{}
+	for _, s := range r.expr.eval(samples) {
+		sampleLabels := make(map[string]string, len(s.Metric))
+		labels := make(model.LabelSet, len(s.Metric)+len(r.labels)+1)
+		for name, value := range s.Metric {
+			if name != model.MetricNameLabel {
+				sampleLabels[string(name)] = string(value)
+				labels[name] = value
+			}
+		}
+		value := float64(s.Value)
+		for name, t := range r.labels {
+			labels[name] = model.LabelValue(expand(t, sampleLabels, value))
+		}
+		labels[model.AlertNameLabel] = model.LabelValue(r.name)
+		fp := labels.Fingerprint()
+		if seen[fp] {
+			continue
+		}
+		seen[fp] = true
+
+		a, ok := r.active[fp]
+		if !ok {
+			a = &Alert{Labels: labels, State: StatePending, ActiveAt: ts}
+			r.active[fp] = a
+		}
+		a.Value = value
+		a.Annotations = make(model.LabelSet, len(r.annotations))
+		for name, t := range r.annotations {
+			a.Annotations[name] = model.LabelValue(expand(t, sampleLabels, value))
+		}
+		if a.State == StatePending && ts.Sub(a.ActiveAt) >= r.holdDuration {
+			a.State = StateFiring
+			changed = append(changed, *a)
+		}
+	}
+
+	for fp, a := range r.active {
+		if seen[fp] {
+			continue
+		}
+		delete(r.active, fp)
+		if a.State == StateFiring {
+			a.State = StateResolved
+			a.EndsAt = ts
+			changed = append(changed, *a)
+		}
+	}
+	return changed
+}
+
+// emailEvent returns the email event of an alert which started firing or
+// resolved at ts.
+func emailEvent(a Alert, ts time.Time) utils.EmailEvent {
+	labels := make(model.LabelSet, len(a.Labels))
+	for name, value := range a.Labels {
+		if name != model.AlertNameLabel && name != "severity" {
+			labels[name] = value
+		}
+	}
+	summary := fmt.Sprintf("%s %s%s", strings.ToUpper(string(a.State)), a.Labels[model.AlertNameLabel], labels)
+	if s, ok := a.Annotations["summary"]; ok {
+		summary += ": " + string(s)
+	}
+	return utils.EmailEvent{
+		Key:      a.Labels.String(),
+		Severity: string(a.Labels["severity"]),
+		Summary:  summary,
+		Time:     ts,
+	}
+}
+
+// Manager evaluates the alerting rules at a fixed interval and sends the
+// alerts to the Alertmanagers and webhooks. Firing alerts are sent to the
+// Alertmanagers again every minute, the webhooks and emails only get the
+// changes.
+type Manager struct {
+	interval      time.Duration
+	gatherer      prometheus.Gatherer
+	alertmanagers []*notifier
+	webhooks      []*notifier
+	email         *utils.EmailNotifier
+
+	mtx   sync.Mutex
+	rules []*rule
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewManager returns a Manager evaluating the rules of cfg on the metrics of
+// g, it has to be started. A nil cfg returns a nil Manager.
+func NewManager(cfg *utils.AlertingConfig, g prometheus.Gatherer) (*Manager, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	m := &Manager{
+		interval: cfg.EvaluationInterval,
+		gatherer: g,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if m.interval <= 0 {
+		m.interval = defaultEvaluationInterval
+	}
+	rules := cfg.Rules
+	if cfg.DefaultRules {
+		rules = append(append([]*utils.AlertRuleConfig{}, rules...), defaultRules...)
+	}
+	for _, rc := range rules {
+		r, err := newRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, r)
+	}
+	for _, nc := range cfg.Alertmanagers {
+		n, err := newAlertmanagerNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		m.alertmanagers = append(m.alertmanagers, n)
+	}
+	for _, nc := range cfg.Webhooks {
+		n, err := newWebhookNotifier(nc)
+		if err != nil {
+			return nil, err
+		}
+		m.webhooks = append(m.webhooks, n)
+	}
+	if cfg.Email != nil {
+		// Emails are only sent for alerts, they would silently never be sent
+		// without rules.
+		if len(rules) == 0 {
+			return nil, errors.New("email: no alerting rules, set rules or default_rules")
+		}
+		var err error
+		if m.email, err = utils.NewEmailNotifier(cfg.Email); err != nil {
+			return nil, fmt.Errorf("email: %s", err)
+		}
+	}
+	return m, nil
+}
+
+// Start starts evaluating the rules in the background. The active alerts of
+// the rules of old, which must be stopped, are taken over by the rules with
+// the same name and expression, so a reload doesn't resend or reset them.
+// old may be nil.
+func (m *Manager) Start(old *Manager) {
+	if old != nil {
+		old.mtx.Lock()
+		for _, r := range m.rules {
+			for _, o := range old.rules {
+				if o.name == r.name && o.exprString == r.exprString {
+					r.active, o.active = o.active, map[model.Fingerprint]*Alert{}
+					break
+				}
+			}
+		}
+		old.mtx.Unlock()
+	}
+	for _, n := range m.notifiers() {
+		n.start()
+	}
+	if m.email != nil {
+		m.email.Start()
+	}
+	log.Infof("Evaluating %d alerting rules every %s", len(m.rules), m.interval)
+	go m.run()
+}
+
+// Stop stops evaluating the rules and sending alerts. The alerts not sent
+// yet are dropped, except for the pending emails which are sent.
+func (m *Manager) Stop() {
+	close(m.done)
+	<-m.stopped
+	for _, n := range m.notifiers() {
+		n.stop()
+	}
+	if m.email != nil {
+		m.email.Stop()
+	}
+}
+
+func (m *Manager) notifiers() []*notifier {
+	return append(append([]*notifier{}, m.alertmanagers...), m.webhooks...)
+}
+
+func (m *Manager) run() {
+	defer close(m.stopped)
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.eval(time.Now())
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// eval evaluates the rules on the metrics gathered at ts and queues the
+// alerts to send.
+func (m *Manager) eval(ts time.Time) {
+	mfs, err := m.gatherer.Gather()
+	if err != nil {
+		// An absent series must not be mistaken for a failed gathering.
+		if len(mfs) == 0 {
+			log.Errorln("Error gathering metrics, not evaluating the alerting rules:", err)
+			return
+		}
+		log.Warnln("Error gathering metrics, evaluating the alerting rules on the rest:", err)
+	}
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.TimeFromUnixNano(ts.UnixNano())}, mfs...)
+	if err != nil {
+		log.Warnln("Error extracting samples, evaluating the alerting rules on the rest:", err)
+	}
+
+	validity := 4 * resendDelay
+	if 4*m.interval > validity {
+		validity = 4 * m.interval
+	}
+	var changed, resend []Alert
+	pending, firing := 0, 0
+	m.mtx.Lock()
+	for _, r := range m.rules {
+		for _, a := range r.eval(samples, ts) {
+			changed = append(changed, a)
+			if a.State == StateResolved {
+				resend = append(resend, a)
+			}
+		}
+		for _, a := range r.active {
+			if a.State == StatePending {
+				pending++
+				continue
+			}
+			firing++
+			if ts.Sub(a.lastSent) >= resendDelay {
+				a.lastSent = ts
+				a.EndsAt = ts.Add(validity)
+				resend = append(resend, *a)
+			}
+		}
+	}
+	m.mtx.Unlock()
+	alertsGauge.WithLabelValues(string(StatePending)).Set(float64(pending))
+	alertsGauge.WithLabelValues(string(StateFiring)).Set(float64(firing))
+
+	for _, n := range m.alertmanagers {
+		n.enqueue(resend)
+	}
+	for _, n := range m.webhooks {
+		n.enqueue(changed)
+	}
+	if m.email != nil && len(changed) > 0 {
+		events := make([]utils.EmailEvent, 0, len(changed))
+		for _, a := range changed {
+			events = append(events, emailEvent(a, ts))
+		}
+		m.email.Notify(events...)
+	}
+}
+
+// Alerts returns the pending and firing alerts, sorted by their labels.
+func (m *Manager) Alerts() []Alert {
+	m.mtx.Lock()
+	alerts := []Alert{}
+	for _, r := range m.rules {
+		for _, a := range r.active {
+			alerts = append(alerts, *a)
+		}
+	}
+	m.mtx.Unlock()
+	sort.Slice(alerts, func(i, j int) bool { return alerts[i].Labels.Before(alerts[j].Labels) })
+	return alerts
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// receiver records the bodies posted to it, failing the first requests.
+type receiver struct {
+	mtx      sync.Mutex
+	failures int
+	bodies   [][]byte
+	received chan struct{}
+}
+
+func newReceiver(failures int) *receiver {
+	return &receiver{failures: failures, received: make(chan struct{}, 100)}
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var body json.RawMessage
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.bodies = append(r.bodies, body)
+	r.received <- struct{}{}
+}
+
+func (r *receiver) wait(t *testing.T) []byte {
+	select {
+	case <-r.received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification received")
+	}
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.bodies[len(r.bodies)-1]
+}
+
+func TestManager(t *testing.T) {
+	load := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_load1", Help: "Load."})
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(load)
+
+	am, hook := newReceiver(2), newReceiver(0)
+	amServer, hookServer := httptest.NewServer(am), httptest.NewServer(hook)
+	defer amServer.Close()
+	defer hookServer.Close()
+
+	m, err := NewManager(&utils.AlertingConfig{
+		EvaluationInterval: time.Hour,
+		Rules: []*utils.AlertRuleConfig{{
+			Alert:       "HighLoad",
+			Expr:        "node_load1 > 10",
+			For:         time.Minute,
+			Labels:      map[string]string{"severity": "critical"},
+			Annotations: map[string]string{"summary": "Load is {{ $value }}"},
+		}, {
+			Alert: "LoadMissing",
+			Expr:  "absent(node_load1)",
+		}},
+		Alertmanagers: []*utils.NotifierConfig{{URL: amServer.URL, MaxBackoff: 10 * time.Millisecond}},
+		Webhooks:      []*utils.NotifierConfig{{URL: hookServer.URL}},
+	}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range m.notifiers() {
+		n.start()
+		defer n.stop()
+	}
+
+	now := time.Now()
+	load.Set(12)
+	m.eval(now)
+	alerts := m.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StatePending || alerts[0].Annotations["summary"] != "Load is 12" {
+		t.Fatalf("want a pending HighLoad alert, got %+v", alerts)
+	}
+
+	// The Alertmanager gets the firing alert once it recovered.
+	m.eval(now.Add(time.Minute))
+	var amAlerts []amAlert
+	if err := json.Unmarshal(am.wait(t), &amAlerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(amAlerts) != 1 || amAlerts[0].Labels["alertname"] != "HighLoad" || amAlerts[0].Labels["severity"] != "critical" {
+		t.Errorf("unexpected Alertmanager alerts %+v", amAlerts)
+	}
+	var msg webhookMessage
+	if err := json.Unmarshal(hook.wait(t), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != "firing" || len(msg.Alerts) != 1 || msg.Alerts[0].Value != "1.2e+01" {
+		t.Errorf("unexpected webhook message %+v", msg)
+	}
+
+	// Resolved alerts are sent with their end.
+	load.Set(1)
+	resolved := now.Add(2 * time.Minute)
+	m.eval(resolved)
+	if alerts := m.Alerts(); len(alerts) != 0 {
+		t.Errorf("want no alerts, got %+v", alerts)
+	}
+	if err := json.Unmarshal(am.wait(t), &amAlerts); err != nil {
+		t.Fatal(err)
+	}
+	if len(amAlerts) != 1 || !amAlerts[0].EndsAt.Equal(resolved) {
+		t.Errorf("want the alert resolved at %s, got %+v", resolved, amAlerts)
+	}
+	if err := json.Unmarshal(hook.wait(t), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Status != "resolved" || msg.Alerts[0].EndsAt == nil {
+		t.Errorf("unexpected webhook message %+v", msg)
+	}
+}
+
+func TestManagerStartKeepsAlerts(t *testing.T) {
+	cfg := &utils.AlertingConfig{
+		Rules: []*utils.AlertRuleConfig{{Alert: "NoLoad", Expr: "absent(node_load1)", For: time.Minute}},
+	}
+	old, err := NewManager(cfg, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.Start(nil)
+	old.Stop()
+	pending := old.Alerts()
+	if len(pending) != 1 {
+		t.Fatalf("want a pending alert, got %+v", pending)
+	}
+
+	m, err := NewManager(cfg, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Start(old)
+	defer m.Stop()
+	alerts := m.Alerts()
+	if len(alerts) != 1 || !alerts[0].ActiveAt.Equal(pending[0].ActiveAt) {
+		t.Errorf("want the pending alert kept, got %+v", alerts)
+	}
+}
+
+func TestDefaultRules(t *testing.T) {
+	unitState := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "node_systemd_unit_state", Help: "State."}, []string{"name", "state"})
+	unitState.WithLabelValues("cron.service", "failed").Set(1)
+	unitState.WithLabelValues("ssh.service", "failed").Set(0)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(unitState)
+
+	m, err := NewManager(&utils.AlertingConfig{DefaultRules: true}, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	m.eval(now)
+	alerts := m.Alerts()
+	if len(alerts) != 1 || alerts[0].State != StateFiring || alerts[0].Labels["alertname"] != "SystemdUnitFailed" {
+		t.Fatalf("want a firing SystemdUnitFailed alert, got %+v", alerts)
+	}
+
+	ev := emailEvent(alerts[0], now)
+	want := `FIRING SystemdUnitFailed{name="cron.service", state="failed"}: Systemd unit cron.service failed`
+	if ev.Summary != want || ev.Severity != "critical" || !ev.Time.Equal(now) {
+		t.Errorf("want an event %q, got %+v", want, ev)
+	}
+}
+
+func TestNewManagerErrors(t *testing.T) {
+	for _, cfg := range []*utils.AlertingConfig{
+		{Rules: []*utils.AlertRuleConfig{{Expr: "up == 0"}}},
+		{Rules: []*utils.AlertRuleConfig{{Alert: "A", Expr: "up =="}}},
+		{Rules: []*utils.AlertRuleConfig{{Alert: "A", Expr: "up", Labels: map[string]string{"alertname": "B"}}}},
+		{Rules: []*utils.AlertRuleConfig{{Alert: "A", Expr: "up", Annotations: map[string]string{"summary": "{{"}}}},
+		{Webhooks: []*utils.NotifierConfig{{URL: "ftp://example.com"}}},
+		{Email: &utils.EmailConfig{SMTPHost: "smtp.example.com", From: "node@example.com", Recipients: map[string][]string{"default": {"ops@example.com"}}}},
+	} {
+		if _, err := NewManager(cfg, prometheus.NewRegistry()); err == nil {
+			t.Errorf("want an error for %+v", cfg)
+		}
+	}
+}
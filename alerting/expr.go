@@ -0,0 +1,144 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/history"
+)
+
+// The comparison operators, longest first so they are matched greedily.
+var operators = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// expr is a series selector optionally compared to a number, or the absence
+// of the series matching a selector.
+type expr struct {
+	matchers  []*history.Matcher
+	absent    bool
+	op        string
+	threshold float64
+}
+
+// parseExpr parses expressions like node_load1 > 10, up{job="node"} == 0,
+// node_systemd_unit_state{state="failed"} or absent(node_kmsg_condition).
+func parseExpr(s string) (*expr, error) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "absent(") && strings.HasSuffix(s, ")") {
+		matchers, err := history.ParseSelector(s[len("absent(") : len(s)-1])
+		if err != nil {
+			return nil, err
+		}
+		return &expr{matchers: matchers, absent: true}, nil
+	}
+
+	selector, op, threshold := s, "", ""
+	if i, o := findOperator(s); i >= 0 {
+		selector, op, threshold = s[:i], o, strings.TrimSpace(s[i+len(o):])
+	}
+	matchers, err := history.ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	e := &expr{matchers: matchers, op: op}
+	if op != "" {
+		if e.threshold, err = strconv.ParseFloat(threshold, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %q in %q", threshold, s)
+		}
+	}
+	return e, nil
+}
+
+// findOperator returns the position of the first comparison operator of s
+// outside of label matchers, or -1.
+func findOperator(s string) (int, string) {
+	braces := false
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case braces:
+			if c == '"' || c == '\'' {
+				quote = c
+			} else if c == '}' {
+				braces = false
+			}
+		case c == '{':
+			braces = true
+		default:
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					return i, op
+				}
+			}
+		}
+	}
+	return -1, ""
+}
+
+// eval returns the samples for which e is true. An absent expression returns
+// a single sample of value 1 with the labels of its equality matchers, like
+// PromQL, if no sample matches.
+func (e *expr) eval(samples model.Vector) model.Vector {
+	var result model.Vector
+	for _, s := range samples {
+		if !history.MatchAll(e.matchers, s.Metric) {
+			continue
+		}
+		if e.absent {
+			return nil
+		}
+		if e.op == "" || compare(float64(s.Value), e.op, e.threshold) {
+			result = append(result, s)
+		}
+	}
+	if !e.absent {
+		return result
+	}
+
+	m := model.Metric{}
+	for _, matcher := range e.matchers {
+		if matcher.Type == history.MatchEqual && matcher.Name != model.MetricNameLabel {
+			m[matcher.Name] = model.LabelValue(matcher.Value)
+		}
+	}
+	return model.Vector{&model.Sample{Metric: m, Value: 1}}
+}
+
+func compare(v float64, op string, threshold float64) bool {
+	switch op {
+	case ">":
+		return v > threshold
+	case ">=":
+		return v >= threshold
+	case "<":
+		return v < threshold
+	case "<=":
+		return v <= threshold
+	case "==":
+		return v == threshold
+	case "!=":
+		return v != threshold
+	}
+	panic("invalid operator " + op)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerting
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestExpr(t *testing.T) {
+	samples := model.Vector{
+		{Metric: model.Metric{"__name__": "node_load1"}, Value: 12},
+		{Metric: model.Metric{"__name__": "node_filesystem_avail_bytes", "mountpoint": "/"}, Value: 100},
+		{Metric: model.Metric{"__name__": "node_filesystem_avail_bytes", "mountpoint": "/home"}, Value: 1000},
+		{Metric: model.Metric{"__name__": "node_systemd_unit_state", "name": "a>b.service", "state": "failed"}, Value: 1},
+	}
+	for _, c := range []struct {
+		expr string
+		want int
+	}{
+		{"node_load1 > 10", 1},
+		{"node_load1>=12", 1},
+		{"node_load1 < 10", 0},
+		{`node_filesystem_avail_bytes{mountpoint=~"/.*"} <= 1e3`, 2},
+		{`node_filesystem_avail_bytes{mountpoint!="/"} != 1000`, 0},
+		{`node_systemd_unit_state{name="a>b.service",state="failed"} == 1`, 1},
+		{`node_systemd_unit_state{state="failed"}`, 1},
+		{`absent(node_load1)`, 0},
+		{`absent(node_load5)`, 1},
+	} {
+		e, err := parseExpr(c.expr)
+		if err != nil {
+			t.Errorf("%s: %s", c.expr, err)
+			continue
+		}
+		if got := len(e.eval(samples)); got != c.want {
+			t.Errorf("%s: want %d samples, got %d", c.expr, c.want, got)
+		}
+	}
+
+	e, _ := parseExpr(`absent(up{job="node",instance=~".+"})`)
+	got := e.eval(nil)
+	if want := (model.Metric{"job": "node"}); len(got) != 1 || !got[0].Metric.Equal(want) {
+		t.Errorf("absent: want %v, got %v", want, got)
+	}
+
+	for _, expr := range []string{"", "node_load1 >", "node_load1 > x", "> 1", `absent(node_load1{)`, "node_load1 10"} {
+		if _, err := parseExpr(expr); err == nil {
+			t.Errorf("%q: want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+const (
+	defaultNotifierTimeout   = 10 * time.Second
+	defaultMaxBackoff        = time.Minute
+	defaultQueueCapacity     = 1000
+	minBackoff               = time.Second
+	maxAlertsPerNotification = 64
+)
+
+var (
+	notificationsSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "notifications",
+		Name:      "sent_total",
+		Help:      "Total number of alerts sent.",
+	}, []string{"notifier"})
+	notificationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "notifications",
+		Name:      "failed_total",
+		Help:      "Total number of notifications that failed to be sent.",
+	}, []string{"notifier"})
+	notificationsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "notifications",
+		Name:      "dropped_total",
+		Help:      "Total number of alerts dropped because the queue was full or the notifier rejected them.",
+	}, []string{"notifier"})
+	notificationsQueued = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "notifications",
+		Name:      "queue_length",
+		Help:      "Number of alerts waiting to be sent.",
+	}, []string{"notifier"})
+)
+
+// notifier sends the alerts of its queue to an endpoint, in order. Failed
+// sends are retried with an exponential back-off, except for requests the
+// endpoint rejected.
+type notifier struct {
+	url string
+	// name is the URL without credentials, used as label and in logs.
+	name       string
+	encode     func([]Alert) ([]byte, error)
+	client     *http.Client
+	capacity   int
+	maxBackoff time.Duration
+
+	mtx   sync.Mutex
+	queue []Alert
+	// removed is the number of alerts removed from the front of the queue
+	// since the start.
+	removed uint64
+	more    chan struct{}
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+func newNotifier(cfg *utils.NotifierConfig, rawurl string, encode func([]Alert) ([]byte, error)) (*notifier, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notifier URL %q: %s", cfg.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid notifier URL %q: scheme must be http or https", cfg.URL)
+	}
+	n := &notifier{
+		url:        rawurl,
+		name:       redactURL(u),
+		encode:     encode,
+		client:     &http.Client{Timeout: cfg.Timeout},
+		capacity:   cfg.QueueCapacity,
+		maxBackoff: cfg.MaxBackoff,
+		more:       make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if n.client.Timeout <= 0 {
+		n.client.Timeout = defaultNotifierTimeout
+	}
+	if n.capacity <= 0 {
+		n.capacity = defaultQueueCapacity
+	}
+	if n.maxBackoff <= 0 {
+		n.maxBackoff = defaultMaxBackoff
+	}
+	return n, nil
+}
+
+// redactURL returns u without its user information and query, which may
+// hold credentials.
+func redactURL(u *url.URL) string {
+	r := *u
+	r.User = nil
+	r.RawQuery = ""
+	r.ForceQuery = false
+	r.Fragment = ""
+	return r.String()
+}
+
+// amAlert is an alert in the format of the Alertmanager API.
+type amAlert struct {
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+	StartsAt    time.Time      `json:"startsAt"`
+	EndsAt      time.Time      `json:"endsAt"`
+}
+
+// newAlertmanagerNotifier returns a notifier posting to the v1 alerts API of
+// the Alertmanager at cfg.URL.
+func newAlertmanagerNotifier(cfg *utils.NotifierConfig) (*notifier, error) {
+	return newNotifier(cfg, strings.TrimSuffix(cfg.URL, "/")+"/api/v1/alerts", func(alerts []Alert) ([]byte, error) {
+		ams := make([]amAlert, 0, len(alerts))
+		for _, a := range alerts {
+			ams = append(ams, amAlert{
+				Labels:      a.Labels,
+				Annotations: a.Annotations,
+				StartsAt:    a.ActiveAt,
+				EndsAt:      a.EndsAt,
+			})
+		}
+		return json.Marshal(ams)
+	})
+}
+
+// webhookMessage is the body of a webhook notification, like the webhooks of
+// the Alertmanager. Its status is firing if any of the alerts is firing.
+type webhookMessage struct {
+	Status string         `json:"status"`
+	Alerts []webhookAlert `json:"alerts"`
+}
+
+type webhookAlert struct {
+	Status      State          `json:"status"`
+	Labels      model.LabelSet `json:"labels"`
+	Annotations model.LabelSet `json:"annotations"`
+	StartsAt    time.Time      `json:"startsAt"`
+	EndsAt      *time.Time     `json:"endsAt,omitempty"`
+	Value       string         `json:"value"`
+}
+
+// newWebhookNotifier returns a notifier posting JSON messages to cfg.URL.
+func newWebhookNotifier(cfg *utils.NotifierConfig) (*notifier, error) {
+	return newNotifier(cfg, cfg.URL, func(alerts []Alert) ([]byte, error) {
+		msg := webhookMessage{Status: string(StateResolved), Alerts: make([]webhookAlert, 0, len(alerts))}
+		for _, a := range alerts {
+			wa := webhookAlert{
+				Status:      a.State,
+				Labels:      a.Labels,
+				Annotations: a.Annotations,
+				StartsAt:    a.ActiveAt,
+				Value:       strconv.FormatFloat(a.Value, 'e', -1, 64),
+			}
+			if a.State == StateResolved {
+				endsAt := a.EndsAt
+				wa.EndsAt = &endsAt
+			} else {
+				msg.Status = string(StateFiring)
+			}
+			msg.Alerts = append(msg.Alerts, wa)
+		}
+		return json.Marshal(msg)
+	})
+}
+
+// enqueue queues alerts to be sent, dropping the oldest ones if the queue is
+// full.
+func (n *notifier) enqueue(alerts []Alert) {
+	if len(alerts) == 0 {
+		return
+	}
+	n.mtx.Lock()
+	n.queue = append(n.queue, alerts...)
+	if over := len(n.queue) - n.capacity; over > 0 {
+		n.queue = n.queue[over:]
+		n.removed += uint64(over)
+		notificationsDropped.WithLabelValues(n.name).Add(float64(over))
+	}
+	notificationsQueued.WithLabelValues(n.name).Set(float64(len(n.queue)))
+	n.mtx.Unlock()
+	select {
+	case n.more <- struct{}{}:
+	default:
+	}
+}
+
+func (n *notifier) start() {
+	go n.run()
+}
+
+func (n *notifier) stop() {
+	close(n.done)
+	<-n.stopped
+	notificationsSent.DeleteLabelValues(n.name)
+	notificationsFailed.DeleteLabelValues(n.name)
+	notificationsDropped.DeleteLabelValues(n.name)
+	notificationsQueued.DeleteLabelValues(n.name)
+}
+
+func (n *notifier) run() {
+	defer close(n.stopped)
+	var backoff time.Duration
+	for {
+		n.mtx.Lock()
+		batch := n.queue
+		if len(batch) > maxAlertsPerNotification {
+			batch = batch[:maxAlertsPerNotification]
+		}
+		batch = append([]Alert(nil), batch...)
+		start := n.removed
+		n.mtx.Unlock()
+		if len(batch) == 0 {
+			select {
+			case <-n.more:
+				continue
+			case <-n.done:
+				return
+			}
+		}
+
+		err := n.send(batch)
+		switch err.(type) {
+		case nil:
+			notificationsSent.WithLabelValues(n.name).Add(float64(len(batch)))
+			backoff = 0
+		case unrecoverableError:
+			log.Errorf("Dropping %d alerts rejected by %s: %s", len(batch), n.name, err)
+			notificationsDropped.WithLabelValues(n.name).Add(float64(len(batch)))
+			backoff = 0
+		default:
+			backoff *= 2
+			if backoff < minBackoff {
+				backoff = minBackoff
+			}
+			if backoff > n.maxBackoff {
+				backoff = n.maxBackoff
+			}
+			log.Errorf("Error sending %d alerts to %s, retrying in %s: %s", len(batch), n.name, backoff, err)
+			select {
+			case <-time.After(backoff):
+				continue
+			case <-n.done:
+				return
+			}
+		}
+
+		// The oldest alerts of the batch may have been dropped meanwhile.
+		n.mtx.Lock()
+		if end := start + uint64(len(batch)); end > n.removed {
+			sent := int(end - n.removed)
+			n.queue = n.queue[sent:]
+			n.removed += uint64(sent)
+		}
+		notificationsQueued.WithLabelValues(n.name).Set(float64(len(n.queue)))
+		n.mtx.Unlock()
+	}
+}
+
+// unrecoverableError is returned for notifications that will never be
+// accepted.
+type unrecoverableError struct {
+	error
+}
+
+func (n *notifier) send(alerts []Alert) error {
+	body, err := n.encode(alerts)
+	if err != nil {
+		return unrecoverableError{err}
+	}
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(body))
+	if err != nil {
+		return unrecoverableError{err}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "node_exporter/"+version.Version)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		notificationsFailed.WithLabelValues(n.name).Inc()
+		if ue, ok := err.(*url.Error); ok {
+			// The error is logged, it mustn't include the credentials.
+			ue.URL = n.name
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	notificationsFailed.WithLabelValues(n.name).Inc()
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	// Client errors other than rate limiting won't go away by retrying.
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return unrecoverableError{err}
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/alerting"
+	"github.com/prometheus/node_exporter/collector"
+	"github.com/prometheus/node_exporter/history"
+)
+
+// apiResponse is the envelope of all API responses, following the Prometheus
+// HTTP API.
+type apiResponse struct {
+	Status string      `json:"status"`
+	Data   interface{} `json:"data,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+func respond(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, apiResponse{Status: "success", Data: data})
+}
+
+func respondError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiResponse{Status: "error", Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, code int, r apiResponse) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		log.Errorln("Couldn't marshal API response:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+// collectorsHandler lists all registered collectors with their configuration
+// and the outcome of their last update.
+func collectorsHandler(w http.ResponseWriter, r *http.Request) {
+	respond(w, collector.Status())
+}
+
+// serveAlerts lists the pending and firing alerts of the alerting rules.
+func (h *handler) serveAlerts(w http.ResponseWriter, r *http.Request) {
+	h.mtx.RLock()
+	m := h.alerts
+	h.mtx.RUnlock()
+	alerts := []alerting.Alert{}
+	if m != nil {
+		alerts = m.Alerts()
+	}
+	respond(w, struct {
+		Alerts []alerting.Alert `json:"alerts"`
+	}{alerts})
+}
+
+// queryRangeHandler serves the samples in the history of the series matching
+// the match[] selectors, between start and end, which default to the whole
+// history. With step, at most one sample per step is returned per series. A
+// nil store means the history is disabled.
+func queryRangeHandler(store *history.Store, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if store == nil {
+			respondError(w, http.StatusNotFound, fmt.Errorf("history is disabled, set --history.retention to enable it"))
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		if len(r.Form["match[]"]) == 0 {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("no match[] parameter provided"))
+			return
+		}
+		var selectors [][]*history.Matcher
+		for _, s := range r.Form["match[]"] {
+			matchers, err := history.ParseSelector(s)
+			if err != nil {
+				respondError(w, http.StatusBadRequest, err)
+				return
+			}
+			selectors = append(selectors, matchers)
+		}
+
+		end, err := parseTime(r.FormValue("end"), model.Now())
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid end: %s", err))
+			return
+		}
+		start, err := parseTime(r.FormValue("start"), end.Add(-retention))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid start: %s", err))
+			return
+		}
+		if end.Before(start) {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("end timestamp must not be before start time"))
+			return
+		}
+		step, err := parseDuration(r.FormValue("step"))
+		if err != nil {
+			respondError(w, http.StatusBadRequest, fmt.Errorf("invalid step: %s", err))
+			return
+		}
+
+		respond(w, struct {
+			ResultType model.ValueType `json:"resultType"`
+			Result     model.Matrix    `json:"result"`
+		}{
+			ResultType: model.ValMatrix,
+			Result:     store.Query(selectors, start, end, step),
+		})
+	}
+}
+
+// parseTime parses a Unix timestamp in seconds or an RFC 3339 time, returning
+// def for an empty string.
+func parseTime(s string, def model.Time) (model.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		return model.TimeFromUnixNano(int64(t * float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+	}
+	return model.TimeFromUnixNano(t.UnixNano()), nil
+}
+
+// parseDuration parses a duration in seconds or like 15s, returning 0 for an
+// empty string.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if d, err := strconv.ParseFloat(s, 64); err == nil && d >= 0 && !math.IsInf(d, 0) {
+		return time.Duration(d * float64(time.Second)), nil
+	}
+	d, err := model.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+	}
+	return time.Duration(d), nil
+}
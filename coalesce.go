@@ -0,0 +1,198 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/node_exporter/collector"
+)
+
+var (
+	scrapesCoalesced = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "node_exporter",
+		Name:      "scrapes_coalesced_total",
+		Help:      "Total number of scrapes served with the result of an overlapping scrape of the same collectors.",
+	})
+	scrapesRejected = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "node_exporter",
+		Name:      "scrapes_rejected_total",
+		Help:      "Total number of scrapes rejected because the limit of concurrent collections was reached.",
+	})
+	scrapesInProgress = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "node_exporter",
+		Name:      "scrapes_in_progress",
+		Help:      "Number of collections in progress.",
+	})
+)
+
+// errTooManyScrapes is returned when no collection slot became free in time.
+var errTooManyScrapes = errors.New("too many concurrent scrapes, try again later")
+
+// scrapeLimiter shares the result of a collection with the overlapping
+// requests for the same collectors, and limits the number of concurrent
+// collections.
+type scrapeLimiter struct {
+	// window is how long a finished collection is shared.
+	window time.Duration
+	// slots holds a token per running collection, nil if unlimited.
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	mtx   sync.Mutex
+	calls map[string]*gatherCall
+}
+
+// gatherCall is a collection in progress or finished within the window.
+type gatherCall struct {
+	done chan struct{}
+	// started is zero until the collection got a slot.
+	started  time.Time
+	finished time.Time
+	mfs      []*dto.MetricFamily
+	err      error
+}
+
+// newScrapeLimiter returns a scrapeLimiter running at most maxConcurrent
+// collections, unlimited if 0. Requests wait up to queueTimeout for a slot.
+func newScrapeLimiter(window time.Duration, maxConcurrent int, queueTimeout time.Duration) *scrapeLimiter {
+	l := &scrapeLimiter{
+		window:       window,
+		queueTimeout: queueTimeout,
+		calls:        map[string]*gatherCall{},
+	}
+	if maxConcurrent > 0 {
+		l.slots = make(chan struct{}, maxConcurrent)
+	}
+	return l
+}
+
+// gather returns the metrics of g, or the result of the collection of the
+// same key in progress or finished within the window. The returned metric
+// families are shared and must not be modified.
+func (l *scrapeLimiter) gather(key string, g prometheus.Gatherer) ([]*dto.MetricFamily, error) {
+	l.mtx.Lock()
+	now := time.Now()
+	for k, c := range l.calls {
+		if !c.finished.IsZero() && now.Sub(c.finished) > l.window {
+			delete(l.calls, k)
+		}
+	}
+	if c, ok := l.calls[key]; ok {
+		l.mtx.Unlock()
+		<-c.done
+		if c.err == errTooManyScrapes {
+			scrapesRejected.Inc()
+		} else {
+			scrapesCoalesced.Inc()
+		}
+		return c.mfs, c.err
+	}
+	c := &gatherCall{done: make(chan struct{})}
+	l.calls[key] = c
+	l.mtx.Unlock()
+
+	if !l.acquire() {
+		// Rejections aren't shared, a later request may get a slot.
+		l.mtx.Lock()
+		delete(l.calls, key)
+		l.mtx.Unlock()
+		c.err = errTooManyScrapes
+		close(c.done)
+		scrapesRejected.Inc()
+		return nil, c.err
+	}
+	l.mtx.Lock()
+	c.started = time.Now()
+	l.mtx.Unlock()
+	scrapesInProgress.Inc()
+	c.mfs, c.err = g.Gather()
+	scrapesInProgress.Dec()
+	l.release()
+
+	l.mtx.Lock()
+	c.finished = time.Now()
+	l.mtx.Unlock()
+	close(c.done)
+	return c.mfs, c.err
+}
+
+// stalled returns an error if a collection has been running for longer than
+// max.
+func (l *scrapeLimiter) stalled(max time.Duration) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+	now := time.Now()
+	for _, c := range l.calls {
+		if !c.started.IsZero() && c.finished.IsZero() && now.Sub(c.started) > max {
+			return fmt.Errorf("collection running for %s", now.Sub(c.started))
+		}
+	}
+	return nil
+}
+
+func (l *scrapeLimiter) acquire() bool {
+	if l.slots == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if l.queueTimeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (l *scrapeLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// scrapeKey identifies the collectors of a scrape of nc, with the given
+// filters, exclusions and collector options.
+func scrapeKey(nc *collector.NodeCollector, filters, excludes []string, options map[string]string) string {
+	filters = sortedCopy(filters)
+	excludes = sortedCopy(excludes)
+	opts := make([]string, 0, len(options))
+	for name, value := range options {
+		opts = append(opts, fmt.Sprintf("%s=%q", name, value))
+	}
+	sort.Strings(opts)
+	return fmt.Sprintf("%p collect=%s exclude=%s options=%s",
+		nc, strings.Join(filters, ","), strings.Join(excludes, ","), strings.Join(opts, ","))
+}
+
+func sortedCopy(s []string) []string {
+	c := append([]string(nil), s...)
+	sort.Strings(c)
+	return c
+}
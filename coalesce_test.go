@@ -0,0 +1,134 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/node_exporter/collector"
+)
+
+// blockingGatherer counts its gathers, which return once release is closed.
+type blockingGatherer struct {
+	calls   int32
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingGatherer() *blockingGatherer {
+	return &blockingGatherer{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (g *blockingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	atomic.AddInt32(&g.calls, 1)
+	g.started <- struct{}{}
+	<-g.release
+	return []*dto.MetricFamily{{}}, nil
+}
+
+func TestScrapeLimiterCoalesce(t *testing.T) {
+	l := newScrapeLimiter(time.Hour, 0, 0)
+	g := newBlockingGatherer()
+
+	var wg sync.WaitGroup
+	results := make([][]*dto.MetricFamily, 3)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = l.gather("key", g)
+		}(i)
+		if i == 0 {
+			<-g.started
+		}
+	}
+	// Let the other requests join the collection in progress.
+	time.Sleep(50 * time.Millisecond)
+	close(g.release)
+	wg.Wait()
+
+	if calls := atomic.LoadInt32(&g.calls); calls != 1 {
+		t.Errorf("want one collection for overlapping requests, got %d", calls)
+	}
+	for _, r := range results[1:] {
+		if len(r) != 1 || r[0] != results[0][0] {
+			t.Error("want all requests to share the result")
+		}
+	}
+
+	// Within the window the result is shared, other keys are collected.
+	l.gather("key", g)
+	l.gather("other", g)
+	if calls := atomic.LoadInt32(&g.calls); calls != 2 {
+		t.Errorf("want a new collection for another key only, got %d collections", calls)
+	}
+}
+
+func TestScrapeLimiterWindow(t *testing.T) {
+	l := newScrapeLimiter(0, 0, 0)
+	g := newBlockingGatherer()
+	close(g.release)
+	l.gather("key", g)
+	l.gather("key", g)
+	if calls := atomic.LoadInt32(&g.calls); calls != 2 {
+		t.Errorf("want finished collections not to be shared without a window, got %d collections", calls)
+	}
+}
+
+func TestScrapeLimiterConcurrency(t *testing.T) {
+	l := newScrapeLimiter(0, 1, 0)
+	g := newBlockingGatherer()
+	done := make(chan struct{})
+	go func() {
+		l.gather("first", g)
+		close(done)
+	}()
+	<-g.started
+
+	if _, err := l.gather("second", g); err != errTooManyScrapes {
+		t.Errorf("want %v without a free slot, got %v", errTooManyScrapes, err)
+	}
+
+	l.queueTimeout = time.Second
+	queued := make(chan error)
+	go func() {
+		_, err := l.gather("second", g)
+		queued <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(g.release)
+	<-done
+	if err := <-queued; err != nil {
+		t.Errorf("want the queued request to get the released slot, got %v", err)
+	}
+}
+
+func TestScrapeKey(t *testing.T) {
+	nc := &collector.NodeCollector{}
+	a := scrapeKey(nc, []string{"cpu", "meminfo"}, nil, map[string]string{"a.b": "1", "c.d": "2"})
+	b := scrapeKey(nc, []string{"meminfo", "cpu"}, nil, map[string]string{"c.d": "2", "a.b": "1"})
+	if a != b {
+		t.Errorf("want the same key regardless of the order, got %q and %q", a, b)
+	}
+	if c := scrapeKey(nc, []string{"cpu"}, []string{"meminfo"}, nil); c == a {
+		t.Error("want different keys for different collectors")
+	}
+	if d := scrapeKey(&collector.NodeCollector{}, []string{"cpu", "meminfo"}, nil, map[string]string{"a.b": "1", "c.d": "2"}); d == a {
+		t.Error("want different keys for different node collectors")
+	}
+}
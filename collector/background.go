@@ -0,0 +1,137 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	scrapeLastSuccessDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_last_success_timestamp_seconds"),
+		"node_exporter: Unix time of the last successful update of a background collector.",
+		[]string{"collector"},
+		nil,
+	)
+
+	errNoUpdate = errors.New("no background update finished yet")
+)
+
+// backgroundCollector updates a collector at a fixed interval, independent of
+// scrapes, and serves the result of the last update to every scrape.
+type backgroundCollector struct {
+	name     string
+	c        Collector
+	interval time.Duration
+	timeout  time.Duration
+	done     chan struct{}
+
+	mtx         sync.RWMutex
+	last        updateResult
+	lastSuccess time.Time
+	// updating is the start of the update in progress, zero if none is.
+	updating time.Time
+}
+
+// newBackgroundCollector wraps c and starts updating it in the background.
+func newBackgroundCollector(name string, c Collector, interval, timeout time.Duration) *backgroundCollector {
+	bc := &backgroundCollector{
+		name:     name,
+		c:        c,
+		interval: interval,
+		timeout:  timeout,
+		done:     make(chan struct{}),
+		last:     updateResult{err: errNoUpdate},
+	}
+	go bc.loop()
+	return bc
+}
+
+func (bc *backgroundCollector) loop() {
+	ticker := time.NewTicker(bc.interval)
+	defer ticker.Stop()
+	for {
+		bc.update()
+		select {
+		case <-ticker.C:
+		case <-bc.done:
+			return
+		}
+	}
+}
+
+// stop ends the background updates, the last result is still served.
+func (bc *backgroundCollector) stop() {
+	close(bc.done)
+}
+
+func (bc *backgroundCollector) update() {
+	bc.mtx.Lock()
+	bc.updating = time.Now()
+	bc.mtx.Unlock()
+
+	r := run(bc.name, bc.c, bc.timeout)
+	r.log(bc.name)
+
+	bc.mtx.Lock()
+	defer bc.mtx.Unlock()
+	bc.updating = time.Time{}
+	bc.last = r
+	if r.err == nil {
+		bc.lastSuccess = time.Now()
+	}
+}
+
+// Update implements Collector by sending the metrics of the last background
+// update.
+func (bc *backgroundCollector) Update(ch chan<- prometheus.Metric) error {
+	bc.mtx.RLock()
+	defer bc.mtx.RUnlock()
+	for _, m := range bc.last.metrics {
+		ch <- m
+	}
+	return bc.last.err
+}
+
+// result returns the last background update and the time of the last
+// successful one, zero if none succeeded yet.
+func (bc *backgroundCollector) result() (updateResult, time.Time) {
+	bc.mtx.RLock()
+	defer bc.mtx.RUnlock()
+	return bc.last, bc.lastSuccess
+}
+
+// Stalled returns an error if the update of a background collector has been
+// running for longer than max, e.g. because a collector without timeout hangs.
+func (n *NodeCollector) Stalled(max time.Duration) error {
+	now := time.Now()
+	for name, c := range n.Collectors {
+		bc, ok := c.(*backgroundCollector)
+		if !ok {
+			continue
+		}
+		bc.mtx.RLock()
+		updating := bc.updating
+		bc.mtx.RUnlock()
+		if !updating.IsZero() && now.Sub(updating) > max {
+			return fmt.Errorf("%s collector update running for %s", name, now.Sub(updating))
+		}
+	}
+	return nil
+}
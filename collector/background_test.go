@@ -0,0 +1,77 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type countingCollector struct {
+	updates int32
+}
+
+func (c *countingCollector) Update(ch chan<- prometheus.Metric) error {
+	atomic.AddInt32(&c.updates, 1)
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+func TestBackgroundCollector(t *testing.T) {
+	c := &countingCollector{}
+	bc := newBackgroundCollector("test_background", c, time.Hour, 0)
+	defer bc.stop()
+
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&c.updates) == 0 || bc.Update(make(chan prometheus.Metric, 1)) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("background collector didn't update")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	nc := &NodeCollector{Collectors: map[string]Collector{"test_background": bc}}
+	for i := 0; i < 3; i++ {
+		results, others := scrapeResults(t, nc)
+		if got := results["test_background"]["success"]; got != 1 {
+			t.Errorf("want success 1, got %v", got)
+		}
+		// The cached test metric and the last success timestamp.
+		if others != 2 {
+			t.Errorf("want 2 metrics, got %d", others)
+		}
+	}
+	if got := atomic.LoadInt32(&c.updates); got != 1 {
+		t.Errorf("want scrapes to be served from the cache, got %d updates", got)
+	}
+}
+
+func TestStalled(t *testing.T) {
+	bc := &backgroundCollector{name: "test_background"}
+	nc := &NodeCollector{Collectors: map[string]Collector{"test_background": bc}}
+	if err := nc.Stalled(time.Second); err != nil {
+		t.Errorf("want no stall without an update in progress, got %s", err)
+	}
+	bc.updating = time.Now()
+	if err := nc.Stalled(time.Second); err != nil {
+		t.Errorf("want no stall for a recent update, got %s", err)
+	}
+	bc.updating = time.Now().Add(-time.Minute)
+	if err := nc.Stalled(time.Second); err == nil {
+		t.Error("want a stall for an update running for a minute")
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+var (
+	scrapeBackoffDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_backoff_seconds"),
+		"node_exporter: Seconds until a failing collector is updated again, 0 if it isn't backing off.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeConsecutiveFailuresDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_consecutive_failures"),
+		"node_exporter: Number of consecutive failed updates of a collector.",
+		[]string{"collector"},
+		nil,
+	)
+
+	backoffFailures = kingpin.Flag(
+		"collector.backoff-failures",
+		"Number of consecutive failed updates after which a collector is skipped by scrapes, 0 disables backing off.",
+	).Default("3").Int()
+	backoffInitial = kingpin.Flag(
+		"collector.backoff-initial",
+		"How long a failing collector is skipped at first, doubled on every further failure.",
+	).Default("30s").Duration()
+	backoffMax = kingpin.Flag(
+		"collector.backoff-max",
+		"Maximum time a failing collector is skipped.",
+	).Default("10m").Duration()
+
+	errBackingOff = errors.New("skipped after consecutive failures")
+)
+
+// breaker backs off a collector after consecutive failed updates. Once the
+// backoff expired, a single update probes the collector: on success the
+// collector is updated on every scrape again, on failure the backoff doubles.
+type breaker struct {
+	failures int
+	initial  time.Duration
+	max      time.Duration
+
+	mtx         sync.Mutex
+	consecutive int
+	until       time.Time
+}
+
+// newBreaker returns a breaker configured by the flags, nil if backing off is
+// disabled.
+func newBreaker() *breaker {
+	if *backoffFailures <= 0 {
+		return nil
+	}
+	return &breaker{failures: *backoffFailures, initial: *backoffInitial, max: *backoffMax}
+}
+
+// allow returns whether the collector may be updated at now. Probing the
+// collector extends the backoff, so overlapping scrapes don't probe as well.
+func (b *breaker) allow(now time.Time) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.consecutive < b.failures {
+		return true
+	}
+	if now.Before(b.until) {
+		return false
+	}
+	b.until = now.Add(b.backoff())
+	return true
+}
+
+// record records the outcome of an update finished at now, and returns the
+// number of consecutive failures before it.
+func (b *breaker) record(err error, now time.Time) int {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	previous := b.consecutive
+	if err == nil {
+		b.consecutive = 0
+		b.until = time.Time{}
+		return previous
+	}
+	b.consecutive++
+	if b.consecutive >= b.failures {
+		b.until = now.Add(b.backoff())
+	}
+	return previous
+}
+
+// backoff returns how long the collector is skipped after the current number
+// of consecutive failures.
+func (b *breaker) backoff() time.Duration {
+	d := b.initial
+	for i := b.failures; i < b.consecutive && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+// state returns the number of consecutive failures and the remaining backoff
+// at now.
+func (b *breaker) state(now time.Time) (int, time.Duration) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	var remaining time.Duration
+	if b.consecutive >= b.failures && now.Before(b.until) {
+		remaining = b.until.Sub(now)
+	}
+	return b.consecutive, remaining
+}
+
+// runWithBreaker updates the named collector unless it is backing off. Once
+// the collector backs off, its failures are logged once per backoff instead
+// of on every scrape.
+func runWithBreaker(name string, c Collector, timeout time.Duration, b *breaker) updateResult {
+	if !b.allow(time.Now()) {
+		return updateResult{err: errBackingOff}
+	}
+	r := run(name, c, timeout)
+	previous := b.record(r.err, time.Now())
+	switch {
+	case r.err == nil && previous >= b.failures:
+		log.Infof("%s collector recovered after %d consecutive failures", name, previous)
+	case r.err != nil && previous+1 >= b.failures:
+		_, backoff := b.state(time.Now())
+		log.Warnf("%s collector failed %d times in a row, skipping it for %s: %s", name, previous+1, backoff, r.err)
+	default:
+		r.log(name)
+	}
+	return r
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &breaker{failures: 2, initial: time.Minute, max: 3 * time.Minute}
+	failed := errors.New("failed")
+	now := time.Unix(0, 0)
+
+	for _, step := range []struct {
+		after    time.Duration
+		err      error
+		allow    bool
+		failures int
+		backoff  time.Duration
+	}{
+		{err: failed, allow: true, failures: 1},
+		{err: failed, allow: true, failures: 2, backoff: time.Minute},
+		{after: 59 * time.Second, backoff: time.Second, failures: 2},
+		{after: time.Second, err: failed, allow: true, failures: 3, backoff: 2 * time.Minute},
+		{after: 2 * time.Minute, err: failed, allow: true, failures: 4, backoff: 3 * time.Minute},
+		{after: 3 * time.Minute, err: failed, allow: true, failures: 5, backoff: 3 * time.Minute},
+		{after: 3 * time.Minute, allow: true},
+		{err: failed, allow: true, failures: 1},
+	} {
+		now = now.Add(step.after)
+		allow := b.allow(now)
+		if allow {
+			b.record(step.err, now)
+		}
+		failures, backoff := b.state(now)
+		if allow != step.allow || failures != step.failures || backoff != step.backoff {
+			t.Fatalf("at %s: want allow %v, %d failures and backoff %s, got %v, %d and %s",
+				now.Sub(time.Unix(0, 0)), step.allow, step.failures, step.backoff, allow, failures, backoff)
+		}
+	}
+}
+
+// failingCollector fails every update and counts them.
+type failingCollector struct {
+	updates *int
+}
+
+func (c failingCollector) Update(ch chan<- prometheus.Metric) error {
+	*c.updates++
+	return errors.New("failed")
+}
+
+func TestCollectorBackoff(t *testing.T) {
+	updates := 0
+	nc := &NodeCollector{
+		Collectors: map[string]Collector{"test_failing": failingCollector{updates: &updates}},
+		breakers:   map[string]*breaker{"test_failing": {failures: 2, initial: time.Hour, max: time.Hour}},
+	}
+
+	var failures, backoff float64
+	for i := 0; i < 4; i++ {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			nc.Collect(ch)
+			close(ch)
+		}()
+		for m := range ch {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			switch m.Desc() {
+			case scrapeConsecutiveFailuresDesc:
+				failures = pb.GetGauge().GetValue()
+			case scrapeBackoffDesc:
+				backoff = pb.GetGauge().GetValue()
+			}
+		}
+	}
+	if updates != 2 {
+		t.Errorf("want the collector skipped after 2 failed updates, got %d updates", updates)
+	}
+	if failures != 2 || backoff <= 0 {
+		t.Errorf("want 2 consecutive failures and a backoff, got %v and %v", failures, backoff)
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+)
+
+var scrapeCollisionsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "scrape", "collector_collisions"),
+	"node_exporter: Number of metrics of a collector dropped in strict mode because they are invalid, weren't described or collide with other metrics.",
+	[]string{"collector"},
+	nil,
+)
+
+// checker verifies the metrics of collectors in strict mode. Described
+// collectors are registered with a pedantic registry when the checker is
+// created and may only send the metrics they describe. The metrics of other
+// collectors may not use the name of a described metric family. On every
+// scrape, the metrics of all collectors are checked like the pedantic
+// registry checks gathered metrics.
+type checker struct {
+	// described holds the descriptors of every described collector.
+	described map[string]map[string]bool
+	// families maps the described metric names to their collector.
+	families map[string]string
+}
+
+// describedOnly is a prometheus.Collector that only describes descs, used to
+// register the descriptors of a collector.
+type describedOnly []*prometheus.Desc
+
+func (d describedOnly) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range d {
+		ch <- desc
+	}
+}
+
+func (d describedOnly) Collect(ch chan<- prometheus.Metric) {}
+
+// newChecker registers the described collectors with a pedantic registry and
+// returns a checker for their metrics. It fails if a collector describes an
+// invalid descriptor, or one inconsistent with the descriptors of another
+// collector.
+func newChecker(collectors map[string]Collector) (*checker, error) {
+	c := &checker{
+		described: map[string]map[string]bool{},
+		families:  map[string]string{},
+	}
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	reg := prometheus.NewPedanticRegistry()
+	for _, name := range names {
+		descs := describe(collectors[name])
+		if descs == nil {
+			continue
+		}
+		if len(descs) > 0 {
+			if err := reg.Register(describedOnly(descs)); err != nil {
+				return nil, fmt.Errorf("strict mode: %s collector: %s", name, err)
+			}
+		}
+		c.described[name] = map[string]bool{}
+		for _, d := range descs {
+			c.described[name][d.String()] = true
+			c.families[descName(d)] = name
+		}
+	}
+	return c, nil
+}
+
+// family is the signature of a metric family sent in a scrape.
+type family struct {
+	collector  string
+	help       string
+	metricType dto.MetricType
+	labels     string
+}
+
+// scrapeChecker checks the metrics of all collectors of a single scrape.
+// Collectors must be checked one at a time, in the same order on every
+// scrape, so the same metrics win a collision.
+type scrapeChecker struct {
+	*checker
+	// families holds the metric families sent so far.
+	families map[string]family
+	// series holds the series sent so far.
+	series map[string]bool
+}
+
+func (c *checker) newScrape() *scrapeChecker {
+	return &scrapeChecker{
+		checker:  c,
+		families: map[string]family{},
+		series:   map[string]bool{},
+	}
+}
+
+// check returns the metrics of the named collector that pass the checks,
+// and the number of rejected ones. metrics is never modified.
+func (c *scrapeChecker) check(name string, metrics []prometheus.Metric) ([]prometheus.Metric, int) {
+	var (
+		passed   = make([]prometheus.Metric, 0, len(metrics))
+		rejected int
+	)
+	for _, m := range metrics {
+		if err := c.checkMetric(name, m); err != nil {
+			log.Warnf("%s collector: dropping metric %s: %s", name, m.Desc(), err)
+			rejected++
+			continue
+		}
+		passed = append(passed, m)
+	}
+	return passed, rejected
+}
+
+// checkMetric checks a single metric of the named collector and records its
+// family and series if it passes.
+func (c *scrapeChecker) checkMetric(name string, m prometheus.Metric) error {
+	d := m.Desc()
+	fqName := descName(d)
+	if descs, ok := c.described[name]; ok && !descs[d.String()] {
+		return fmt.Errorf("not described by the collector")
+	}
+	if owner, ok := c.checker.families[fqName]; ok && owner != name {
+		return fmt.Errorf("colliding with the %s collector", owner)
+	}
+
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return err
+	}
+	f := family{
+		collector:  name,
+		help:       descHelp(d),
+		metricType: metricType(pb),
+		labels:     labelNames(pb),
+	}
+	if seen, ok := c.families[fqName]; ok {
+		switch {
+		case seen.collector != name:
+			return fmt.Errorf("colliding with the %s collector", seen.collector)
+		case seen != f:
+			return fmt.Errorf("inconsistent with the other metrics of %s", fqName)
+		}
+	}
+	series := fqName + "{" + labelPairs(pb) + "}"
+	if c.series[series] {
+		return fmt.Errorf("duplicate series %s", series)
+	}
+	c.families[fqName] = f
+	c.series[series] = true
+	return nil
+}
+
+func metricType(pb *dto.Metric) dto.MetricType {
+	switch {
+	case pb.Counter != nil:
+		return dto.MetricType_COUNTER
+	case pb.Summary != nil:
+		return dto.MetricType_SUMMARY
+	case pb.Histogram != nil:
+		return dto.MetricType_HISTOGRAM
+	case pb.Untyped != nil:
+		return dto.MetricType_UNTYPED
+	}
+	return dto.MetricType_GAUGE
+}
+
+// labelNames returns the label names of pb, which are sorted by Write.
+func labelNames(pb *dto.Metric) string {
+	names := make([]string, 0, len(pb.Label))
+	for _, l := range pb.Label {
+		names = append(names, l.GetName())
+	}
+	return strings.Join(names, ",")
+}
+
+func labelPairs(pb *dto.Metric) string {
+	pairs := make([]string, 0, len(pb.Label))
+	for _, l := range pb.Label {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// describe returns the descriptors of c, nil if it doesn't describe its
+// metrics.
+func describe(c Collector) []*prometheus.Desc {
+	if bc, ok := c.(*backgroundCollector); ok {
+		c = bc.c
+	}
+	dc, ok := c.(DescribedCollector)
+	if !ok {
+		return nil
+	}
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		dc.Describe(ch)
+		close(ch)
+	}()
+	descs := []*prometheus.Desc{}
+	for d := range ch {
+		descs = append(descs, d)
+	}
+	return descs
+}
+
+// descName returns the fully-qualified name of d, which the client library
+// only exposes through String.
+func descName(d *prometheus.Desc) string {
+	name, _ := parseDesc(d)
+	return name
+}
+
+// descHelp returns the help string of d.
+func descHelp(d *prometheus.Desc) string {
+	_, help := parseDesc(d)
+	return help
+}
+
+func parseDesc(d *prometheus.Desc) (name, help string) {
+	const prefix = "Desc{fqName: "
+	s := d.String()
+	if !strings.HasPrefix(s, prefix) {
+		return "", ""
+	}
+	if _, err := fmt.Sscanf(s[len(prefix):], "%q, help: %q", &name, &help); err != nil {
+		return name, ""
+	}
+	return name, help
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var otherDesc = prometheus.NewDesc("node_test_other", "Other test metric.", nil, nil)
+
+// describedCollector describes testDesc but sends extra metrics as well.
+type describedCollector struct {
+	extra []*prometheus.Desc
+}
+
+func (c describedCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- testDesc
+}
+
+func (c describedCollector) Update(ch chan<- prometheus.Metric) error {
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, 1)
+	for _, d := range c.extra {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, 1)
+	}
+	return nil
+}
+
+// undescribedCollector sends a metric for every desc.
+type undescribedCollector struct {
+	descs []*prometheus.Desc
+}
+
+func (c undescribedCollector) Update(ch chan<- prometheus.Metric) error {
+	for _, d := range c.descs {
+		ch <- prometheus.MustNewConstMetric(d, prometheus.GaugeValue, 1)
+	}
+	return nil
+}
+
+func TestStrictMode(t *testing.T) {
+	collidingDesc := prometheus.NewDesc("node_test_value", "Colliding test metric.", nil, prometheus.Labels{"file": "test.prom"})
+	collectors := map[string]Collector{
+		"test_described":   describedCollector{extra: []*prometheus.Desc{otherDesc}},
+		"test_undescribed": undescribedCollector{descs: []*prometheus.Desc{otherDesc, collidingDesc}},
+	}
+	checker, err := newChecker(collectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := &NodeCollector{Collectors: collectors, checker: checker}
+
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(nc); err != nil {
+		t.Fatal(err)
+	}
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("want strict mode to keep the scrape consistent, got %s", err)
+	}
+
+	collisions := map[string]float64{}
+	var values []*dto.Metric
+	for _, mf := range mfs {
+		switch mf.GetName() {
+		case "node_scrape_collector_collisions":
+			for _, m := range mf.Metric {
+				collisions[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+			}
+		case "node_test_value":
+			values = mf.Metric
+		}
+	}
+	if want := map[string]float64{"test_described": 1, "test_undescribed": 1}; len(collisions) != 2 ||
+		collisions["test_described"] != want["test_described"] || collisions["test_undescribed"] != want["test_undescribed"] {
+		t.Errorf("want collisions %v, got %v", want, collisions)
+	}
+	if len(values) != 1 || len(values[0].GetLabel()) != 0 {
+		t.Errorf("want only the described node_test_value, got %v", values)
+	}
+}
+
+func TestStrictModeRegistration(t *testing.T) {
+	inconsistentDesc := prometheus.NewDesc("node_test_value", "Inconsistent test metric.", []string{"device"}, nil)
+	collectors := map[string]Collector{
+		"test_described": describedCollector{},
+		"test_other":     describedOnlyCollector{descs: []*prometheus.Desc{inconsistentDesc}},
+	}
+	if _, err := newChecker(collectors); err == nil {
+		t.Error("want an error for collectors describing inconsistent descriptors")
+	}
+
+	collectors["test_other"] = describedOnlyCollector{descs: []*prometheus.Desc{otherDesc}}
+	if _, err := newChecker(collectors); err != nil {
+		t.Errorf("want consistent descriptors registered, got %s", err)
+	}
+}
+
+func TestStrictModeUndescribed(t *testing.T) {
+	labeledDesc := prometheus.NewDesc("node_test_other", "Other test metric.", nil, prometheus.Labels{"device": "sda"})
+	collectors := map[string]Collector{
+		"test_a": undescribedCollector{descs: []*prometheus.Desc{otherDesc, otherDesc}},
+		"test_b": undescribedCollector{descs: []*prometheus.Desc{labeledDesc}},
+	}
+	checker, err := newChecker(collectors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc := &NodeCollector{Collectors: collectors, checker: checker}
+
+	for i := 0; i < 3; i++ {
+		reg := prometheus.NewRegistry()
+		if err := reg.Register(nc); err != nil {
+			t.Fatal(err)
+		}
+		mfs, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("want strict mode to keep the scrape consistent, got %s", err)
+		}
+		collisions := map[string]float64{}
+		for _, mf := range mfs {
+			if mf.GetName() == "node_scrape_collector_collisions" {
+				for _, m := range mf.Metric {
+					collisions[m.GetLabel()[0].GetValue()] = m.GetGauge().GetValue()
+				}
+			}
+		}
+		// test_a sends a duplicate series, test_b collides with test_a.
+		if collisions["test_a"] != 1 || collisions["test_b"] != 1 {
+			t.Errorf("want one collision for both collectors, got %v", collisions)
+		}
+	}
+}
+
+// describedOnlyCollector describes descs but sends nothing.
+type describedOnlyCollector struct {
+	descs []*prometheus.Desc
+}
+
+func (c describedOnlyCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d
+	}
+}
+
+func (c describedOnlyCollector) Update(ch chan<- prometheus.Metric) error {
+	return nil
+}
+
+func TestDescName(t *testing.T) {
+	if got, want := descName(testDesc), "node_test_value"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -15,11 +15,15 @@
 package collector
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/log"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -40,6 +44,43 @@ var (
 		[]string{"collector"},
 		nil,
 	)
+	scrapeTimeoutDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_timeout"),
+		"node_exporter: Whether a collector timed out.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeSeriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_series"),
+		"node_exporter: Number of series sent by a collector, before applying its series limit.",
+		[]string{"collector"},
+		nil,
+	)
+	scrapeSeriesLimitExceededDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "scrape", "collector_series_limit_exceeded"),
+		"node_exporter: Whether a collector sent more series than its series limit.",
+		[]string{"collector"},
+		nil,
+	)
+)
+
+var (
+	collectorDefaultTimeout = kingpin.Flag(
+		"collector.timeout",
+		"Timeout for a single collector update, 0 disables the timeout. Can be overridden per collector with --collector.<name>.timeout.",
+	).Default("0s").Duration()
+	collectorDefaultSeriesLimit = kingpin.Flag(
+		"collector.series-limit",
+		"Maximum number of series of a single collector, 0 disables the limit. Can be overridden per collector with --collector.<name>.series-limit.",
+	).Default("0").Int()
+	seriesLimitAction = kingpin.Flag(
+		"collector.series-limit-action",
+		"What to do with the series of a collector over its limit: truncate them to the limit or drop all of them.",
+	).Default("truncate").Enum("truncate", "drop")
+	strictMode = kingpin.Flag(
+		"collector.strict",
+		"Register the collectors describing their metrics with a pedantic registry, and drop invalid, undescribed or colliding metrics of every collector.",
+	).Default("false").Bool()
 )
 
 const (
@@ -48,8 +89,12 @@ const (
 )
 
 var (
-	factories      = make(map[string]func() (Collector, error))
-	collectorState = make(map[string]*bool)
+	factories            = make(map[string]func() (Collector, error))
+	collectorState       = make(map[string]*bool)
+	collectorDefaults    = make(map[string]bool)
+	collectorTimeout     = make(map[string]*time.Duration)
+	collectorInterval    = make(map[string]*time.Duration)
+	collectorSeriesLimit = make(map[string]*int)
 )
 
 func registerCollector(collector string, isDefaultEnabled bool, factory func() (Collector, error)) {
@@ -66,17 +111,159 @@ func registerCollector(collector string, isDefaultEnabled bool, factory func() (
 
 	flag := kingpin.Flag(flagName, flagHelp).Default(defaultValue).Bool()
 	collectorState[collector] = flag
+	collectorDefaults[collector] = isDefaultEnabled
+
+	timeoutFlagName := fmt.Sprintf("collector.%s.timeout", collector)
+	timeoutFlagHelp := fmt.Sprintf("Timeout for the %s collector, overrides --collector.timeout if set.", collector)
+	collectorTimeout[collector] = kingpin.Flag(timeoutFlagName, timeoutFlagHelp).Default("0s").Duration()
+
+	intervalFlagName := fmt.Sprintf("collector.%s.interval", collector)
+	intervalFlagHelp := fmt.Sprintf("Update the %s collector in the background at this interval and serve the last result to scrapes, 0 updates it on every scrape.", collector)
+	collectorInterval[collector] = kingpin.Flag(intervalFlagName, intervalFlagHelp).Default("0s").Duration()
+
+	seriesLimitFlagName := fmt.Sprintf("collector.%s.series-limit", collector)
+	seriesLimitFlagHelp := fmt.Sprintf("Maximum number of series of the %s collector, overrides --collector.series-limit if set.", collector)
+	collectorSeriesLimit[collector] = kingpin.Flag(seriesLimitFlagName, seriesLimitFlagHelp).Default("0").Int()
 
 	factories[collector] = factory
 }
 
 // NodeCollector implements the prometheus.Collector interface.
-type nodeCollector struct {
+type NodeCollector struct {
 	Collectors map[string]Collector
+	// Update timeouts and series limits per collector, taken from the flags
+	// on creation.
+	timeouts     map[string]time.Duration
+	seriesLimits map[string]int
+	// dropOverLimit drops all series of a collector over its series limit
+	// instead of truncating them.
+	dropOverLimit bool
+	// checker verifies the collected metrics in strict mode, nil otherwise.
+	checker *checker
+	// relabelRules are applied to the metrics of each collector.
+	relabelRules map[string][]*relabelRule
+	// breakers back off the failing collectors updated on scrape. They are
+	// shared by all NodeCollectors derived from the same instances.
+	breakers map[string]*breaker
+}
+
+// NewNodeCollector creates a new NodeCollector holding one instance of every
+// enabled collector, or only of the given ones if filters are passed. The
+// instances are meant to be created once and reused across scrapes.
+func NewNodeCollector(filters ...string) (*NodeCollector, error) {
+	f, err := parseFilters(filters)
+	if err != nil {
+		return nil, err
+	}
+	n := &NodeCollector{
+		Collectors:    make(map[string]Collector),
+		timeouts:      make(map[string]time.Duration),
+		seriesLimits:  make(map[string]int),
+		dropOverLimit: *seriesLimitAction == "drop",
+		breakers:      make(map[string]*breaker),
+	}
+	for key, enabled := range collectorState {
+		if !*enabled || (len(f) > 0 && !f[key]) {
+			continue
+		}
+		collector, err := factories[key]()
+		if err != nil {
+			n.Close()
+			return nil, err
+		}
+		timeout := timeoutFor(key)
+		if interval := intervalFor(key); interval > 0 {
+			collector = newBackgroundCollector(key, collector, interval, timeout)
+		} else if b := newBreaker(); b != nil {
+			n.breakers[key] = b
+		}
+		n.Collectors[key] = collector
+		n.timeouts[key] = timeout
+		n.seriesLimits[key] = seriesLimitFor(key)
+	}
+	if *strictMode {
+		if n.checker, err = newChecker(n.Collectors); err != nil {
+			n.Close()
+			return nil, err
+		}
+	}
+	return n, nil
 }
 
-// NewNodeCollector creates a new NodeCollector
-func NewNodeCollector(filters ...string) (*nodeCollector, error) {
+// Filter returns a NodeCollector running only the given collectors. It shares
+// the collector instances of n, no new collectors are created. Without
+// filters, n itself is returned.
+func (n *NodeCollector) Filter(filters ...string) (*NodeCollector, error) {
+	if len(filters) == 0 {
+		return n, nil
+	}
+	filtered := n.withCollectors(make(map[string]Collector))
+	for _, filter := range filters {
+		if _, exist := factories[filter]; !exist {
+			return nil, fmt.Errorf("missing collector: %s", filter)
+		}
+		c, ok := n.Collectors[filter]
+		if !ok {
+			return nil, fmt.Errorf("disabled collector: %s", filter)
+		}
+		filtered.Collectors[filter] = c
+	}
+	return filtered, nil
+}
+
+// Exclude returns a NodeCollector running all collectors of n except the
+// given ones. Excluding a known but disabled collector is not an error, so the
+// same exclusions can be used for nodes with different collectors enabled.
+func (n *NodeCollector) Exclude(excludes ...string) (*NodeCollector, error) {
+	if len(excludes) == 0 {
+		return n, nil
+	}
+	excluded := make(map[string]bool, len(excludes))
+	for _, exclude := range excludes {
+		if _, exist := factories[exclude]; !exist {
+			return nil, fmt.Errorf("missing collector: %s", exclude)
+		}
+		excluded[exclude] = true
+	}
+	filtered := n.withCollectors(make(map[string]Collector))
+	for name, c := range n.Collectors {
+		if !excluded[name] {
+			filtered.Collectors[name] = c
+		}
+	}
+	return filtered, nil
+}
+
+// withCollectors returns a NodeCollector running collectors with the
+// settings of n.
+func (n *NodeCollector) withCollectors(collectors map[string]Collector) *NodeCollector {
+	return &NodeCollector{
+		Collectors:    collectors,
+		timeouts:      n.timeouts,
+		seriesLimits:  n.seriesLimits,
+		dropOverLimit: n.dropOverLimit,
+		checker:       n.checker,
+		relabelRules:  n.relabelRules,
+		breakers:      n.breakers,
+	}
+}
+
+// Close stops the background updates of the collectors and closes the
+// collectors implementing ClosingCollector. It must only be called on a
+// NodeCollector returned by NewNodeCollector, once it isn't used anymore.
+func (n *NodeCollector) Close() {
+	for _, c := range n.Collectors {
+		if bc, ok := c.(*backgroundCollector); ok {
+			bc.stop()
+			c = bc.c
+		}
+		if cc, ok := c.(ClosingCollector); ok {
+			cc.Close()
+		}
+	}
+}
+
+func parseFilters(filters []string) (map[string]bool, error) {
 	f := make(map[string]bool)
 	for _, filter := range filters {
 		enabled, exist := collectorState[filter]
@@ -88,55 +275,306 @@ func NewNodeCollector(filters ...string) (*nodeCollector, error) {
 		}
 		f[filter] = true
 	}
-	collectors := make(map[string]Collector)
-	for key, enabled := range collectorState {
-		if *enabled {
-			collector, err := factories[key]()
-			if err != nil {
-				return nil, err
-			}
-			if len(f) == 0 || f[key] {
-				collectors[key] = collector
-			}
-		}
-	}
-	return &nodeCollector{Collectors: collectors}, nil
+	return f, nil
 }
 
-// Describe implements the prometheus.Collector interface.
-func (n nodeCollector) Describe(ch chan<- *prometheus.Desc) {
+// Describe implements the prometheus.Collector interface. In strict mode, the
+// descriptors of all collectors implementing DescribedCollector are sent as
+// well, so the registry checks them for consistency.
+func (n NodeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- scrapeDurationDesc
 	ch <- scrapeSuccessDesc
+	ch <- scrapeTimeoutDesc
+	ch <- scrapeLastSuccessDesc
+	ch <- scrapeSeriesDesc
+	ch <- scrapeSeriesLimitExceededDesc
+	ch <- scrapeBackoffDesc
+	ch <- scrapeConsecutiveFailuresDesc
+	if n.checker == nil {
+		return
+	}
+	ch <- scrapeCollisionsDesc
+	for _, c := range n.Collectors {
+		for _, d := range describe(c) {
+			ch <- d
+		}
+	}
 }
 
 // Collect implements the prometheus.Collector interface.
-func (n nodeCollector) Collect(ch chan<- prometheus.Metric) {
+func (n NodeCollector) Collect(ch chan<- prometheus.Metric) {
+	if n.checker != nil {
+		n.collectStrict(ch)
+		return
+	}
 	wg := sync.WaitGroup{}
 	wg.Add(len(n.Collectors))
 	for name, c := range n.Collectors {
 		go func(name string, c Collector) {
-			execute(name, c, ch)
+			r, lastSuccess := n.execute(name, c)
+			n.send(name, r, lastSuccess, nil, ch)
 			wg.Done()
 		}(name, c)
 	}
 	wg.Wait()
 }
 
-func execute(name string, c Collector, ch chan<- prometheus.Metric) {
+// collectStrict updates the collectors concurrently like Collect, but checks
+// and sends their metrics one collector at a time, ordered by name, so the
+// same metrics win a collision on every scrape.
+func (n NodeCollector) collectStrict(ch chan<- prometheus.Metric) {
+	names := make([]string, 0, len(n.Collectors))
+	for name := range n.Collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]updateResult, len(names))
+	lastSuccess := make([]time.Time, len(names))
+	wg := sync.WaitGroup{}
+	wg.Add(len(names))
+	for i, name := range names {
+		go func(i int, name string) {
+			results[i], lastSuccess[i] = n.execute(name, n.Collectors[name])
+			wg.Done()
+		}(i, name)
+	}
+	wg.Wait()
+
+	sc := n.checker.newScrape()
+	for i, name := range names {
+		n.send(name, results[i], lastSuccess[i], sc, ch)
+	}
+}
+
+// execute updates the named collector, or takes its last background update,
+// and returns the result together with the time of the last successful
+// background update. Collectors backing off after consecutive failures are
+// skipped.
+func (n NodeCollector) execute(name string, c Collector) (updateResult, time.Time) {
+	if bc, ok := c.(*backgroundCollector); ok {
+		return bc.result()
+	}
+	if b := n.breakers[name]; b != nil {
+		return runWithBreaker(name, c, n.timeouts[name], b), time.Time{}
+	}
+	r := run(name, c, n.timeouts[name])
+	r.log(name)
+	return r, time.Time{}
+}
+
+// send sends the result of the update of the named collector, checked by sc
+// in strict mode.
+func (n NodeCollector) send(name string, r updateResult, lastSuccess time.Time, sc *scrapeChecker, ch chan<- prometheus.Metric) {
+	if sc != nil {
+		var rejected int
+		r.metrics, rejected = sc.check(name, r.metrics)
+		ch <- prometheus.MustNewConstMetric(scrapeCollisionsDesc, prometheus.GaugeValue, float64(rejected), name)
+	}
+	r.metrics = n.relabelMetrics(name, r.metrics)
+	r.metrics = n.limit(name, r.metrics, ch)
+	r.send(name, ch)
+	if !lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(scrapeLastSuccessDesc, prometheus.GaugeValue, float64(lastSuccess.UnixNano())/1e9, name)
+	}
+	if b := n.breakers[name]; b != nil {
+		failures, backoff := b.state(time.Now())
+		ch <- prometheus.MustNewConstMetric(scrapeBackoffDesc, prometheus.GaugeValue, backoff.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(scrapeConsecutiveFailuresDesc, prometheus.GaugeValue, float64(failures), name)
+	}
+}
+
+// limit applies the series limit of the named collector to metrics and sends
+// the series metrics of the collector. metrics is never modified.
+func (n NodeCollector) limit(name string, metrics []prometheus.Metric, ch chan<- prometheus.Metric) []prometheus.Metric {
+	var exceeded float64
+	series := len(metrics)
+	if limit := n.seriesLimits[name]; limit > 0 && series > limit {
+		exceeded = 1
+		if n.dropOverLimit {
+			log.Warnf("%s collector: dropping all %d series, over the limit of %d", name, series, limit)
+			metrics = nil
+		} else {
+			log.Warnf("%s collector: truncating %d series to the limit of %d", name, series, limit)
+			metrics = truncate(metrics, limit)
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeSeriesDesc, prometheus.GaugeValue, float64(series), name)
+	ch <- prometheus.MustNewConstMetric(scrapeSeriesLimitExceededDesc, prometheus.GaugeValue, exceeded, name)
+	return metrics
+}
+
+var (
+	errStillRunning = errors.New("skipped, the update that timed out before is still running")
+
+	// abandonedMtx protects abandoned.
+	abandonedMtx sync.Mutex
+	// abandoned holds the collectors with an update that timed out and
+	// hasn't returned yet. They aren't updated again until it returns, so a
+	// hanging collector doesn't pile up goroutines.
+	abandoned = map[string]bool{}
+)
+
+// truncate returns the first limit metrics ordered by descriptor and label
+// values, so the same series are kept on every scrape whatever order the
+// collector sends them in. metrics is never modified.
+func truncate(metrics []prometheus.Metric, limit int) []prometheus.Metric {
+	type keyed struct {
+		key    string
+		metric prometheus.Metric
+	}
+	sorted := make([]keyed, 0, len(metrics))
+	for _, m := range metrics {
+		key := m.Desc().String()
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err == nil {
+			key += labelPairs(pb)
+		}
+		sorted = append(sorted, keyed{key: key, metric: m})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].key < sorted[j].key })
+
+	truncated := make([]prometheus.Metric, limit)
+	for i := range truncated {
+		truncated[i] = sorted[i].metric
+	}
+	return truncated
+}
+
+// updateResult holds the outcome of a single collector update.
+type updateResult struct {
+	metrics  []prometheus.Metric
+	duration time.Duration
+	err      error
+	timedOut bool
+}
+
+// run updates the named collector within timeout, if non-zero, and records
+// the outcome.
+func run(name string, c Collector, timeout time.Duration) updateResult {
+	ctx, cancel := context.WithCancel(context.Background())
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
+	defer cancel()
+
+	abandonedMtx.Lock()
+	skip := abandoned[name]
+	abandonedMtx.Unlock()
+	if skip {
+		r := updateResult{err: errStillRunning}
+		recordRun(name, r)
+		return r
+	}
+
 	begin := time.Now()
-	err := c.Update(ch)
-	duration := time.Since(begin)
-	var success float64
+	metrics, err := update(ctx, name, c)
+	r := updateResult{
+		metrics:  metrics,
+		duration: time.Since(begin),
+		err:      err,
+		timedOut: err != nil && ctx.Err() == context.DeadlineExceeded,
+	}
 
-	if err != nil {
-		log.Errorf("ERROR: %s collector failed after %fs: %s", name, duration.Seconds(), err)
-		success = 0
-	} else {
-		log.Debugf("OK: %s collector succeeded after %fs.", name, duration.Seconds())
+	recordRun(name, r)
+	return r
+}
+
+// log logs the outcome of the update of the named collector.
+func (r updateResult) log(name string) {
+	switch {
+	case r.timedOut:
+		log.Errorf("ERROR: %s collector timed out after %fs", name, r.duration.Seconds())
+	case r.err != nil:
+		log.Errorf("ERROR: %s collector failed after %fs: %s", name, r.duration.Seconds(), r.err)
+	default:
+		log.Debugf("OK: %s collector succeeded after %fs.", name, r.duration.Seconds())
+	}
+}
+
+// send sends the metrics of the update followed by the scrape metrics of the
+// named collector.
+func (r updateResult) send(name string, ch chan<- prometheus.Metric) {
+	var success, timedOut float64
+	if r.err == nil {
 		success = 1
 	}
-	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, duration.Seconds(), name)
+	if r.timedOut {
+		timedOut = 1
+	}
+	for _, m := range r.metrics {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeDurationDesc, prometheus.GaugeValue, r.duration.Seconds(), name)
 	ch <- prometheus.MustNewConstMetric(scrapeSuccessDesc, prometheus.GaugeValue, success, name)
+	ch <- prometheus.MustNewConstMetric(scrapeTimeoutDesc, prometheus.GaugeValue, timedOut, name)
+}
+
+// timeoutFor returns the update timeout of the named collector, falling back
+// to the global --collector.timeout.
+func timeoutFor(name string) time.Duration {
+	if timeout, ok := collectorTimeout[name]; ok && *timeout > 0 {
+		return *timeout
+	}
+	return *collectorDefaultTimeout
+}
+
+// seriesLimitFor returns the series limit of the named collector, falling
+// back to the global --collector.series-limit.
+func seriesLimitFor(name string) int {
+	if limit, ok := collectorSeriesLimit[name]; ok && *limit > 0 {
+		return *limit
+	}
+	return *collectorDefaultSeriesLimit
+}
+
+// intervalFor returns the background update interval of the named collector,
+// 0 if it is updated on every scrape.
+func intervalFor(name string) time.Duration {
+	if interval, ok := collectorInterval[name]; ok {
+		return *interval
+	}
+	return 0
+}
+
+// update runs a single collector update of the named collector and buffers the
+// metrics it sends. If ctx is done before the update returns, the update is
+// abandoned: its metrics are discarded and it is left to finish in the
+// background, recorded in abandoned until then.
+func update(ctx context.Context, name string, c Collector) ([]prometheus.Metric, error) {
+	metricCh := make(chan prometheus.Metric)
+	errCh := make(chan error, 1)
+	go func() {
+		if cc, ok := c.(ContextCollector); ok {
+			errCh <- cc.UpdateContext(ctx, metricCh)
+		} else {
+			errCh <- c.Update(metricCh)
+		}
+		close(metricCh)
+	}()
+
+	var metrics []prometheus.Metric
+	for {
+		select {
+		case m, ok := <-metricCh:
+			if !ok {
+				return metrics, <-errCh
+			}
+			metrics = append(metrics, m)
+		case <-ctx.Done():
+			abandonedMtx.Lock()
+			abandoned[name] = true
+			abandonedMtx.Unlock()
+			go func() {
+				for range metricCh {
+				}
+				abandonedMtx.Lock()
+				delete(abandoned, name)
+				abandonedMtx.Unlock()
+			}()
+			return nil, ctx.Err()
+		}
+	}
 }
 
 // Collector is the interface a collector has to implement.
@@ -145,6 +583,32 @@ type Collector interface {
 	Update(ch chan<- prometheus.Metric) error
 }
 
+// DescribedCollector is implemented by collectors that know the descriptors of
+// all metrics they send ahead of time. Collectors with metric names or labels
+// only known at runtime, like textfile, don't implement it.
+type DescribedCollector interface {
+	Collector
+	// Send the descriptors of all metrics sent by Update.
+	Describe(ch chan<- *prometheus.Desc)
+}
+
+// ContextCollector is implemented by collectors that can stop an update early
+// when its context is cancelled, e.g. because the collector timed out.
+type ContextCollector interface {
+	Collector
+	// Like Update, but gives up once ctx is done.
+	UpdateContext(ctx context.Context, ch chan<- prometheus.Metric) error
+}
+
+// ClosingCollector is implemented by collectors holding resources across
+// updates, like goroutines following files, that must be released once the
+// collector isn't used anymore.
+type ClosingCollector interface {
+	Collector
+	// Release the resources of the collector, Update isn't called anymore.
+	Close()
+}
+
 type typedDesc struct {
 	desc      *prometheus.Desc
 	valueType prometheus.ValueType
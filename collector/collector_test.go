@@ -0,0 +1,306 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var testDesc = prometheus.NewDesc("node_test_value", "Test metric.", nil, nil)
+
+type testCollector struct {
+	delay time.Duration
+}
+
+func (c testCollector) Update(ch chan<- prometheus.Metric) error {
+	time.Sleep(c.delay)
+	ch <- prometheus.MustNewConstMetric(testDesc, prometheus.GaugeValue, 1)
+	return nil
+}
+
+// scrapeResults collects the node collector and returns the value of the
+// scrape meta metrics per collector, plus the number of other metrics.
+func scrapeResults(t *testing.T, nc *NodeCollector) (map[string]map[string]float64, int) {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(ch)
+		close(ch)
+	}()
+
+	results := map[string]map[string]float64{}
+	others := 0
+	for m := range ch {
+		var name string
+		switch m.Desc() {
+		case scrapeSuccessDesc:
+			name = "success"
+		case scrapeTimeoutDesc:
+			name = "timeout"
+		case scrapeDurationDesc, scrapeSeriesDesc, scrapeSeriesLimitExceededDesc:
+			continue
+		default:
+			others++
+			continue
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		collector := pb.GetLabel()[0].GetValue()
+		if results[collector] == nil {
+			results[collector] = map[string]float64{}
+		}
+		results[collector][name] = pb.GetGauge().GetValue()
+	}
+	return results, others
+}
+
+func TestCollectorTimeout(t *testing.T) {
+	nc := &NodeCollector{
+		Collectors: map[string]Collector{
+			"test_fast": testCollector{},
+			"test_slow": testCollector{delay: time.Second},
+		},
+		timeouts: map[string]time.Duration{"test_slow": 50 * time.Millisecond},
+	}
+
+	begin := time.Now()
+	results, others := scrapeResults(t, nc)
+	if d := time.Since(begin); d >= time.Second {
+		t.Errorf("collect took %s, want less than the slow collector's delay", d)
+	}
+
+	want := map[string]map[string]float64{
+		"test_fast": {"success": 1, "timeout": 0},
+		"test_slow": {"success": 0, "timeout": 1},
+	}
+	for collector, values := range want {
+		for name, v := range values {
+			if got := results[collector][name]; got != v {
+				t.Errorf("%s %s: want %v, got %v", collector, name, v, got)
+			}
+		}
+	}
+	if others != 1 {
+		t.Errorf("want only the metric of the fast collector, got %d metrics", others)
+	}
+}
+
+// blockingCollector blocks in Update until release is closed.
+type blockingCollector struct {
+	release chan struct{}
+	updates *int32
+}
+
+func (c blockingCollector) Update(ch chan<- prometheus.Metric) error {
+	atomic.AddInt32(c.updates, 1)
+	<-c.release
+	return nil
+}
+
+func TestAbandonedUpdate(t *testing.T) {
+	defer delete(lastRuns, "test_blocking")
+	var updates int32
+	c := blockingCollector{release: make(chan struct{}), updates: &updates}
+
+	if r := run("test_blocking", c, 10*time.Millisecond); !r.timedOut {
+		t.Fatalf("want the first update to time out, got %+v", r)
+	}
+	for i := 0; i < 3; i++ {
+		if r := run("test_blocking", c, 10*time.Millisecond); r.err != errStillRunning {
+			t.Errorf("want the update skipped while the first one runs, got %+v", r)
+		}
+	}
+	if got := atomic.LoadInt32(&updates); got != 1 {
+		t.Errorf("want a single running update, got %d", got)
+	}
+
+	close(c.release)
+	for i := 0; ; i++ {
+		abandonedMtx.Lock()
+		running := abandoned["test_blocking"]
+		abandonedMtx.Unlock()
+		if !running {
+			break
+		}
+		if i == 100 {
+			t.Fatal("abandoned update still recorded after it returned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r := run("test_blocking", c, 10*time.Millisecond); r.err != nil {
+		t.Errorf("want the collector updated again once the first update returned, got %+v", r)
+	}
+}
+
+func TestNodeCollectorFilter(t *testing.T) {
+	factories["test_enabled"] = func() (Collector, error) { return testCollector{}, nil }
+	factories["test_disabled"] = func() (Collector, error) { return testCollector{}, nil }
+	defer delete(factories, "test_enabled")
+	defer delete(factories, "test_disabled")
+
+	c := &testCollector{}
+	nc := &NodeCollector{Collectors: map[string]Collector{"test_enabled": c}}
+
+	filtered, err := nc.Filter("test_enabled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filtered.Collectors["test_enabled"]; got != c {
+		t.Errorf("want the filtered collector to share the instance %p, got %p", c, got)
+	}
+
+	for _, filter := range []string{"test_disabled", "test_missing"} {
+		if _, err := nc.Filter(filter); err == nil {
+			t.Errorf("want an error when filtering for %s", filter)
+		}
+	}
+}
+
+func TestNodeCollectorExclude(t *testing.T) {
+	factories["test_enabled"] = func() (Collector, error) { return testCollector{}, nil }
+	factories["test_disabled"] = func() (Collector, error) { return testCollector{}, nil }
+	defer delete(factories, "test_enabled")
+	defer delete(factories, "test_disabled")
+
+	nc := &NodeCollector{Collectors: map[string]Collector{
+		"test_enabled": testCollector{},
+		"loadavg":      testCollector{},
+	}}
+
+	filtered, err := nc.Exclude("loadavg", "test_disabled")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := filtered.Collectors["loadavg"]; ok || len(filtered.Collectors) != 1 {
+		t.Errorf("want only test_enabled after excluding loadavg, got %v", filtered.Collectors)
+	}
+	if len(nc.Collectors) != 2 {
+		t.Error("want Exclude to leave the original collectors unchanged")
+	}
+
+	if _, err := nc.Exclude("test_missing"); err == nil {
+		t.Error("want an error when excluding a missing collector")
+	}
+}
+
+// manyCollector sends n series of testDesc.
+type manyCollector struct {
+	n int
+}
+
+func (c manyCollector) Update(ch chan<- prometheus.Metric) error {
+	desc := prometheus.NewDesc("node_test_many", "Test metric.", []string{"i"}, nil)
+	for i := 0; i < c.n; i++ {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, fmt.Sprint(i))
+	}
+	return nil
+}
+
+// shuffledCollector sends n series of testDesc in a random order.
+type shuffledCollector struct {
+	n int
+}
+
+func (c shuffledCollector) Update(ch chan<- prometheus.Metric) error {
+	desc := prometheus.NewDesc("node_test_many", "Test metric.", []string{"i"}, nil)
+	for _, i := range rand.Perm(c.n) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, fmt.Sprint(i))
+	}
+	return nil
+}
+
+func TestSeriesLimitKeepsSameSeries(t *testing.T) {
+	nc := &NodeCollector{
+		Collectors:   map[string]Collector{"test_shuffled": shuffledCollector{n: 20}},
+		seriesLimits: map[string]int{"test_shuffled": 5},
+	}
+	for run := 0; run < 10; run++ {
+		ch := make(chan prometheus.Metric)
+		go func() {
+			nc.Collect(ch)
+			close(ch)
+		}()
+		var kept []string
+		for m := range ch {
+			switch m.Desc() {
+			case scrapeSeriesDesc, scrapeSeriesLimitExceededDesc, scrapeDurationDesc, scrapeSuccessDesc, scrapeTimeoutDesc:
+				continue
+			}
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			kept = append(kept, pb.GetLabel()[0].GetValue())
+		}
+		sort.Strings(kept)
+		if got, want := strings.Join(kept, ","), "0,1,10,11,12"; got != want {
+			t.Fatalf("run %d: want series %s kept, got %s", run, want, got)
+		}
+	}
+}
+
+func TestSeriesLimit(t *testing.T) {
+	for _, test := range []struct {
+		drop     bool
+		limit    int
+		series   int
+		exceeded float64
+	}{
+		{limit: 0, series: 5},
+		{limit: 5, series: 5},
+		{limit: 3, series: 3, exceeded: 1},
+		{limit: 3, series: 0, exceeded: 1, drop: true},
+	} {
+		nc := &NodeCollector{
+			Collectors:    map[string]Collector{"test_many": manyCollector{n: 5}},
+			seriesLimits:  map[string]int{"test_many": test.limit},
+			dropOverLimit: test.drop,
+		}
+		ch := make(chan prometheus.Metric)
+		go func() {
+			nc.Collect(ch)
+			close(ch)
+		}()
+		var series, seriesMetric, exceeded float64
+		for m := range ch {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			switch m.Desc() {
+			case scrapeSeriesDesc:
+				seriesMetric = pb.GetGauge().GetValue()
+			case scrapeSeriesLimitExceededDesc:
+				exceeded = pb.GetGauge().GetValue()
+			case scrapeDurationDesc, scrapeSuccessDesc, scrapeTimeoutDesc:
+			default:
+				series++
+			}
+		}
+		if series != float64(test.series) || seriesMetric != 5 || exceeded != test.exceeded {
+			t.Errorf("limit %d, drop %v: want %d series, 5 reported and exceeded %v, got %v series, %v reported and exceeded %v",
+				test.limit, test.drop, test.series, test.exceeded, series, seriesMetric, exceeded)
+		}
+	}
+}
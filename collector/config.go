@@ -0,0 +1,260 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/node_exporter/utils"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Collectors read their settings from the --collector.* flags when they are
+// created. The configuration file is applied on top of the command line by
+// setting those flags before the collectors are created, so collectors must
+// only read their flags in their factory, never during Update.
+
+var (
+	// flagMtx serializes changes to the collector flags.
+	flagMtx sync.Mutex
+	// commandLineValues holds the collector flag values given on the command
+	// line, recorded before the first configuration is applied.
+	commandLineValues map[string]string
+
+	// requestOptions are the options that can be overridden for a single
+	// scrape. They only filter what the collectors report, options pointing
+	// collectors at other paths, URLs or hosts are left to the command line
+	// and the configuration file.
+	requestOptions = map[string]bool{
+		"diskstats.ignored-devices":       true,
+		"filesystem.ignored-fs-types":     true,
+		"filesystem.ignored-mount-points": true,
+		"netclass.ignored-devices":        true,
+		"netdev.ignored-devices":          true,
+		"netstat.fields":                  true,
+		"systemd.unit-blacklist":          true,
+		"systemd.unit-whitelist":          true,
+		"vmstat.fields":                   true,
+	}
+)
+
+// NewNodeCollectorFromConfig creates a NodeCollector with the collector flags
+// overridden by cfg. A nil cfg uses the command line flags only. If cfg is
+// invalid or a collector can't be created, the flags are left unchanged.
+func NewNodeCollectorFromConfig(cfg *utils.Config) (*NodeCollector, error) {
+	flagMtx.Lock()
+	defer flagMtx.Unlock()
+
+	if commandLineValues == nil {
+		commandLineValues = currentFlagValues()
+	}
+	values, err := configFlagValues(cfg)
+	if err != nil {
+		return nil, err
+	}
+	relabelRules, err := relabelRulesFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := currentFlagValues()
+	if err := setFlagValues(values); err != nil {
+		setFlagValues(previous)
+		return nil, err
+	}
+	nc, err := NewNodeCollector()
+	if err != nil {
+		setFlagValues(previous)
+		return nil, err
+	}
+	nc.relabelRules = relabelRules
+	return nc, nil
+}
+
+// WithOptions returns a NodeCollector in which the collectors with options
+// overridden are replaced by new instances created with those options, e.g.
+// "systemd.unit-whitelist" sets --collector.systemd.unit-whitelist. The other
+// collectors are shared with n. The new instances are meant for a single
+// scrape and are never updated in the background, so the options of collectors
+// implementing ClosingCollector can't be overridden. Only the filter options
+// in requestOptions can be set, as options usually come from scrape requests.
+func (n *NodeCollector) WithOptions(options map[string]string) (*NodeCollector, error) {
+	for option := range options {
+		if _, err := splitOption(option); err != nil {
+			return nil, err
+		}
+		if !requestOptions[option] {
+			return nil, fmt.Errorf("option %q can't be set per scrape", option)
+		}
+	}
+	return n.withOptions(options)
+}
+
+// WithConfigOptions is like WithOptions, but any collector option except
+// interval can be set. It is meant for options from the configuration file.
+func (n *NodeCollector) WithConfigOptions(options map[string]string) (*NodeCollector, error) {
+	return n.withOptions(options)
+}
+
+// splitOption returns the collector of an option named
+// <collector>.<option>.
+func splitOption(option string) (string, error) {
+	i := strings.Index(option, ".")
+	if i < 0 {
+		return "", fmt.Errorf("invalid option %q, want <collector>.<option>", option)
+	}
+	collector := option[:i]
+	if _, ok := factories[collector]; !ok {
+		return "", fmt.Errorf("missing collector: %s", collector)
+	}
+	return collector, nil
+}
+
+func (n *NodeCollector) withOptions(options map[string]string) (*NodeCollector, error) {
+	if len(options) == 0 {
+		return n, nil
+	}
+
+	flagMtx.Lock()
+	defer flagMtx.Unlock()
+
+	flags := collectorFlags()
+	values := map[string]map[string]string{}
+	for option, value := range options {
+		collector, err := splitOption(option)
+		if err != nil {
+			return nil, err
+		}
+		name := "collector." + option
+		if _, ok := flags[name]; !ok || name == "collector."+collector+".interval" {
+			return nil, fmt.Errorf("unknown option %q for collector %q", option[len(collector)+1:], collector)
+		}
+		if _, ok := n.Collectors[collector]; !ok {
+			return nil, fmt.Errorf("options for disabled or filtered out collector: %s", collector)
+		}
+		if values[collector] == nil {
+			values[collector] = map[string]string{}
+		}
+		values[collector][name] = value
+	}
+
+	o := n.withCollectors(make(map[string]Collector, len(n.Collectors)))
+	o.timeouts = make(map[string]time.Duration, len(n.timeouts))
+	o.seriesLimits = make(map[string]int, len(n.seriesLimits))
+	o.breakers = make(map[string]*breaker, len(n.breakers))
+	for name, c := range n.Collectors {
+		o.Collectors[name] = c
+		o.timeouts[name] = n.timeouts[name]
+		o.seriesLimits[name] = n.seriesLimits[name]
+		// The new instances don't back off, they only serve one scrape.
+		if _, ok := values[name]; !ok && n.breakers[name] != nil {
+			o.breakers[name] = n.breakers[name]
+		}
+	}
+	previous := currentFlagValues()
+	defer setFlagValues(previous)
+	for _, v := range values {
+		if err := setFlagValues(v); err != nil {
+			return nil, err
+		}
+	}
+	for collector := range values {
+		c, err := factories[collector]()
+		if err != nil {
+			return nil, err
+		}
+		// The new instances are never closed.
+		if cc, ok := c.(ClosingCollector); ok {
+			cc.Close()
+			return nil, fmt.Errorf("options of the %s collector can only be set on the command line or in the configuration file", collector)
+		}
+		o.Collectors[collector] = c
+		o.timeouts[collector] = timeoutFor(collector)
+		o.seriesLimits[collector] = seriesLimitFor(collector)
+	}
+	return o, nil
+}
+
+// configFlagValues returns the values of all collector flags after applying
+// cfg to the command line values.
+func configFlagValues(cfg *utils.Config) (map[string]string, error) {
+	values := make(map[string]string, len(commandLineValues))
+	for name, value := range commandLineValues {
+		values[name] = value
+	}
+	if cfg == nil {
+		return values, nil
+	}
+
+	for collector, cc := range cfg.Collectors {
+		if _, ok := factories[collector]; !ok {
+			return nil, fmt.Errorf("unknown collector %q", collector)
+		}
+		if cc == nil {
+			continue
+		}
+		if cc.Enabled != nil {
+			values["collector."+collector] = strconv.FormatBool(*cc.Enabled)
+		}
+		if cc.Timeout != nil {
+			values["collector."+collector+".timeout"] = cc.Timeout.String()
+		}
+		if cc.Interval != nil {
+			values["collector."+collector+".interval"] = cc.Interval.String()
+		}
+		if cc.SeriesLimit != nil {
+			values["collector."+collector+".series-limit"] = strconv.Itoa(*cc.SeriesLimit)
+		}
+		for option, value := range cc.Options {
+			name := "collector." + collector + "." + option
+			if _, ok := values[name]; !ok {
+				return nil, fmt.Errorf("unknown option %q for collector %q", option, collector)
+			}
+			values[name] = value
+		}
+	}
+	return values, nil
+}
+
+func collectorFlags() map[string]kingpin.Value {
+	flags := map[string]kingpin.Value{}
+	for _, f := range kingpin.CommandLine.Model().Flags {
+		if strings.HasPrefix(f.Name, "collector.") {
+			flags[f.Name] = f.Value
+		}
+	}
+	return flags
+}
+
+func currentFlagValues() map[string]string {
+	values := map[string]string{}
+	for name, value := range collectorFlags() {
+		values[name] = value.String()
+	}
+	return values
+}
+
+func setFlagValues(values map[string]string) error {
+	flags := collectorFlags()
+	for name, value := range values {
+		if err := flags[name].Set(value); err != nil {
+			return fmt.Errorf("invalid value %q for --%s: %s", value, name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/node_exporter/utils"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestNewNodeCollectorFromConfig(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	enabled, disabled := true, false
+
+	textfilePath := func(nc *NodeCollector) string {
+		return nc.Collectors["textfile"].(*textFileCollector).path
+	}
+
+	nc, err := NewNodeCollectorFromConfig(&utils.Config{
+		Collectors: map[string]*utils.CollectorConfig{
+			"textfile": {
+				Enabled: &enabled,
+				Options: map[string]string{"directory": "fixtures/textfile/two_metric_files"},
+			},
+			"loadavg": {Enabled: &disabled},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Close()
+	if got, want := textfilePath(nc), "fixtures/textfile/two_metric_files"; got != want {
+		t.Errorf("want textfile directory %q, got %q", want, got)
+	}
+	if _, ok := nc.Collectors["loadavg"]; ok {
+		t.Error("want loadavg collector to be disabled")
+	}
+
+	for _, cfg := range []*utils.Config{
+		{Collectors: map[string]*utils.CollectorConfig{"unknown": {}}},
+		{Collectors: map[string]*utils.CollectorConfig{"textfile": {Options: map[string]string{"unknown": ""}}}},
+		{Collectors: map[string]*utils.CollectorConfig{"netstat": {Options: map[string]string{"fields": "("}}}},
+	} {
+		if _, err := NewNodeCollectorFromConfig(cfg); err == nil {
+			t.Errorf("want an error for config %+v", cfg.Collectors)
+		}
+	}
+	if got, want := *textFileDirectory, "fixtures/textfile/two_metric_files"; got != want {
+		t.Errorf("want an invalid config to keep the flags unchanged, got textfile directory %q", got)
+	}
+
+	nc, err = NewNodeCollectorFromConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.Close()
+	if got := textfilePath(nc); got != "" {
+		t.Errorf("want the command line textfile directory without a config, got %q", got)
+	}
+	if _, ok := nc.Collectors["loadavg"]; !ok {
+		t.Error("want loadavg collector to be enabled without a config")
+	}
+}
+
+func TestWithOptions(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	nc, err := NewNodeCollectorFromConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	o, err := nc.WithOptions(map[string]string{
+		"netdev.ignored-devices": "^eth",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := o.Collectors["netdev"].(*netDevCollector).ignoredDevicesPattern.String(), "^eth"; got != want {
+		t.Errorf("want netdev ignored devices %q, got %q", want, got)
+	}
+	if o.Collectors["loadavg"] != nc.Collectors["loadavg"] {
+		t.Error("want collectors without options to be shared")
+	}
+	if *netdevIgnoredDevices != "^$" {
+		t.Error("want the flags restored after applying options")
+	}
+
+	for _, options := range []map[string]string{
+		{"textfile": "x"},
+		{"unknown.option": "x"},
+		{"textfile.unknown": "x"},
+		{"textfile.interval": "1m"},
+		{"textfile.timeout": "5s"},
+		{"netstat.fields": "("},
+		// Options pointing collectors elsewhere can't be set per scrape.
+		{"textfile.directory": "/etc"},
+		{"supervisord.url": "http://internal-host/"},
+		{"ntp.server": "internal-host", "ntp.server-is-local": "true"},
+	} {
+		if _, err := nc.WithOptions(options); err == nil {
+			t.Errorf("want an error for options %v", options)
+		}
+	}
+	if *textFileDirectory != "" {
+		t.Error("want rejected options to leave the flags unchanged")
+	}
+}
+
+func TestWithConfigOptions(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	nc, err := NewNodeCollectorFromConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	o, err := nc.WithConfigOptions(map[string]string{
+		"textfile.directory": "fixtures/textfile/two_metric_files",
+		"textfile.timeout":   "5s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := o.Collectors["textfile"].(*textFileCollector).path, "fixtures/textfile/two_metric_files"; got != want {
+		t.Errorf("want textfile directory %q, got %q", want, got)
+	}
+	if got, want := o.timeouts["textfile"], 5*time.Second; got != want {
+		t.Errorf("want textfile timeout %s, got %s", want, got)
+	}
+	if o.Collectors["loadavg"] != nc.Collectors["loadavg"] {
+		t.Error("want collectors without options to be shared")
+	}
+	if got := nc.Collectors["textfile"].(*textFileCollector).path; got != "" {
+		t.Errorf("want the original textfile collector unchanged, got directory %q", got)
+	}
+	if *textFileDirectory != "" || *collectorTimeout["textfile"] != 0 {
+		t.Error("want the flags restored after applying options")
+	}
+
+	for _, options := range []map[string]string{
+		{"textfile.interval": "1m"},
+		{"textfile.timeout": "soon"},
+		{"wifi.fixtures": "x"},
+	} {
+		if _, err := nc.WithConfigOptions(options); err == nil {
+			t.Errorf("want an error for options %v", options)
+		}
+	}
+	if *collectorTimeout["textfile"] != 0 {
+		t.Error("want invalid options to leave the flags unchanged")
+	}
+}
@@ -44,6 +44,12 @@ func NewConntrackCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *conntrackCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.current
+	ch <- c.limit
+}
+
 func (c *conntrackCollector) Update(ch chan<- prometheus.Metric) error {
 	value, err := readUintFromFile(procFilePath("sys/net/netfilter/nf_conntrack_count"))
 	if err != nil {
@@ -14,6 +14,7 @@ import (
 	"golang.org/x/net/context"
 	"strings"
 	"bufio"
+	"sync"
 )
 
 func init() {
@@ -25,10 +26,27 @@ type containersCollector struct {
 	nContainerDesc             *prometheus.Desc
 
 	containerMetrics map[string]*prometheus.Desc
+
+	mtx sync.Mutex
+	// client is created on first use and reused across scrapes.
+	client *dockerapi.Client
+	// eventsSince is where the next events query starts, eventCounts holds
+	// the number of container events seen so far of the containers that
+	// still exist.
+	eventsSince time.Time
+	eventCounts map[containerEvent]float64
+}
+
+// containerEvent holds the labels of a container event.
+type containerEvent struct {
+	eventType, action, name, image, from string
 }
 
 var defaultTimeout = time.Second * 5
 
+// eventsLookback is how far back events are read on the first scrape.
+const eventsLookback = 15 * time.Second
+
 func NewContainersCollector() (Collector, error) {
 	const subsystem = "containers"
 
@@ -117,19 +135,33 @@ func NewContainersCollector() (Collector, error) {
 		nEventsDesc:                nEventsDesc,
 		nContainerDesc:             nContainerDesc,
 		containerMetrics:           containerMetrics,
+		eventCounts:                make(map[containerEvent]float64),
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *containersCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.nEventsDesc
+	ch <- c.nContainerDesc
+	for _, d := range c.containerMetrics {
+		ch <- d
+	}
+}
+
 func (c *containersCollector) Update(ch chan<- prometheus.Metric) error {
-	events, err := c.getAllEvents()
-	if err != nil {
+	return c.UpdateContext(context.Background(), ch)
+}
+
+// UpdateContext implements ContextCollector, aborting outstanding Docker API
+// calls once ctx is done.
+func (c *containersCollector) UpdateContext(ctx context.Context, ch chan<- prometheus.Metric) error {
+	if err := c.updateEvents(ctx); err != nil {
 		return fmt.Errorf("couldn't get containers events: %s", err)
 	}
 
-	events = filterEvents(events)
-	containers, err  := c.getAllContainers()
+	containers, err  := c.getAllContainers(ctx)
 
-	metrics, err := c.asyncRetrieveMetrics()
+	metrics, err := c.asyncRetrieveMetrics(ctx)
 
 	if err != nil {
 		return err
@@ -139,13 +171,58 @@ func (c *containersCollector) Update(ch chan<- prometheus.Metric) error {
 		c.setPrometheusMetrics(b, ch)
 	}
 
-	c.collectEventsMetrics(ch, events)
+	c.collectEventsMetrics(ch)
 	c.collectContainersMetrics(ch, containers)
 	return nil
 }
 
-func (c *containersCollector) collectEventsMetrics(ch chan<- prometheus.Metric, events []eventtypes.Message) {
-	for _, event := range events {
+// dockerClient returns the Docker client of the collector, creating it on
+// first use.
+func (c *containersCollector) dockerClient() (*dockerapi.Client, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.client == nil {
+		client, err := dockerapi.NewEnvClient()
+		if err != nil {
+			return nil, fmt.Errorf("couldn't get docker connection: %s", err)
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+// updateEvents reads the events since the previous scrape and adds them to
+// the event counts. The counts of containers removed before the scrape are
+// dropped, so the events of a container are exported one last time after it
+// is removed.
+func (c *containersCollector) updateEvents(ctx context.Context) error {
+	client, err := c.dockerClient()
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	existing, err := c.getContainerNames(ctx, client)
+	if err != nil {
+		return err
+	}
+	pruneEventCounts(c.eventCounts, existing)
+
+	until := time.Now()
+	since := c.eventsSince
+	if since.IsZero() {
+		since = until.Add(-eventsLookback)
+	}
+	events, err := c.getAllEvents(ctx, client, since, until)
+	if err != nil {
+		return err
+	}
+	c.eventsSince = until
+
+	for _, event := range filterEvents(events) {
 		log.Debugln(event.From, event.Action, event.Type, event.Time, event.Status)
 		if strings.HasPrefix(event.Actor.Attributes["name"], "k8s_") {
 			log.Debugln("containerName", event.Actor.Attributes["name"])
@@ -153,14 +230,37 @@ func (c *containersCollector) collectEventsMetrics(ch chan<- prometheus.Metric,
 		}
 
 		if event.Type == "container" {
-			log.Debugln("send events: ", event.From, event.Action, event.Type, event.Time, event.Status)
-			ch <- prometheus.MustNewConstMetric(
-				c.nEventsDesc, prometheus.CounterValue,
-				1, event.Type, event.Action, event.Actor.Attributes["name"], event.Actor.Attributes["image"], event.From)
+			c.eventCounts[containerEvent{
+				eventType: event.Type,
+				action:    event.Action,
+				name:      event.Actor.Attributes["name"],
+				image:     event.Actor.Attributes["image"],
+				from:      event.From,
+			}]++
+		}
+	}
+	return nil
+}
+
+// pruneEventCounts deletes the counts of the containers not in existing.
+func pruneEventCounts(counts map[containerEvent]float64, existing map[string]bool) {
+	for event := range counts {
+		if !existing[event.name] {
+			delete(counts, event)
 		}
 	}
 }
 
+func (c *containersCollector) collectEventsMetrics(ch chan<- prometheus.Metric) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for event, count := range c.eventCounts {
+		ch <- prometheus.MustNewConstMetric(
+			c.nEventsDesc, prometheus.CounterValue,
+			count, event.eventType, event.action, event.name, event.image, event.from)
+	}
+}
 
 func (c *containersCollector) collectContainersMetrics(ch chan <- prometheus.Metric, containers []types.Container) {
 	for _, container := range containers {
@@ -198,28 +298,20 @@ func DecodeEvents(input io.Reader) ([]eventtypes.Message, error) {
 	return events, nil
 }
 
-func (c *containersCollector) getAllEvents() ([]eventtypes.Message, error) {
-	client, err := dockerapi.NewEnvClient()
-	if err != nil {
-		return nil, fmt.Errorf("couldn't get docker connection: %s", err)
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+func (c *containersCollector) getAllEvents(ctx context.Context, client *dockerapi.Client, since, until time.Time) ([]eventtypes.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 
-	lastTime := 15 * time.Second
-
-	since := time.Unix(time.Now().Add(-lastTime).Unix(), 0).Format("2006-01-02T15:04:05.999999999Z07:00")
-	until := time.Unix(time.Now().Unix(), 0).Format("2006-01-02T15:04:05.999999999Z07:00")
 	opts := types.EventsOptions{
-		Since: since,
-		Until: until,
+		Since: since.Format(time.RFC3339Nano),
+		Until: until.Format(time.RFC3339Nano),
 	}
 	response, err := client.Events(ctx, opts)
 
 	if err != nil {
 		return nil, err
 	}
+	defer response.Close()
 
 	events, err := DecodeEvents(response)
 
@@ -229,13 +321,31 @@ func (c *containersCollector) getAllEvents() ([]eventtypes.Message, error) {
 	return events, nil
 }
 
+// getContainerNames returns the names of all containers, including stopped
+// ones, as used in events.
+func (c *containersCollector) getContainerNames(ctx context.Context, client *dockerapi.Client) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
+	defer cancel()
 
-func (c *containersCollector) getAllContainers() ([]types.Container, error) {
-	client, err := dockerapi.NewEnvClient()
+	containers, err := client.ContainerList(ctx, types.ContainerListOptions{All: true})
 	if err != nil {
-		return nil, fmt.Errorf("couldn't get docker connection: %s", err)
+		return nil, err
+	}
+	names := make(map[string]bool, len(containers))
+	for _, container := range containers {
+		for _, name := range container.Names {
+			names[strings.TrimPrefix(name, "/")] = true
+		}
+	}
+	return names, nil
+}
+
+func (c *containersCollector) getAllContainers(ctx context.Context) ([]types.Container, error) {
+	client, err := c.dockerClient()
+	if err != nil {
+		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	ctx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
 	options := types.ContainerListOptions{}
 	containers, err := client.ContainerList(ctx, options)
@@ -274,14 +384,12 @@ func (c *containersCollector) setPrometheusMetrics(stats *ContainerMetrics, ch c
 	}
 }
 
-func (c *containersCollector) asyncRetrieveMetrics() ([]*ContainerMetrics, error) {
-	// Create new docker API client for passed down to the async requests
-	cli, err := dockerapi.NewEnvClient()
+func (c *containersCollector) asyncRetrieveMetrics(ctx context.Context) ([]*ContainerMetrics, error) {
+	cli, err := c.dockerClient()
 	if err != nil {
-		log.Errorf("Error creating Docker client %v", err)
 		return nil, err
 	}
-	containers, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: false})
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: false})
 	if err != nil {
 		log.Errorf("Error obtaining container listing: %v", err)
 		return nil, err
@@ -300,7 +408,7 @@ func (c *containersCollector) asyncRetrieveMetrics() ([]*ContainerMetrics, error
 	// Done due to there not yet being a '--all' option for the cli.ContainerMetrics function in the engine
 	for _, c := range containers {
 		go func(cli *dockerapi.Client, id, name string) {
-			retrieveContainerMetrics(*cli, id, name, ch)
+			retrieveContainerMetrics(ctx, *cli, id, name, ch)
 		}(cli, c.ID, c.Names[0][1:])
 
 	}
@@ -311,6 +419,8 @@ func (c *containersCollector) asyncRetrieveMetrics() ([]*ContainerMetrics, error
 			if len(ContainerMetrics) == len(containers) {
 				return ContainerMetrics, nil
 			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
 		}
 	}
 }
@@ -356,9 +466,9 @@ type ContainerMetrics struct {
 	} `json:"precpu_stats"`
 }
 
-func retrieveContainerMetrics(cli dockerapi.Client, id, name string, ch chan<- *ContainerMetrics) {
+func retrieveContainerMetrics(ctx context.Context, cli dockerapi.Client, id, name string, ch chan<- *ContainerMetrics) {
 
-	stats, err := cli.ContainerStats(context.Background(), id, false)
+	stats, err := cli.ContainerStats(ctx, id, false)
 	if err != nil {
 		log.Errorf("Error obtaining container stats for %s, error: %v", id, err)
 		return
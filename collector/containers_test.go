@@ -0,0 +1,35 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import "testing"
+
+func TestPruneEventCounts(t *testing.T) {
+	counts := map[containerEvent]float64{
+		{eventType: "container", action: "start", name: "web", image: "nginx"}:     2,
+		{eventType: "container", action: "die", name: "web", image: "nginx"}:       1,
+		{eventType: "container", action: "start", name: "job-1", image: "batch"}:   1,
+		{eventType: "container", action: "destroy", name: "job-1", image: "batch"}: 1,
+	}
+	pruneEventCounts(counts, map[string]bool{"web": true, "db": true})
+
+	if len(counts) != 2 {
+		t.Fatalf("want the 2 counts of web, got %v", counts)
+	}
+	for event := range counts {
+		if event.name != "web" {
+			t.Errorf("want the counts of job-1 dropped, got %+v", event)
+		}
+	}
+}
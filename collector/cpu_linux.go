@@ -78,6 +78,17 @@ func NewCPUCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *cpuCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.cpu
+	ch <- c.cpuGuest
+	ch <- c.cpuFreq
+	ch <- c.cpuFreqMin
+	ch <- c.cpuFreqMax
+	ch <- c.cpuCoreThrottle
+	ch <- c.cpuPackageThrottle
+}
+
 // Update implements Collector and exposes cpu related metrics from /proc/stat and /sys/.../cpu/.
 func (c *cpuCollector) Update(ch chan<- prometheus.Metric) error {
 	if err := c.updateStat(ch); err != nil {
@@ -64,8 +64,13 @@ func init() {
 func NewDiskstatsCollector() (Collector, error) {
 	var diskLabelNames = []string{"device"}
 
+	ignoredDevicesPattern, err := regexp.Compile(*ignoredDevices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.diskstats.ignored-devices: %s", err)
+	}
+
 	return &diskstatsCollector{
-		ignoredDevicesPattern: regexp.MustCompile(*ignoredDevices),
+		ignoredDevicesPattern: ignoredDevicesPattern,
 		// Docs from https://www.kernel.org/doc/Documentation/iostats.txt
 		descs: []typedFactorDesc{
 			{
@@ -166,6 +171,13 @@ func NewDiskstatsCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *diskstatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.descs {
+		ch <- d.desc
+	}
+}
+
 func (c *diskstatsCollector) Update(ch chan<- prometheus.Metric) error {
 	procDiskStats := procFilePath("diskstats")
 	diskStats, err := getDiskStats()
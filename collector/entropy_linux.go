@@ -40,6 +40,11 @@ func NewEntropyCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *entropyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entropyAvail
+}
+
 func (c *entropyCollector) Update(ch chan<- prometheus.Metric) error {
 	value, err := readUintFromFile(procFilePath("sys/kernel/random/entropy_avail"))
 	if err != nil {
@@ -17,7 +17,9 @@
 package collector
 
 import (
+	"fmt"
 	"regexp"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -48,6 +50,10 @@ type filesystemCollector struct {
 	sizeDesc, freeDesc, availDesc *prometheus.Desc
 	filesDesc, filesFreeDesc      *prometheus.Desc
 	roDesc, deviceErrorDesc       *prometheus.Desc
+
+	// Mount points whose statfs() call hung, only tracked on Linux.
+	stuckMountsMtx sync.Mutex
+	stuckMounts    map[string]struct{}
 }
 
 type filesystemLabels struct {
@@ -68,8 +74,14 @@ func init() {
 // NewFilesystemCollector returns a new Collector exposing filesystems stats.
 func NewFilesystemCollector() (Collector, error) {
 	subsystem := "filesystem"
-	mountPointPattern := regexp.MustCompile(*ignoredMountPoints)
-	filesystemsTypesPattern := regexp.MustCompile(*ignoredFSTypes)
+	mountPointPattern, err := regexp.Compile(*ignoredMountPoints)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.filesystem.ignored-mount-points: %s", err)
+	}
+	filesystemsTypesPattern, err := regexp.Compile(*ignoredFSTypes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.filesystem.ignored-fs-types: %s", err)
+	}
 
 	sizeDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "size_bytes"),
@@ -123,9 +135,21 @@ func NewFilesystemCollector() (Collector, error) {
 		filesFreeDesc:             filesFreeDesc,
 		roDesc:                    roDesc,
 		deviceErrorDesc:           deviceErrorDesc,
+		stuckMounts:               make(map[string]struct{}),
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *filesystemCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.sizeDesc
+	ch <- c.freeDesc
+	ch <- c.availDesc
+	ch <- c.filesDesc
+	ch <- c.filesFreeDesc
+	ch <- c.roDesc
+	ch <- c.deviceErrorDesc
+}
+
 func (c *filesystemCollector) Update(ch chan<- prometheus.Metric) error {
 	stats, err := c.GetStats()
 	if err != nil {
@@ -0,0 +1,469 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nokmsg
+
+package collector
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/procfs"
+	"gopkg.in/alecthomas/kingpin.v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const kmsgSubsystem = "kmsg"
+
+var (
+	kmsgPath         = kingpin.Flag("collector.kmsg.path", "Kernel log device, or a file of kernel log records.").Default("/dev/kmsg").String()
+	kmsgConfig       = kingpin.Flag("collector.kmsg.config", "Path to a YAML file with problems to detect in addition to the built-in ones.").Default("").String()
+	kmsgMaxProcesses = kingpin.Flag("collector.kmsg.max-processes", "Maximum number of distinct processes counted per problem, further processes are counted with an empty process label.").Default("20").Int()
+)
+
+var (
+	// kmsgReadersMtx protects kmsgReaders.
+	kmsgReadersMtx sync.Mutex
+	// kmsgReaders holds the readers of the kernel logs by path. A reader is
+	// shared by the collectors reading the same path, so a collector created
+	// on reload goes on where the previous one is instead of reading the
+	// whole log again.
+	kmsgReaders = map[string]*kmsgReader{}
+)
+
+// kmsgPollInterval is how often a regular file is read again at its end.
+var kmsgPollInterval = time.Second
+
+// kmsgBuiltinProblems are detected like node-problem-detector does. A line
+// only counts for the first problem it matches.
+var kmsgBuiltinProblems = []kmsgProblemConfig{
+	{Name: "OOMKilling", Regex: `Killed process \d+ \((?P<process>[^)]+)\)`},
+	{Name: "TaskHung", Regex: `task (?P<process>.+):\d+ blocked for more than \d+ seconds`},
+	{Name: "SoftLockup", Regex: `BUG: soft lockup - CPU#\d+ stuck for`},
+	{Name: "HardLockup", Regex: `Watchdog detected hard LOCKUP on cpu \d+`},
+	{Name: "ReadonlyFilesystem", Regex: `Remounting filesystem read-only`, Condition: true},
+	{Name: "Ext4Error", Regex: `^EXT4-fs error`},
+	{Name: "XFSError", Regex: `^XFS \(\S+\): (Corruption|metadata I/O error|Log I/O Error|xfs_do_force_shutdown|Filesystem has been shut down)`},
+	{Name: "NFSServerNotResponding", Regex: `^nfs: server \S+ not responding`},
+	{Name: "KernelOops", Regex: `^Oops: [0-9a-f]+ \[#\d+\]`},
+	{Name: "KernelBug", Regex: `^kernel BUG at `},
+}
+
+// kmsgConfigFile is the content of --collector.kmsg.config.
+type kmsgConfigFile struct {
+	Problems []kmsgProblemConfig `yaml:"problems"`
+}
+
+// kmsgProblemConfig detects the messages matching Regex. A problem with the
+// name of a built-in one replaces it. The "process" capture group of Regex,
+// if any, becomes the process label. A condition stays set once detected.
+type kmsgProblemConfig struct {
+	Name      string `yaml:"name"`
+	Regex     string `yaml:"regex"`
+	Condition bool   `yaml:"condition"`
+}
+
+type kmsgProblem struct {
+	name      string
+	regex     *regexp.Regexp
+	condition bool
+	// process is the index of the process capture group, or -1.
+	process int
+
+	counts map[string]float64
+	last   time.Time
+}
+
+// kmsgReader reads a kernel log and passes its messages to the collectors
+// attached to it.
+type kmsgReader struct {
+	path     string
+	bootTime time.Time
+	file     *os.File
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	// mtx protects collectors and the counts of the attached collectors.
+	mtx        sync.Mutex
+	collectors []*kmsgCollector
+}
+
+type kmsgCollector struct {
+	reader       *kmsgReader
+	problems     []*kmsgProblem
+	maxProcesses int
+	messages     float64
+
+	messagesDesc  *prometheus.Desc
+	problemsDesc  *prometheus.Desc
+	lastDesc      *prometheus.Desc
+	conditionDesc *prometheus.Desc
+}
+
+func init() {
+	registerCollector(kmsgSubsystem, defaultDisabled, NewKmsgCollector)
+}
+
+// NewKmsgCollector returns a new Collector detecting kernel problems in the
+// messages of --collector.kmsg.path. The kernel log is read from the oldest
+// message still buffered, so conditions are detected again after a restart.
+// A collector created while another one reads the same path, e.g. on reload,
+// takes over its counts and only reads the messages written since.
+func NewKmsgCollector() (Collector, error) {
+	problems, err := kmsgProblems(*kmsgConfig)
+	if err != nil {
+		return nil, fmt.Errorf("kmsg collector: %s", err)
+	}
+
+	c := &kmsgCollector{
+		problems:     problems,
+		maxProcesses: *kmsgMaxProcesses,
+		messagesDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "messages_total"),
+			"Number of kernel messages read.",
+			nil, nil,
+		),
+		problemsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "problems_total"),
+			"Number of kernel messages reporting a problem, by the process named in them.",
+			[]string{"problem", "process"}, nil,
+		),
+		lastDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "problem_last_timestamp_seconds"),
+			"Time of the last kernel message reporting a problem.",
+			[]string{"problem"}, nil,
+		),
+		conditionDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, kmsgSubsystem, "condition"),
+			"Whether a permanent problem was reported since boot.",
+			[]string{"condition"}, nil,
+		),
+	}
+	if err := c.attach(*kmsgPath); err != nil {
+		return nil, fmt.Errorf("kmsg collector: %s", err)
+	}
+	return c, nil
+}
+
+// attach attaches c to the reader of path, starting one if none reads it yet.
+// c takes over the counts of the last collector attached to the reader.
+func (c *kmsgCollector) attach(path string) error {
+	kmsgReadersMtx.Lock()
+	defer kmsgReadersMtx.Unlock()
+	r, ok := kmsgReaders[path]
+	if !ok {
+		var err error
+		if r, err = newKmsgReader(path); err != nil {
+			return err
+		}
+		kmsgReaders[path] = r
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if n := len(r.collectors); n > 0 {
+		c.takeOver(r.collectors[n-1])
+	}
+	r.collectors = append(r.collectors, c)
+	c.reader = r
+	return nil
+}
+
+// takeOver copies the counts of previous, for the problems defined the same
+// way in both collectors.
+func (c *kmsgCollector) takeOver(previous *kmsgCollector) {
+	c.messages = previous.messages
+	for _, p := range c.problems {
+		for _, pp := range previous.problems {
+			if p.name != pp.name || p.regex.String() != pp.regex.String() || p.condition != pp.condition {
+				continue
+			}
+			for process, count := range pp.counts {
+				p.counts[process] = count
+			}
+			p.last = pp.last
+		}
+	}
+}
+
+func newKmsgReader(path string) (*kmsgReader, error) {
+	fs, err := procfs.NewFS(*procPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open procfs: %v", err)
+	}
+	stat, err := fs.NewStat()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &kmsgReader{
+		path:     path,
+		bootTime: time.Unix(int64(stat.BootTime), 0),
+		file:     f,
+		done:     make(chan struct{}),
+	}
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.read()
+	}()
+	return r, nil
+}
+
+// kmsgProblems returns the problems of the file at path followed by the
+// built-in problems they don't replace.
+func kmsgProblems(path string) ([]*kmsgProblem, error) {
+	cfg := &kmsgConfigFile{}
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+			return nil, fmt.Errorf("couldn't parse %s: %s", path, err)
+		}
+	}
+
+	problems := []*kmsgProblem{}
+	seen := map[string]bool{}
+	for _, pc := range cfg.Problems {
+		if seen[pc.Name] {
+			return nil, fmt.Errorf("problem %s defined twice", pc.Name)
+		}
+		p, err := newKmsgProblem(pc)
+		if err != nil {
+			return nil, err
+		}
+		seen[pc.Name] = true
+		problems = append(problems, p)
+	}
+	for _, pc := range kmsgBuiltinProblems {
+		if seen[pc.Name] {
+			continue
+		}
+		p, err := newKmsgProblem(pc)
+		if err != nil {
+			return nil, err
+		}
+		problems = append(problems, p)
+	}
+	return problems, nil
+}
+
+func newKmsgProblem(pc kmsgProblemConfig) (*kmsgProblem, error) {
+	if pc.Name == "" {
+		return nil, errors.New("missing problem name")
+	}
+	re, err := regexp.Compile(pc.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of problem %s: %s", pc.Name, err)
+	}
+	p := &kmsgProblem{
+		name:      pc.Name,
+		regex:     re,
+		condition: pc.Condition,
+		process:   -1,
+		counts:    map[string]float64{},
+	}
+	for i, name := range re.SubexpNames() {
+		switch name {
+		case "":
+		case "process":
+			p.process = i
+		default:
+			return nil, fmt.Errorf("invalid capture group %q of problem %s, only process is supported", name, pc.Name)
+		}
+	}
+	return p, nil
+}
+
+// read processes the kernel messages until the reader is closed.
+func (r *kmsgReader) read() {
+	br := bufio.NewReader(r.file)
+	partial := ""
+	for {
+		line, err := br.ReadString('\n')
+		if err == nil {
+			r.process(partial + strings.TrimSuffix(line, "\n"))
+			partial = ""
+			continue
+		}
+		partial += line
+		switch {
+		case err == io.EOF:
+			// The end of a regular file, wait for more to be written.
+			select {
+			case <-time.After(kmsgPollInterval):
+			case <-r.done:
+				return
+			}
+		case isEPIPE(err):
+			// The oldest messages were overwritten before they were read,
+			// reading goes on with the oldest message left.
+			partial = ""
+		default:
+			select {
+			case <-r.done:
+			default:
+				log.Errorln("kmsg collector: stopped reading:", err)
+			}
+			return
+		}
+	}
+}
+
+func isEPIPE(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		return pe.Err == syscall.EPIPE
+	}
+	return false
+}
+
+// process passes the message of a line of the kernel log to the attached
+// collectors. A record is "<priority>,<sequence>,<microseconds since
+// boot>,<flags>;<message>" followed by continuation lines starting with a
+// space, which are ignored.
+func (r *kmsgReader) process(line string) {
+	if strings.HasPrefix(line, " ") {
+		return
+	}
+	ts := time.Now()
+	message := line
+	if i := strings.IndexByte(line, ';'); i >= 0 {
+		fields := strings.Split(line[:i], ",")
+		if len(fields) >= 3 {
+			priority, err1 := strconv.Atoi(fields[0])
+			usec, err2 := strconv.ParseInt(fields[2], 10, 64)
+			if err1 == nil && err2 == nil {
+				// Only the kernel facility is trusted, user space can write
+				// to /dev/kmsg too.
+				if priority>>3 != 0 {
+					return
+				}
+				ts = r.bootTime.Add(time.Duration(usec) * time.Microsecond)
+				message = line[i+1:]
+			}
+		}
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, c := range r.collectors {
+		c.detect(message, ts)
+	}
+}
+
+// close stops reading the kernel log.
+func (r *kmsgReader) close() {
+	close(r.done)
+	r.file.Close()
+	r.wg.Wait()
+}
+
+// detect counts the problem reported by message, if any. The reader lock must
+// be held.
+func (c *kmsgCollector) detect(message string, ts time.Time) {
+	c.messages++
+	for _, p := range c.problems {
+		m := p.regex.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		process := ""
+		if p.process >= 0 {
+			process = m[p.process]
+		}
+		if _, ok := p.counts[process]; !ok && len(p.counts) >= c.maxProcesses {
+			process = ""
+		}
+		p.counts[process]++
+		if ts.After(p.last) {
+			p.last = ts
+		}
+		return
+	}
+}
+
+// Describe implements DescribedCollector.
+func (c *kmsgCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.messagesDesc
+	ch <- c.problemsDesc
+	ch <- c.lastDesc
+	ch <- c.conditionDesc
+}
+
+// Update implements Collector and exposes the kernel problems detected.
+func (c *kmsgCollector) Update(ch chan<- prometheus.Metric) error {
+	c.reader.mtx.Lock()
+	defer c.reader.mtx.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(c.messagesDesc, prometheus.CounterValue, c.messages)
+	for _, p := range c.problems {
+		if len(p.counts) == 0 {
+			ch <- prometheus.MustNewConstMetric(c.problemsDesc, prometheus.CounterValue, 0, p.name, "")
+		}
+		for process, count := range p.counts {
+			ch <- prometheus.MustNewConstMetric(c.problemsDesc, prometheus.CounterValue, count, p.name, process)
+		}
+		if !p.last.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lastDesc, prometheus.GaugeValue, float64(p.last.UnixNano())/1e9, p.name)
+		}
+		if p.condition {
+			detected := 0.0
+			if len(p.counts) > 0 {
+				detected = 1
+			}
+			ch <- prometheus.MustNewConstMetric(c.conditionDesc, prometheus.GaugeValue, detected, p.name)
+		}
+	}
+	return nil
+}
+
+// Close implements ClosingCollector, it detaches the collector from its
+// reader, which stops reading the kernel log once no collector is attached.
+func (c *kmsgCollector) Close() {
+	kmsgReadersMtx.Lock()
+	defer kmsgReadersMtx.Unlock()
+	r := c.reader
+	r.mtx.Lock()
+	attached := false
+	for i, other := range r.collectors {
+		if other == c {
+			r.collectors = append(r.collectors[:i], r.collectors[i+1:]...)
+			attached = true
+			break
+		}
+	}
+	last := len(r.collectors) == 0
+	r.mtx.Unlock()
+	if attached && last {
+		delete(kmsgReaders, r.path)
+		r.close()
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nokmsg
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// kmsgMetrics returns the values of the metrics of c by name and labels.
+func kmsgMetrics(t *testing.T, c Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Update(ch)
+		close(ch)
+	}()
+	metrics := map[string]float64{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := []string{}
+		for _, l := range pb.GetLabel() {
+			labels = append(labels, l.GetName()+"="+l.GetValue())
+		}
+		sort.Strings(labels)
+		v := pb.GetGauge().GetValue()
+		if pb.Counter != nil {
+			v = pb.GetCounter().GetValue()
+		}
+		metrics[descName(m.Desc())+"{"+strings.Join(labels, ",")+"}"] = v
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return metrics
+}
+
+func TestKmsg(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kmsg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kmsg := filepath.Join(dir, "kmsg")
+	config := filepath.Join(dir, "kmsg.yml")
+	if err := ioutil.WriteFile(config, []byte(`
+problems:
+- name: KernelOops
+  regex: '^Oops: '
+- name: NvidiaXid
+  regex: 'NVRM: Xid'
+  condition: true
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(kmsg, []byte(strings.Join([]string{
+		"6,1,1000000,-;Linux version 4.15.0",
+		"3,2,2000000,-;Out of memory: Killed process 123 (stress) total-vm:1000kB, anon-rss:900kB, file-rss:0kB",
+		" SUBSYSTEM=memory",
+		"3,3,3000000,-;Memory cgroup out of memory: Killed process 456 (java) total-vm:1000kB",
+		"3,4,4000000,-;Out of memory: Killed process 789 (stress) total-vm:1000kB",
+		"2,5,5000000,-;EXT4-fs error (device sda1): ext4_find_entry:1436: inode #2: comm ls: reading directory lblock 0",
+		"2,6,6000000,-;EXT4-fs (sda1): Remounting filesystem read-only",
+		"4,7,7000000,-;nfs: server filer not responding, still trying",
+		// Written by user space, not trusted.
+		"12,8,8000000,-;Killed process 1 (init)",
+		"",
+	}, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(path, config, proc string, maxProcesses int, interval time.Duration) {
+		*kmsgPath, *kmsgConfig, *procPath, *kmsgMaxProcesses = path, config, proc, maxProcesses
+		kmsgPollInterval = interval
+	}(*kmsgPath, *kmsgConfig, *procPath, *kmsgMaxProcesses, kmsgPollInterval)
+	*kmsgPath = kmsg
+	*kmsgConfig = config
+	*procPath = "fixtures/proc"
+	*kmsgMaxProcesses = 20
+	kmsgPollInterval = 10 * time.Millisecond
+
+	c, err := NewKmsgCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(ClosingCollector).Close()
+	waitForKmsg(t, c, 7)
+	// The boot time of fixtures/proc/stat is 1418183276.
+	want := map[string]float64{
+		"node_kmsg_problems_total{problem=OOMKilling,process=stress}":              2,
+		"node_kmsg_problems_total{problem=OOMKilling,process=java}":                1,
+		"node_kmsg_problem_last_timestamp_seconds{problem=OOMKilling}":             1418183280,
+		"node_kmsg_problems_total{problem=Ext4Error,process=}":                     1,
+		"node_kmsg_problems_total{problem=NFSServerNotResponding,process=}":        1,
+		"node_kmsg_problem_last_timestamp_seconds{problem=NFSServerNotResponding}": 1418183283,
+		"node_kmsg_problems_total{problem=KernelOops,process=}":                    0,
+		"node_kmsg_condition{condition=ReadonlyFilesystem}":                        1,
+		"node_kmsg_condition{condition=NvidiaXid}":                                 0,
+	}
+	metrics := kmsgMetrics(t, c)
+	for metric, v := range want {
+		if got, ok := metrics[metric]; !ok || got != v {
+			t.Errorf("%s: want %v, got %v", metric, v, got)
+		}
+	}
+	if _, ok := metrics["node_kmsg_problems_total{problem=OOMKilling,process=init}"]; ok {
+		t.Error("want user space messages to be ignored")
+	}
+
+	// Messages written later are read, including user-defined problems.
+	f, err := os.OpenFile(kmsg, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("4,9,9000000,-;NVRM: Xid (PCI:0000:00:1e): 79, GPU has fallen off the bus.\n"); err != nil {
+		t.Fatal(err)
+	}
+	waitForKmsg(t, c, 8)
+	if got := kmsgMetrics(t, c)["node_kmsg_condition{condition=NvidiaXid}"]; got != 1 {
+		t.Errorf("want the NvidiaXid condition set, got %v", got)
+	}
+
+	// A collector created on reload takes over the counts instead of reading
+	// the kernel log again, and carries on once the old one is closed.
+	*kmsgMaxProcesses = 2
+	reloaded, err := NewKmsgCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.(ClosingCollector).Close()
+	c.(ClosingCollector).Close()
+	if _, err := f.WriteString("3,10,10000000,-;Out of memory: Killed process 321 (python) total-vm:1000kB\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	waitForKmsg(t, reloaded, 9)
+	metrics = kmsgMetrics(t, reloaded)
+	want = map[string]float64{
+		"node_kmsg_problems_total{problem=OOMKilling,process=stress}": 2,
+		"node_kmsg_problems_total{problem=OOMKilling,process=java}":   1,
+		// Processes over --collector.kmsg.max-processes have no label.
+		"node_kmsg_problems_total{problem=OOMKilling,process=}": 1,
+		"node_kmsg_condition{condition=NvidiaXid}":              1,
+		"node_kmsg_condition{condition=ReadonlyFilesystem}":     1,
+	}
+	for metric, v := range want {
+		if got, ok := metrics[metric]; !ok || got != v {
+			t.Errorf("after reload, %s: want %v, got %v", metric, v, got)
+		}
+	}
+}
+
+// waitForKmsg waits until c read want messages.
+func waitForKmsg(t *testing.T, c Collector, want float64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := kmsgMetrics(t, c)["node_kmsg_messages_total{}"]
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("want %v messages read, got %v", want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKmsgProblemValidation(t *testing.T) {
+	for _, pc := range []kmsgProblemConfig{
+		{Regex: "error"},
+		{Name: "bad", Regex: "("},
+		{Name: "bad", Regex: "(?P<pid>\\d+)"},
+	} {
+		if _, err := newKmsgProblem(pc); err == nil {
+			t.Errorf("want an error for problem %+v", pc)
+		}
+	}
+	for _, pc := range kmsgBuiltinProblems {
+		if _, err := newKmsgProblem(pc); err != nil {
+			t.Errorf("built-in problem %s: %s", pc.Name, err)
+		}
+	}
+}
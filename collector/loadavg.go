@@ -42,6 +42,13 @@ func NewLoadavgCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *loadavgCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range c.metric {
+		ch <- d.desc
+	}
+}
+
 func (c *loadavgCollector) Update(ch chan<- prometheus.Metric) error {
 	loads, err := getLoad()
 	if err != nil {
@@ -0,0 +1,565 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nologmatch
+
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/hpcloud/tail"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"gopkg.in/alecthomas/kingpin.v2"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const logmatchSubsystem = "logmatch"
+
+var (
+	logmatchConfig         = kingpin.Flag("collector.logmatch.config", "Path to the YAML file listing the log files to follow and the rules matching their lines.").Default("").String()
+	logmatchStateFile      = kingpin.Flag("collector.logmatch.state-file", "Absolute path of the file keeping the read position and the counts of every log file across restarts, e.g. /var/lib/node_exporter/logmatch_state.json.").Default("").String()
+	logmatchMaxLineLength  = kingpin.Flag("collector.logmatch.max-line-length", "Lines longer than this many bytes are truncated before they are matched.").Default("4096").Int()
+	logmatchMaxLabelValues = kingpin.Flag("collector.logmatch.max-label-values", "Maximum number of distinct captured label values per file and rule, further values are dropped.").Default("100").Int()
+)
+
+// logmatchConfigFile is the content of --collector.logmatch.config.
+type logmatchConfigFile struct {
+	Files []logmatchFileConfig `yaml:"files"`
+}
+
+type logmatchFileConfig struct {
+	Path  string               `yaml:"path"`
+	Rules []logmatchRuleConfig `yaml:"rules"`
+}
+
+// logmatchRuleConfig counts the lines matching Regex. The named capture
+// groups of Regex become labels.
+type logmatchRuleConfig struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+}
+
+// logmatchState is the content of --collector.logmatch.state-file.
+type logmatchState struct {
+	Files map[string]logmatchFileState `json:"files"`
+}
+
+type logmatchFileState struct {
+	Inode     uint64               `json:"inode"`
+	Offset    int64                `json:"offset"`
+	Read      float64              `json:"read"`
+	Truncated float64              `json:"truncated"`
+	Counts    []logmatchCountState `json:"counts"`
+	Dropped   map[string]float64   `json:"dropped,omitempty"`
+}
+
+type logmatchCountState struct {
+	Rule   string            `json:"rule"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Count  float64           `json:"count"`
+}
+
+type logmatchCollector struct {
+	files     []*logmatchFile
+	stateFile string
+
+	readDesc      *prometheus.Desc
+	truncatedDesc *prometheus.Desc
+	droppedDesc   *prometheus.Desc
+
+	// saveMtx serializes writing the state file.
+	saveMtx sync.Mutex
+	saved   []byte
+	wg      sync.WaitGroup
+}
+
+// logmatchFile follows a log file and counts the lines matching its rules.
+type logmatchFile struct {
+	path           string
+	rules          []*logmatchRule
+	maxLineLength  int
+	maxLabelValues int
+	tail           *tail.Tail
+	// reopened receives a value whenever tail reopened the file, until done
+	// is closed when follow returns.
+	reopened chan struct{}
+	done     chan struct{}
+
+	mtx sync.Mutex
+	// inode and offset are the position after the last counted line.
+	inode     uint64
+	offset    int64
+	read      float64
+	truncated float64
+	// counts holds the counts of every rule by their joined label values.
+	counts  []map[string]*logmatchCount
+	dropped []float64
+}
+
+type logmatchRule struct {
+	name   string
+	regex  *regexp.Regexp
+	labels []string
+	// groups are the indexes of the capture groups of the labels.
+	groups []int
+	desc   *prometheus.Desc
+}
+
+type logmatchCount struct {
+	values []string
+	count  float64
+}
+
+func init() {
+	registerCollector(logmatchSubsystem, defaultDisabled, NewLogmatchCollector)
+}
+
+// NewLogmatchCollector returns a new Collector following the log files of
+// --collector.logmatch.config, from the positions saved in the state file.
+func NewLogmatchCollector() (Collector, error) {
+	if *logmatchConfig == "" {
+		return nil, errors.New("logmatch collector: --collector.logmatch.config is required")
+	}
+	// A relative path would depend on the working directory, / for services.
+	if !filepath.IsAbs(*logmatchStateFile) {
+		return nil, errors.New("logmatch collector: --collector.logmatch.state-file must be an absolute path")
+	}
+	cfg, err := readLogmatchConfig(*logmatchConfig)
+	if err != nil {
+		return nil, fmt.Errorf("logmatch collector: %s", err)
+	}
+	state, err := readLogmatchState(*logmatchStateFile)
+	if err != nil {
+		return nil, fmt.Errorf("logmatch collector: %s", err)
+	}
+
+	c := &logmatchCollector{
+		stateFile: *logmatchStateFile,
+		readDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logmatchSubsystem, "read_lines_total"),
+			"Number of lines read from a log file.",
+			[]string{"file"}, nil,
+		),
+		truncatedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logmatchSubsystem, "truncated_lines_total"),
+			"Number of lines of a log file truncated to --collector.logmatch.max-line-length before matching.",
+			[]string{"file"}, nil,
+		),
+		droppedDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, logmatchSubsystem, "dropped_matches_total"),
+			"Number of matches of a rule dropped because it reached --collector.logmatch.max-label-values.",
+			[]string{"file", "rule"}, nil,
+		),
+	}
+	for _, fc := range cfg.Files {
+		f, err := newLogmatchFile(fc, state.Files[fc.Path])
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("logmatch collector: %s", err)
+		}
+		c.files = append(c.files, f)
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			f.follow()
+		}()
+	}
+	return c, nil
+}
+
+func readLogmatchConfig(path string) (*logmatchConfigFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &logmatchConfigFile{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("couldn't parse %s: %s", path, err)
+	}
+	paths := map[string]bool{}
+	for _, fc := range cfg.Files {
+		if fc.Path == "" {
+			return nil, errors.New("missing path of a log file")
+		}
+		if paths[fc.Path] {
+			return nil, fmt.Errorf("log file %s listed twice", fc.Path)
+		}
+		paths[fc.Path] = true
+	}
+	return cfg, nil
+}
+
+// readLogmatchState returns the saved state, empty if there is none.
+func readLogmatchState(path string) (*logmatchState, error) {
+	state := &logmatchState{}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("couldn't parse state file %s: %s", path, err)
+	}
+	return state, nil
+}
+
+func newLogmatchRule(rc logmatchRuleConfig) (*logmatchRule, error) {
+	if rc.Name == "" {
+		return nil, errors.New("missing rule name")
+	}
+	re, err := regexp.Compile(rc.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of rule %s: %s", rc.Name, err)
+	}
+	r := &logmatchRule{name: rc.Name, regex: re}
+	seen := map[string]bool{"file": true, "rule": true}
+	for i, name := range re.SubexpNames() {
+		if name == "" {
+			continue
+		}
+		if !model.LabelName(name).IsValid() || seen[name] {
+			return nil, fmt.Errorf("invalid label name %q captured by rule %s", name, rc.Name)
+		}
+		seen[name] = true
+		r.labels = append(r.labels, name)
+		r.groups = append(r.groups, i)
+	}
+	r.desc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, logmatchSubsystem, "lines_total"),
+		"Number of lines of a log file matched by a rule.",
+		append([]string{"file", "rule"}, r.labels...), nil,
+	)
+	return r, nil
+}
+
+// newLogmatchFile starts following the file of fc from the saved position,
+// if it is still valid. Without one, an existing file is followed from its
+// end, so old lines aren't counted.
+func newLogmatchFile(fc logmatchFileConfig, state logmatchFileState) (*logmatchFile, error) {
+	f := &logmatchFile{
+		path:           fc.Path,
+		maxLineLength:  *logmatchMaxLineLength,
+		maxLabelValues: *logmatchMaxLabelValues,
+		reopened:       make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+	rules := map[string]int{}
+	for _, rc := range fc.Rules {
+		r, err := newLogmatchRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("log file %s: %s", fc.Path, err)
+		}
+		if _, ok := rules[r.name]; ok {
+			return nil, fmt.Errorf("log file %s: rule %s defined twice", fc.Path, r.name)
+		}
+		rules[r.name] = len(f.rules)
+		f.rules = append(f.rules, r)
+		f.counts = append(f.counts, map[string]*logmatchCount{})
+		f.dropped = append(f.dropped, 0)
+	}
+
+	var location *tail.SeekInfo
+	if fi, err := os.Stat(f.path); err == nil {
+		switch {
+		case fileInode(fi) == state.Inode && fi.Size() >= state.Offset:
+			f.restore(state, rules)
+			location = &tail.SeekInfo{Offset: state.Offset, Whence: os.SEEK_SET}
+		case state.Inode != 0:
+			// The file was rotated or truncated since the state was saved.
+			f.restore(state, rules)
+			f.offset = 0
+		default:
+			f.offset = fi.Size()
+			location = &tail.SeekInfo{Offset: f.offset, Whence: os.SEEK_SET}
+		}
+		f.inode = fileInode(fi)
+	} else {
+		// The inode is set once the file appears.
+		f.restore(state, rules)
+		f.offset = 0
+	}
+
+	t, err := tail.TailFile(f.path, tail.Config{
+		Location: location,
+		ReOpen:   true,
+		Follow:   true,
+		Logger:   tailLogger{path: f.path, reopened: f.reopened, done: f.done},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't follow %s: %s", f.path, err)
+	}
+	f.tail = t
+	return f, nil
+}
+
+// restore restores the counts of state, skipping the rules that were removed
+// or capture other labels.
+func (f *logmatchFile) restore(state logmatchFileState, rules map[string]int) {
+	f.offset = state.Offset
+	f.read = state.Read
+	f.truncated = state.Truncated
+	for _, cs := range state.Counts {
+		i, ok := rules[cs.Rule]
+		if !ok || len(cs.Labels) != len(f.rules[i].labels) {
+			continue
+		}
+		values := make([]string, 0, len(cs.Labels))
+		for _, name := range f.rules[i].labels {
+			value, ok := cs.Labels[name]
+			if !ok {
+				break
+			}
+			values = append(values, value)
+		}
+		if len(values) != len(f.rules[i].labels) {
+			continue
+		}
+		f.counts[i][strings.Join(values, "\xff")] = &logmatchCount{values: values, count: cs.Count}
+	}
+	for name, dropped := range state.Dropped {
+		if i, ok := rules[name]; ok {
+			f.dropped[i] = dropped
+		}
+	}
+}
+
+// follow counts the lines sent by tail until it stops. tail reports reopening
+// the file before sending its first line, so the position can be tracked
+// without asking tail, which isn't safe while it runs.
+func (f *logmatchFile) follow() {
+	defer close(f.done)
+	for {
+		select {
+		case line, ok := <-f.tail.Lines:
+			if !ok {
+				return
+			}
+			if line.Err != nil {
+				log.Errorf("logmatch collector: error following %s: %s", f.path, line.Err)
+				continue
+			}
+			f.process(line.Text)
+		case <-f.reopened:
+			f.mtx.Lock()
+			f.offset = 0
+			if fi, err := os.Stat(f.path); err == nil {
+				f.inode = fileInode(fi)
+			}
+			f.mtx.Unlock()
+		}
+	}
+}
+
+// process counts line.
+func (f *logmatchFile) process(line string) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	// tail strips the newline of every line and doesn't send incomplete
+	// lines.
+	f.offset += int64(len(line)) + 1
+	if f.inode == 0 {
+		if fi, err := os.Stat(f.path); err == nil {
+			f.inode = fileInode(fi)
+		}
+	}
+	f.read++
+	if f.maxLineLength > 0 && len(line) > f.maxLineLength {
+		line = line[:f.maxLineLength]
+		f.truncated++
+	}
+	for i, r := range f.rules {
+		match := r.regex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		values := make([]string, len(r.groups))
+		for j, group := range r.groups {
+			values[j] = match[group]
+		}
+		key := strings.Join(values, "\xff")
+		c, ok := f.counts[i][key]
+		if !ok {
+			if len(f.counts[i]) >= f.maxLabelValues {
+				f.dropped[i]++
+				continue
+			}
+			c = &logmatchCount{values: values}
+			f.counts[i][key] = c
+		}
+		c.count++
+	}
+}
+
+func (f *logmatchFile) collect(ch chan<- prometheus.Metric, c *logmatchCollector) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.readDesc, prometheus.CounterValue, f.read, f.path)
+	ch <- prometheus.MustNewConstMetric(c.truncatedDesc, prometheus.CounterValue, f.truncated, f.path)
+	for i, r := range f.rules {
+		ch <- prometheus.MustNewConstMetric(c.droppedDesc, prometheus.CounterValue, f.dropped[i], f.path, r.name)
+		for _, count := range f.counts[i] {
+			labels := append([]string{f.path, r.name}, count.values...)
+			ch <- prometheus.MustNewConstMetric(r.desc, prometheus.CounterValue, count.count, labels...)
+		}
+	}
+}
+
+func (f *logmatchFile) state() logmatchFileState {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	s := logmatchFileState{
+		Inode:     f.inode,
+		Offset:    f.offset,
+		Read:      f.read,
+		Truncated: f.truncated,
+		Dropped:   map[string]float64{},
+	}
+	for i, r := range f.rules {
+		if f.dropped[i] > 0 {
+			s.Dropped[r.name] = f.dropped[i]
+		}
+		for _, count := range f.counts[i] {
+			cs := logmatchCountState{Rule: r.name, Count: count.count}
+			if len(r.labels) > 0 {
+				cs.Labels = make(map[string]string, len(r.labels))
+				for j, name := range r.labels {
+					cs.Labels[name] = count.values[j]
+				}
+			}
+			s.Counts = append(s.Counts, cs)
+		}
+	}
+	return s
+}
+
+// Describe implements DescribedCollector.
+func (c *logmatchCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.readDesc
+	ch <- c.truncatedDesc
+	ch <- c.droppedDesc
+	for _, f := range c.files {
+		for _, r := range f.rules {
+			ch <- r.desc
+		}
+	}
+}
+
+// Update implements Collector and saves the state of the log files.
+func (c *logmatchCollector) Update(ch chan<- prometheus.Metric) error {
+	for _, f := range c.files {
+		f.collect(ch, c)
+	}
+	return c.save()
+}
+
+// Close implements ClosingCollector, it stops following the log files and
+// saves their state.
+func (c *logmatchCollector) Close() {
+	for _, f := range c.files {
+		f.tail.Stop()
+	}
+	// Stopping tail also removes its inotify watch, calling Cleanup would
+	// remove the watch of the next tail of the same file.
+	c.wg.Wait()
+	if err := c.save(); err != nil {
+		log.Errorln("logmatch collector:", err)
+	}
+}
+
+// save writes the state file if the state changed since it was last saved.
+func (c *logmatchCollector) save() error {
+	state := logmatchState{Files: make(map[string]logmatchFileState, len(c.files))}
+	for _, f := range c.files {
+		state.Files[f.path] = f.state()
+	}
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	c.saveMtx.Lock()
+	defer c.saveMtx.Unlock()
+	if bytes.Equal(content, c.saved) {
+		return nil
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.stateFile), filepath.Base(c.stateFile))
+	if err != nil {
+		return fmt.Errorf("couldn't save state: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("couldn't save state: %s", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("couldn't save state: %s", err)
+	}
+	if err := os.Rename(tmp.Name(), c.stateFile); err != nil {
+		return fmt.Errorf("couldn't save state: %s", err)
+	}
+	c.saved = content
+	return nil
+}
+
+// tailLogger logs the messages of tail at debug level, and reports when tail
+// reopened the file after a rotation or truncation. The report is dropped once
+// done is closed, as nothing receives it anymore.
+type tailLogger struct {
+	path     string
+	reopened chan<- struct{}
+	done     <-chan struct{}
+}
+
+func (l tailLogger) Printf(format string, v ...interface{}) {
+	log.Debugf("logmatch collector: "+format, v...)
+	if strings.HasPrefix(format, "Successfully reopened") {
+		// The send blocks until follow handled it, so the position is reset
+		// before the first line of the reopened file is counted.
+		select {
+		case l.reopened <- struct{}{}:
+		case <-l.done:
+		}
+	}
+}
+
+func (l tailLogger) Print(v ...interface{})                 { log.Debug(v...) }
+func (l tailLogger) Println(v ...interface{})               { log.Debugln(v...) }
+func (l tailLogger) Fatal(v ...interface{})                 { log.Fatal(v...) }
+func (l tailLogger) Fatalf(format string, v ...interface{}) { log.Fatalf(format, v...) }
+func (l tailLogger) Fatalln(v ...interface{})               { log.Fatalln(v...) }
+func (l tailLogger) Panic(v ...interface{})                 { panic(fmt.Sprint(v...)) }
+func (l tailLogger) Panicf(format string, v ...interface{}) { panic(fmt.Sprintf(format, v...)) }
+func (l tailLogger) Panicln(v ...interface{})               { panic(fmt.Sprintln(v...)) }
+
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Ino
+	}
+	return 0
+}
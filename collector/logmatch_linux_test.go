@@ -0,0 +1,205 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// +build !nologmatch
+
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// logmatchCounts returns the values of the metrics of c by name and labels.
+func logmatchCounts(t *testing.T, c Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Update(ch)
+		close(ch)
+	}()
+	counts := map[string]float64{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := []string{}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() != "file" {
+				labels = append(labels, l.GetName()+"="+l.GetValue())
+			}
+		}
+		sort.Strings(labels)
+		counts[descName(m.Desc())+"{"+strings.Join(labels, ",")+"}"] = pb.GetCounter().GetValue()
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	return counts
+}
+
+// waitForCount waits until the metric of c reaches want.
+func waitForCount(t *testing.T, c Collector, metric string, want float64) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := logmatchCounts(t, c)[metric]
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s: want %v, got %v", metric, want, got)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLogmatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logmatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	logFile := filepath.Join(dir, "kubelet.log")
+	config := filepath.Join(dir, "logmatch.yml")
+	if err := ioutil.WriteFile(config, []byte(`
+files:
+- path: `+logFile+`
+  rules:
+  - name: error
+    regex: '^E\d{4} '
+  - name: level
+    regex: '^(?P<level>[IWEF])\d{4} '
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Lines written before the first start aren't counted.
+	appendLines(t, logFile, "E0101 old error")
+
+	defer func(config, state string, length, values int) {
+		*logmatchConfig, *logmatchStateFile = config, state
+		*logmatchMaxLineLength, *logmatchMaxLabelValues = length, values
+	}(*logmatchConfig, *logmatchStateFile, *logmatchMaxLineLength, *logmatchMaxLabelValues)
+	*logmatchConfig = config
+	*logmatchMaxLineLength = 20
+	*logmatchMaxLabelValues = 2
+
+	for _, state := range []string{"", "state.json"} {
+		*logmatchStateFile = state
+		if c, err := NewLogmatchCollector(); err == nil {
+			c.(ClosingCollector).Close()
+			t.Errorf("want an error for the state file %q", state)
+		}
+	}
+	*logmatchStateFile = filepath.Join(dir, "state.json")
+
+	c, err := NewLogmatchCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logFile, "E0102 error", "W0102 warning", "I0102 info", "E0102 "+strings.Repeat("x", 30))
+	waitForCount(t, c, "node_logmatch_read_lines_total{}", 4)
+	want := map[string]float64{
+		"node_logmatch_read_lines_total{}":                4,
+		"node_logmatch_truncated_lines_total{}":           1,
+		"node_logmatch_lines_total{rule=error}":           2,
+		"node_logmatch_lines_total{level=E,rule=level}":   2,
+		"node_logmatch_lines_total{level=W,rule=level}":   1,
+		"node_logmatch_dropped_matches_total{rule=error}": 0,
+		"node_logmatch_dropped_matches_total{rule=level}": 1,
+		"node_logmatch_lines_total{level=I,rule=level}":   0,
+	}
+	counts := logmatchCounts(t, c)
+	for metric, v := range want {
+		if counts[metric] != v {
+			t.Errorf("%s: want %v, got %v", metric, v, counts[metric])
+		}
+	}
+
+	// Rotation and truncation are followed.
+	if err := os.Rename(logFile, logFile+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logFile, "E0103 error after rotation")
+	waitForCount(t, c, "node_logmatch_lines_total{rule=error}", 3)
+	if err := os.Truncate(logFile, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, logFile, "E0104 error")
+	waitForCount(t, c, "node_logmatch_lines_total{rule=error}", 4)
+	c.(ClosingCollector).Close()
+
+	// The counts and position survive a restart.
+	appendLines(t, logFile, "E0105 error while stopped")
+	c, err = NewLogmatchCollector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(ClosingCollector).Close()
+	waitForCount(t, c, "node_logmatch_lines_total{rule=error}", 5)
+	appendLines(t, logFile, "E0106 error")
+	waitForCount(t, c, "node_logmatch_lines_total{rule=error}", 6)
+	if got := logmatchCounts(t, c)["node_logmatch_read_lines_total{}"]; got != 8 {
+		t.Errorf("want 8 lines read, got %v", got)
+	}
+}
+
+func TestTailLoggerAfterFollow(t *testing.T) {
+	done := make(chan struct{})
+	close(done)
+	l := tailLogger{path: "test.log", reopened: make(chan struct{}), done: done}
+	returned := make(chan struct{})
+	go func() {
+		l.Printf("Successfully reopened %s", "test.log")
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("reporting a reopened file blocked after follow returned")
+	}
+}
+
+func TestLogmatchRuleValidation(t *testing.T) {
+	for _, rc := range []logmatchRuleConfig{
+		{Regex: "error"},
+		{Name: "bad", Regex: "("},
+		{Name: "bad", Regex: "(?P<file>x)"},
+		{Name: "bad", Regex: "(?P<level>x)(?P<level>y)"},
+	} {
+		if _, err := newLogmatchRule(rc); err == nil {
+			t.Errorf("want an error for rule %+v", rc)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -43,6 +44,7 @@ type meminfoMetric struct {
 }
 
 type meminfoNumaCollector struct {
+	mtx         sync.Mutex // Protects metricDescs across concurrent scrapes.
 	metricDescs map[string]*prometheus.Desc
 }
 
@@ -63,19 +65,27 @@ func (c *meminfoNumaCollector) Update(ch chan<- prometheus.Metric) error {
 		return fmt.Errorf("couldn't get NUMA meminfo: %s", err)
 	}
 	for _, v := range metrics {
-		desc, ok := c.metricDescs[v.metricName]
-		if !ok {
-			desc = prometheus.NewDesc(
-				prometheus.BuildFQName(namespace, memInfoNumaSubsystem, v.metricName),
-				fmt.Sprintf("Memory information field %s.", v.metricName),
-				[]string{"node"}, nil)
-			c.metricDescs[v.metricName] = desc
-		}
+		desc := c.metricDesc(v.metricName)
 		ch <- prometheus.MustNewConstMetric(desc, v.metricType, v.value, v.numaNode)
 	}
 	return nil
 }
 
+func (c *meminfoNumaCollector) metricDesc(metricName string) *prometheus.Desc {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	desc, ok := c.metricDescs[metricName]
+	if !ok {
+		desc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, memInfoNumaSubsystem, metricName),
+			fmt.Sprintf("Memory information field %s.", metricName),
+			[]string{"node"}, nil)
+		c.metricDescs[metricName] = desc
+	}
+	return desc
+}
+
 func getMemInfoNuma() ([]meminfoMetric, error) {
 	var (
 		metrics []meminfoMetric
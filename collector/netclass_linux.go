@@ -41,7 +41,10 @@ func init() {
 
 // NewNetClassCollector returns a new Collector exposing network class stats.
 func NewNetClassCollector() (Collector, error) {
-	pattern := regexp.MustCompile(*netclassIgnoredDevices)
+	pattern, err := regexp.Compile(*netclassIgnoredDevices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.netclass.ignored-devices: %s", err)
+	}
 	return &netClassCollector{
 		subsystem:             "network",
 		ignoredDevicesPattern: pattern,
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"regexp"
 	"strconv"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -32,7 +33,9 @@ var (
 type netDevCollector struct {
 	subsystem             string
 	ignoredDevicesPattern *regexp.Regexp
-	metricDescs           map[string]*prometheus.Desc
+
+	mtx         sync.Mutex // Protects metricDescs across concurrent scrapes.
+	metricDescs map[string]*prometheus.Desc
 }
 
 func init() {
@@ -41,7 +44,10 @@ func init() {
 
 // NewNetDevCollector returns a new Collector exposing network device stats.
 func NewNetDevCollector() (Collector, error) {
-	pattern := regexp.MustCompile(*netdevIgnoredDevices)
+	pattern, err := regexp.Compile(*netdevIgnoredDevices)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.netdev.ignored-devices: %s", err)
+	}
 	return &netDevCollector{
 		subsystem:             "network",
 		ignoredDevicesPattern: pattern,
@@ -56,16 +62,7 @@ func (c *netDevCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 	for dev, devStats := range netDev {
 		for key, value := range devStats {
-			desc, ok := c.metricDescs[key]
-			if !ok {
-				desc = prometheus.NewDesc(
-					prometheus.BuildFQName(namespace, c.subsystem, key+"_total"),
-					fmt.Sprintf("Network device statistic %s.", key),
-					[]string{"device"},
-					nil,
-				)
-				c.metricDescs[key] = desc
-			}
+			desc := c.metricDesc(key)
 			v, err := strconv.ParseFloat(value, 64)
 			if err != nil {
 				return fmt.Errorf("invalid value %s in netstats: %s", value, err)
@@ -75,3 +72,20 @@ func (c *netDevCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 	return nil
 }
+
+func (c *netDevCollector) metricDesc(key string) *prometheus.Desc {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	desc, ok := c.metricDescs[key]
+	if !ok {
+		desc = prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, c.subsystem, key+"_total"),
+			fmt.Sprintf("Network device statistic %s.", key),
+			[]string{"device"},
+			nil,
+		)
+		c.metricDescs[key] = desc
+	}
+	return desc
+}
@@ -47,7 +47,10 @@ func init() {
 // NewNetStatCollector takes and returns
 // a new Collector exposing network stats.
 func NewNetStatCollector() (Collector, error) {
-	pattern := regexp.MustCompile(*netStatFields)
+	pattern, err := regexp.Compile(*netStatFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.netstat.fields: %s", err)
+	}
 	return &netStatCollector{
 		fieldPattern: pattern,
 	}, nil
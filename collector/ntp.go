@@ -47,6 +47,10 @@ var (
 
 type ntpCollector struct {
 	stratum, leap, rtt, offset, reftime, rootDelay, rootDispersion, sanity typedDesc
+
+	server                       string
+	protocolVersion, ipTTL       int
+	maxDistance, offsetTolerance time.Duration
 }
 
 func init() {
@@ -72,6 +76,11 @@ func NewNtpCollector() (Collector, error) {
 	}
 
 	return &ntpCollector{
+		server:          *ntpServer,
+		protocolVersion: *ntpProtocolVersion,
+		ipTTL:           *ntpIPTTL,
+		maxDistance:     *ntpMaxDistance,
+		offsetTolerance: *ntpOffsetTolerance,
 		stratum: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, ntpSubsystem, "stratum"),
 			"NTPD stratum.",
@@ -115,10 +124,17 @@ func NewNtpCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *ntpCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []typedDesc{c.stratum, c.leap, c.rtt, c.offset, c.reftime, c.rootDelay, c.rootDispersion, c.sanity} {
+		ch <- d.desc
+	}
+}
+
 func (c *ntpCollector) Update(ch chan<- prometheus.Metric) error {
-	resp, err := ntp.QueryWithOptions(*ntpServer, ntp.QueryOptions{
-		Version: *ntpProtocolVersion,
-		TTL:     *ntpIPTTL,
+	resp, err := ntp.QueryWithOptions(c.server, ntp.QueryOptions{
+		Version: c.protocolVersion,
+		TTL:     c.ipTTL,
 		Timeout: time.Second, // default `ntpdate` timeout
 	})
 	if err != nil {
@@ -144,7 +160,7 @@ func (c *ntpCollector) Update(ch chan<- prometheus.Metric) error {
 	// Here is SNTP packet sanity check that is exposed to move burden of
 	// configuration from node_exporter user to the developer.
 
-	maxerr := *ntpOffsetTolerance
+	maxerr := c.offsetTolerance
 	leapMidnightMutex.Lock()
 	if resp.Leap == ntp.LeapAddSecond || resp.Leap == ntp.LeapDelSecond {
 		// state of leapMidnight is cached as leap flag is dropped right after midnight
@@ -156,7 +172,7 @@ func (c *ntpCollector) Update(ch chan<- prometheus.Metric) error {
 	}
 	leapMidnightMutex.Unlock()
 
-	if resp.Validate() == nil && resp.RootDistance <= *ntpMaxDistance && resp.MinError <= maxerr {
+	if resp.Validate() == nil && resp.RootDistance <= c.maxDistance && resp.MinError <= maxerr {
 		ch <- c.sanity.mustNewConstMetric(1)
 	} else {
 		ch <- c.sanity.mustNewConstMetric(0)
@@ -26,6 +26,11 @@ var (
 	sysPath  = kingpin.Flag("path.sysfs", "sysfs mountpoint.").Default("/sys").String()
 )
 
+// ProcPath returns the mountpoint of the proc filesystem.
+func ProcPath() string {
+	return *procPath
+}
+
 func procFilePath(name string) string {
 	return path.Join(*procPath, name)
 }
@@ -31,6 +31,7 @@ type qdiscStatCollector struct {
 	drops      typedDesc
 	requeues   typedDesc
 	overlimits typedDesc
+	fixtures   string
 }
 
 var (
@@ -44,6 +45,7 @@ func init() {
 // NewQdiscStatCollector returns a new Collector exposing queuing discipline statistics.
 func NewQdiscStatCollector() (Collector, error) {
 	return &qdiscStatCollector{
+		fixtures: *collectorQdisc,
 		bytes: typedDesc{prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, "qdisc", "bytes_total"),
 			"Number of bytes sent.",
@@ -88,7 +90,7 @@ func (c *qdiscStatCollector) Update(ch chan<- prometheus.Metric) error {
 	var msgs []qdisc.QdiscInfo
 	var err error
 
-	fixtures := *collectorQdisc
+	fixtures := c.fixtures
 
 	if fixtures == "" {
 		msgs, err = qdisc.Get()
@@ -0,0 +1,268 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// The relabel actions, see the Prometheus documentation of relabel_config.
+const (
+	relabelReplace   = "replace"
+	relabelKeep      = "keep"
+	relabelDrop      = "drop"
+	relabelLabelDrop = "labeldrop"
+	relabelLabelMap  = "labelmap"
+)
+
+// relabelRule is a compiled utils.RelabelConfig.
+type relabelRule struct {
+	sourceLabels []model.LabelName
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+func newRelabelRule(cfg *utils.RelabelConfig) (*relabelRule, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("empty relabel config")
+	}
+	r := &relabelRule{
+		separator:   ";",
+		replacement: "$1",
+		targetLabel: cfg.TargetLabel,
+		action:      cfg.Action,
+	}
+	if r.action == "" {
+		r.action = relabelReplace
+	}
+	for _, l := range cfg.SourceLabels {
+		r.sourceLabels = append(r.sourceLabels, model.LabelName(l))
+	}
+	if cfg.Separator != nil {
+		r.separator = *cfg.Separator
+	}
+	if cfg.Replacement != nil {
+		r.replacement = *cfg.Replacement
+	}
+	regex := "(.*)"
+	if cfg.Regex != nil {
+		regex = *cfg.Regex
+	}
+	var err error
+	if r.regex, err = regexp.Compile("^(?:" + regex + ")$"); err != nil {
+		return nil, fmt.Errorf("invalid relabel regex %q: %s", regex, err)
+	}
+
+	switch r.action {
+	case relabelReplace:
+		if r.targetLabel == "" {
+			return nil, fmt.Errorf("relabel action %s requires a target_label", r.action)
+		}
+	case relabelKeep, relabelDrop:
+		if len(r.sourceLabels) == 0 {
+			return nil, fmt.Errorf("relabel action %s requires source_labels", r.action)
+		}
+	case relabelLabelDrop, relabelLabelMap:
+		if len(r.sourceLabels) > 0 || r.targetLabel != "" {
+			return nil, fmt.Errorf("relabel action %s only takes a regex", r.action)
+		}
+	default:
+		return nil, fmt.Errorf("unknown relabel action %q", r.action)
+	}
+	return r, nil
+}
+
+// relabelRulesFromConfig returns the relabel rules of every collector, its
+// own followed by the global ones.
+func relabelRulesFromConfig(cfg *utils.Config) (map[string][]*relabelRule, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	var global []*relabelRule
+	for _, rc := range cfg.RelabelConfigs {
+		r, err := newRelabelRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		global = append(global, r)
+	}
+
+	rules := map[string][]*relabelRule{}
+	for collector := range factories {
+		var own []*relabelRule
+		if cc := cfg.Collectors[collector]; cc != nil {
+			for _, rc := range cc.RelabelConfigs {
+				r, err := newRelabelRule(rc)
+				if err != nil {
+					return nil, fmt.Errorf("collector %s: %s", collector, err)
+				}
+				own = append(own, r)
+			}
+		}
+		if len(own)+len(global) > 0 {
+			rules[collector] = append(own, global...)
+		}
+	}
+	return rules, nil
+}
+
+// relabel applies rules to labels, which include the metric name. It returns
+// nil if the series is dropped, labels itself if it isn't modified.
+func relabel(labels model.LabelSet, rules []*relabelRule) model.LabelSet {
+	out := labels
+	copied := false
+	set := func(name model.LabelName, value model.LabelValue) {
+		if !copied {
+			out = labels.Clone()
+			copied = true
+		}
+		if value == "" {
+			delete(out, name)
+		} else {
+			out[name] = value
+		}
+	}
+
+	for _, r := range rules {
+		values := make([]string, 0, len(r.sourceLabels))
+		for _, name := range r.sourceLabels {
+			values = append(values, string(out[name]))
+		}
+		value := strings.Join(values, r.separator)
+
+		switch r.action {
+		case relabelKeep:
+			if !r.regex.MatchString(value) {
+				return nil
+			}
+		case relabelDrop:
+			if r.regex.MatchString(value) {
+				return nil
+			}
+		case relabelReplace:
+			match := r.regex.FindStringSubmatchIndex(value)
+			if match == nil {
+				continue
+			}
+			target := model.LabelName(r.regex.ExpandString(nil, r.targetLabel, value, match))
+			if !target.IsValid() {
+				continue
+			}
+			set(target, model.LabelValue(r.regex.ExpandString(nil, r.replacement, value, match)))
+		case relabelLabelDrop:
+			for name := range out {
+				if r.regex.MatchString(string(name)) {
+					set(name, "")
+				}
+			}
+		case relabelLabelMap:
+			// Map the labels as they were before this rule.
+			current := out.Clone()
+			for name, v := range current {
+				if r.regex.MatchString(string(name)) {
+					target := model.LabelName(r.regex.ReplaceAllString(string(name), r.replacement))
+					if target.IsValid() {
+						set(target, v)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// relabelMetrics applies the relabel rules of the named collector to metrics.
+// Dropped metrics are left out, modified ones are replaced. metrics is never
+// modified.
+func (n NodeCollector) relabelMetrics(name string, metrics []prometheus.Metric) []prometheus.Metric {
+	rules := n.relabelRules[name]
+	if len(rules) == 0 {
+		return metrics
+	}
+
+	relabeled := make([]prometheus.Metric, 0, len(metrics))
+	descs := map[*prometheus.Desc]model.LabelValue{}
+	for _, m := range metrics {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			// Keep the metric, so the registry reports the error.
+			relabeled = append(relabeled, m)
+			continue
+		}
+		desc := m.Desc()
+		metricName, ok := descs[desc]
+		if !ok {
+			metricName = model.LabelValue(descName(desc))
+			descs[desc] = metricName
+		}
+		labels := model.LabelSet{model.MetricNameLabel: metricName}
+		for _, l := range pb.Label {
+			labels[model.LabelName(l.GetName())] = model.LabelValue(l.GetValue())
+		}
+
+		out := relabel(labels, rules)
+		switch {
+		case out == nil:
+			continue
+		case out.Equal(labels):
+			relabeled = append(relabeled, m)
+			continue
+		case !model.IsValidMetricName(out[model.MetricNameLabel]):
+			log.Debugf("%s collector: dropping metric %s relabeled to invalid name %q", name, labels, out[model.MetricNameLabel])
+			continue
+		}
+
+		pb.Label = pb.Label[:0]
+		for l, v := range out {
+			if l != model.MetricNameLabel {
+				pb.Label = append(pb.Label, &dto.LabelPair{Name: proto.String(string(l)), Value: proto.String(string(v))})
+			}
+		}
+		sort.Slice(pb.Label, func(i, j int) bool { return pb.Label[i].GetName() < pb.Label[j].GetName() })
+		relabeled = append(relabeled, relabeledMetric{
+			desc: prometheus.NewDesc(string(out[model.MetricNameLabel]), descHelp(desc), nil, nil),
+			pb:   pb,
+		})
+	}
+	return relabeled
+}
+
+// relabeledMetric is a metric with its labels rewritten. Its descriptor has
+// no labels, as the registry only checks the labels of pedantic registries.
+type relabeledMetric struct {
+	desc *prometheus.Desc
+	pb   *dto.Metric
+}
+
+func (m relabeledMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+func (m relabeledMetric) Write(out *dto.Metric) error {
+	*out = *m.pb
+	return nil
+}
@@ -0,0 +1,163 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+func mustNewRelabelRules(t *testing.T, cfgs ...*utils.RelabelConfig) []*relabelRule {
+	var rules []*relabelRule
+	for _, cfg := range cfgs {
+		r, err := newRelabelRule(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+func TestRelabel(t *testing.T) {
+	input := model.LabelSet{"__name__": "node_netstat_Tcp_InErrs", "device": "eth0", "mode": "idle"}
+	for _, test := range []struct {
+		rules []*utils.RelabelConfig
+		want  model.LabelSet
+	}{
+		{
+			rules: []*utils.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: stringPtr("node_netstat_Tcp_.*"), Action: "keep"}},
+			want:  input,
+		},
+		{
+			rules: []*utils.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: stringPtr("node_netstat_Udp_.*"), Action: "keep"}},
+			want:  nil,
+		},
+		{
+			rules: []*utils.RelabelConfig{{SourceLabels: []string{"device", "mode"}, Regex: stringPtr("eth0;idle"), Action: "drop"}},
+			want:  nil,
+		},
+		{
+			rules: []*utils.RelabelConfig{{SourceLabels: []string{"device"}, Regex: stringPtr("eth(.*)"), TargetLabel: "index", Replacement: stringPtr("if$1")}},
+			want:  model.LabelSet{"__name__": "node_netstat_Tcp_InErrs", "device": "eth0", "mode": "idle", "index": "if0"},
+		},
+		{
+			rules: []*utils.RelabelConfig{{SourceLabels: []string{"__name__"}, Regex: stringPtr("node_netstat_(.*)"), TargetLabel: "__name__", Replacement: stringPtr("node_net_$1")}},
+			want:  model.LabelSet{"__name__": "node_net_Tcp_InErrs", "device": "eth0", "mode": "idle"},
+		},
+		{
+			rules: []*utils.RelabelConfig{{SourceLabels: []string{"device"}, TargetLabel: "mode", Replacement: stringPtr("")}},
+			want:  model.LabelSet{"__name__": "node_netstat_Tcp_InErrs", "device": "eth0"},
+		},
+		{
+			rules: []*utils.RelabelConfig{{Regex: stringPtr("mode|device"), Action: "labeldrop"}},
+			want:  model.LabelSet{"__name__": "node_netstat_Tcp_InErrs"},
+		},
+		{
+			rules: []*utils.RelabelConfig{{Regex: stringPtr("(dev)ice"), Replacement: stringPtr("${1}"), Action: "labelmap"}},
+			want:  model.LabelSet{"__name__": "node_netstat_Tcp_InErrs", "device": "eth0", "dev": "eth0", "mode": "idle"},
+		},
+	} {
+		got := relabel(input, mustNewRelabelRules(t, test.rules...))
+		if (got == nil) != (test.want == nil) || !got.Equal(test.want) {
+			t.Errorf("%+v: want %v, got %v", *test.rules[0], test.want, got)
+		}
+	}
+	if len(input) != 3 {
+		t.Errorf("want the input labels unchanged, got %v", input)
+	}
+}
+
+func TestNewRelabelRuleInvalid(t *testing.T) {
+	for _, cfg := range []*utils.RelabelConfig{
+		nil,
+		{Action: "unknown"},
+		{Action: "replace"},
+		{Action: "keep"},
+		{Action: "labeldrop", SourceLabels: []string{"mode"}},
+		{SourceLabels: []string{"mode"}, Regex: stringPtr("("), TargetLabel: "x"},
+	} {
+		if _, err := newRelabelRule(cfg); err == nil {
+			t.Errorf("want an error for %+v", cfg)
+		}
+	}
+}
+
+func TestRelabelMetrics(t *testing.T) {
+	nc := &NodeCollector{
+		Collectors: map[string]Collector{"test_many": manyCollector{n: 5}},
+		relabelRules: map[string][]*relabelRule{"test_many": mustNewRelabelRules(t,
+			&utils.RelabelConfig{SourceLabels: []string{"i"}, Regex: stringPtr("[0-2]"), Action: "keep"},
+			&utils.RelabelConfig{SourceLabels: []string{"i"}, Regex: stringPtr("2"), TargetLabel: "__name__", Replacement: stringPtr("node_test_two")},
+		)},
+	}
+	ch := make(chan prometheus.Metric)
+	go func() {
+		nc.Collect(ch)
+		close(ch)
+	}()
+
+	names := map[string]int{}
+	for m := range ch {
+		switch m.Desc() {
+		case scrapeDurationDesc, scrapeSuccessDesc, scrapeTimeoutDesc, scrapeSeriesDesc, scrapeSeriesLimitExceededDesc:
+			continue
+		}
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		if len(pb.Label) != 1 || pb.Label[0].GetName() != "i" || pb.GetGauge().GetValue() != 1 {
+			t.Errorf("want the label and value kept, got %v", pb)
+		}
+		names[descName(m.Desc())]++
+	}
+	if names["node_test_many"] != 2 || names["node_test_two"] != 1 || len(names) != 2 {
+		t.Errorf("want 2 node_test_many and 1 node_test_two series, got %v", names)
+	}
+}
+
+func TestRelabelRulesFromConfig(t *testing.T) {
+	global := &utils.RelabelConfig{Regex: stringPtr("mode"), Action: "labeldrop"}
+	rules, err := relabelRulesFromConfig(&utils.Config{
+		RelabelConfigs: []*utils.RelabelConfig{global},
+		Collectors: map[string]*utils.CollectorConfig{
+			"cpu": {RelabelConfigs: []*utils.RelabelConfig{{SourceLabels: []string{"cpu"}, Regex: stringPtr("0"), Action: "keep"}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu := rules["cpu"]; len(cpu) != 2 || cpu[0].action != relabelKeep || cpu[1].action != relabelLabelDrop {
+		t.Errorf("want the cpu rules followed by the global ones, got %v", cpu)
+	}
+	if len(rules["loadavg"]) != 1 {
+		t.Errorf("want the global rules for loadavg, got %v", rules["loadavg"])
+	}
+
+	_, err = relabelRulesFromConfig(&utils.Config{Collectors: map[string]*utils.CollectorConfig{
+		"cpu": {RelabelConfigs: []*utils.RelabelConfig{{Action: "unknown"}}},
+	}})
+	if err == nil {
+		t.Error("want an error for an invalid collector rule")
+	}
+}
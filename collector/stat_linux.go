@@ -72,6 +72,16 @@ func NewStatCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *statCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.intr
+	ch <- c.ctxt
+	ch <- c.forks
+	ch <- c.btime
+	ch <- c.procsRunning
+	ch <- c.procsBlocked
+}
+
 // Update implements Collector and exposes kernel and system statistics.
 func (c *statCollector) Update(ch chan<- prometheus.Metric) error {
 	fs, err := procfs.NewFS(*procPath)
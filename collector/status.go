@@ -0,0 +1,166 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CollectorStatus describes the configuration and the last update of a
+// registered collector.
+type CollectorStatus struct {
+	Name           string            `json:"name"`
+	Enabled        bool              `json:"enabled"`
+	DefaultEnabled bool              `json:"default_enabled"`
+	Options        map[string]string `json:"options"`
+	// The fields below are only set once the collector ran.
+	LastRun      *time.Time `json:"last_run,omitempty"`
+	LastDuration float64    `json:"last_duration_seconds"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastSeries   int        `json:"last_series"`
+}
+
+var (
+	lastRunsMtx sync.Mutex
+	lastRuns    = map[string]lastRun{}
+
+	// readyChecksMtx protects readyChecks.
+	readyChecksMtx sync.Mutex
+	// readyChecks holds the required collectors Ready is updating.
+	readyChecks = map[string]bool{}
+)
+
+type lastRun struct {
+	time     time.Time
+	duration time.Duration
+	err      error
+	series   int
+	// lastSuccess is kept across failed updates, zero if none succeeded.
+	lastSuccess time.Time
+}
+
+// recordRun records the outcome of an update of the named collector.
+func recordRun(name string, r updateResult) {
+	lastRunsMtx.Lock()
+	defer lastRunsMtx.Unlock()
+	now := time.Now()
+	lastSuccess := lastRuns[name].lastSuccess
+	if r.err == nil {
+		lastSuccess = now
+	}
+	lastRuns[name] = lastRun{
+		time:        now,
+		duration:    r.duration,
+		err:         r.err,
+		series:      len(r.metrics),
+		lastSuccess: lastSuccess,
+	}
+}
+
+// Ready returns an error unless the collectors can be scraped: every
+// collector updated in the background must have finished its first update,
+// and every required collector must have succeeded at least once. Ready only
+// reads the recorded updates. For required collectors updated on scrape that
+// didn't succeed yet, it starts a single update in the background, unless one
+// is already running, whose outcome is seen by the next call.
+func (n *NodeCollector) Ready(required []string) error {
+	for name, c := range n.Collectors {
+		if bc, ok := c.(*backgroundCollector); ok {
+			if r, _ := bc.result(); r.err == errNoUpdate {
+				return fmt.Errorf("%s collector didn't finish its first update", name)
+			}
+		}
+	}
+	for _, name := range required {
+		c, ok := n.Collectors[name]
+		if !ok {
+			return fmt.Errorf("required collector %s is disabled", name)
+		}
+		lastRunsMtx.Lock()
+		r, ran := lastRuns[name]
+		lastRunsMtx.Unlock()
+		if !r.lastSuccess.IsZero() {
+			continue
+		}
+		if _, ok := c.(*backgroundCollector); !ok {
+			n.startReadyCheck(name, c)
+		}
+		if !ran {
+			return fmt.Errorf("required collector %s didn't run yet", name)
+		}
+		return fmt.Errorf("required collector %s didn't succeed yet: %s", name, r.err)
+	}
+	return nil
+}
+
+// startReadyCheck updates the named collector in the background like a
+// scrape, including its backoff, unless Ready already updates it.
+func (n *NodeCollector) startReadyCheck(name string, c Collector) {
+	readyChecksMtx.Lock()
+	defer readyChecksMtx.Unlock()
+	if readyChecks[name] {
+		return
+	}
+	readyChecks[name] = true
+	go func() {
+		n.execute(name, c)
+		readyChecksMtx.Lock()
+		delete(readyChecks, name)
+		readyChecksMtx.Unlock()
+	}()
+}
+
+// Status returns the status of all registered collectors, sorted by name.
+// Options holds the collector flags without their "collector.<name>." prefix.
+func Status() []CollectorStatus {
+	flagMtx.Lock()
+	values := currentFlagValues()
+	statuses := make([]CollectorStatus, 0, len(collectorState))
+	for name, enabled := range collectorState {
+		statuses = append(statuses, CollectorStatus{
+			Name:           name,
+			Enabled:        *enabled,
+			DefaultEnabled: collectorDefaults[name],
+			Options:        map[string]string{},
+		})
+	}
+	flagMtx.Unlock()
+
+	lastRunsMtx.Lock()
+	defer lastRunsMtx.Unlock()
+	for i := range statuses {
+		s := &statuses[i]
+		prefix := "collector." + s.Name + "."
+		for flag, value := range values {
+			if strings.HasPrefix(flag, prefix) {
+				s.Options[strings.TrimPrefix(flag, prefix)] = value
+			}
+		}
+		if r, ok := lastRuns[s.Name]; ok {
+			t := r.time
+			s.LastRun = &t
+			s.LastDuration = r.duration.Seconds()
+			s.LastSeries = r.series
+			if r.err != nil {
+				s.LastError = r.err.Error()
+			}
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	enabled := true
+	for _, name := range []string{"test_fast", "test_slow"} {
+		collectorState[name] = &enabled
+		collectorDefaults[name] = false
+		defer delete(collectorState, name)
+		defer delete(collectorDefaults, name)
+		defer delete(lastRuns, name)
+	}
+
+	nc := &NodeCollector{
+		Collectors: map[string]Collector{
+			"test_fast": testCollector{},
+			"test_slow": testCollector{delay: time.Second},
+		},
+		timeouts: map[string]time.Duration{"test_slow": 10 * time.Millisecond},
+	}
+	scrapeResults(t, nc)
+
+	statuses := map[string]CollectorStatus{}
+	for _, s := range Status() {
+		statuses[s.Name] = s
+	}
+	fast, slow := statuses["test_fast"], statuses["test_slow"]
+	if !fast.Enabled || fast.DefaultEnabled {
+		t.Errorf("want test_fast enabled and disabled by default, got %+v", fast)
+	}
+	if fast.LastRun == nil || fast.LastSeries != 1 || fast.LastError != "" {
+		t.Errorf("want a successful run with 1 series for test_fast, got %+v", fast)
+	}
+	if slow.LastRun == nil || slow.LastSeries != 0 || slow.LastError == "" {
+		t.Errorf("want a timed out run for test_slow, got %+v", slow)
+	}
+	if _, ok := statuses["loadavg"].Options["interval"]; !ok {
+		t.Errorf("want the interval option for loadavg, got %v", statuses["loadavg"].Options)
+	}
+}
+
+func TestReady(t *testing.T) {
+	for _, name := range []string{"test_ok", "test_failing", "test_background"} {
+		defer delete(lastRuns, name)
+	}
+	updates := 0
+	bc := &backgroundCollector{name: "test_background", c: testCollector{}, last: updateResult{err: errNoUpdate}}
+	nc := &NodeCollector{Collectors: map[string]Collector{
+		"test_ok":         testCollector{},
+		"test_failing":    failingCollector{updates: &updates},
+		"test_background": bc,
+	}}
+
+	if err := nc.Ready(nil); err == nil {
+		t.Error("want an error before the first background update")
+	}
+	bc.update()
+	if err := nc.Ready(nil); err != nil {
+		t.Errorf("want ready once the background collector updated, got %s", err)
+	}
+	if err := nc.Ready([]string{"test_ok", "test_background"}); err == nil {
+		t.Error("want an error before the required collector ran")
+	}
+	waitReadyChecks(t)
+	if err := nc.Ready([]string{"test_ok", "test_background"}); err != nil || lastRuns["test_ok"].lastSuccess.IsZero() {
+		t.Errorf("want ready once the required collector succeeded, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := nc.Ready([]string{"test_failing"}); err == nil {
+			t.Error("want an error while a required collector fails")
+		}
+		waitReadyChecks(t)
+	}
+	if err := nc.Ready([]string{"test_failing"}); err == nil {
+		t.Error("want an error while a required collector fails")
+	}
+	waitReadyChecks(t)
+	if updates != 3 {
+		t.Errorf("want the failing required collector updated once per check, got %d updates", updates)
+	}
+	if err := nc.Ready([]string{"test_missing"}); err == nil {
+		t.Error("want an error for a disabled required collector")
+	}
+}
+
+func TestReadyRunsSingleCheck(t *testing.T) {
+	defer delete(lastRuns, "test_required")
+	var updates int32
+	c := blockingCollector{release: make(chan struct{}), updates: &updates}
+	nc := &NodeCollector{Collectors: map[string]Collector{"test_required": c}}
+
+	for i := 0; i < 5; i++ {
+		if err := nc.Ready([]string{"test_required"}); err == nil {
+			t.Error("want an error while the required collector runs")
+		}
+	}
+	for atomic.LoadInt32(&updates) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(c.release)
+	waitReadyChecks(t)
+	if got := atomic.LoadInt32(&updates); got != 1 {
+		t.Errorf("want a single update for concurrent checks, got %d", got)
+	}
+	if err := nc.Ready([]string{"test_required"}); err != nil {
+		t.Errorf("want ready once the update succeeded, got %s", err)
+	}
+}
+
+// waitReadyChecks waits for the updates started by Ready to finish.
+func waitReadyChecks(t *testing.T) {
+	for i := 0; ; i++ {
+		readyChecksMtx.Lock()
+		running := len(readyChecks)
+		readyChecksMtx.Unlock()
+		if running == 0 {
+			return
+		}
+		if i == 1000 {
+			t.Fatal("ready checks still running")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
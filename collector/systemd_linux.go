@@ -16,6 +16,7 @@
 package collector
 
 import (
+	"context"
 	"fmt"
 	"regexp"
 	"strings"
@@ -44,6 +45,7 @@ type systemdCollector struct {
 	socketRefusedConnectionsDesc  *prometheus.Desc
 	unitWhitelistPattern          *regexp.Regexp
 	unitBlacklistPattern          *regexp.Regexp
+	private                       bool
 }
 
 var unitStatesName = []string{"active", "activating", "deactivating", "inactive", "failed"}
@@ -87,8 +89,14 @@ func NewSystemdCollector() (Collector, error) {
 	socketRefusedConnectionsDesc := prometheus.NewDesc(
 		prometheus.BuildFQName(namespace, subsystem, "socket_refused_connections_total"),
 		"Total number of refused socket connections", []string{"name"}, nil)
-	unitWhitelistPattern := regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *unitWhitelist))
-	unitBlacklistPattern := regexp.MustCompile(fmt.Sprintf("^(?:%s)$", *unitBlacklist))
+	unitWhitelistPattern, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", *unitWhitelist))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.systemd.unit-whitelist: %s", err)
+	}
+	unitBlacklistPattern, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", *unitBlacklist))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.systemd.unit-blacklist: %s", err)
+	}
 
 	return &systemdCollector{
 		unitDesc:                      unitDesc,
@@ -102,11 +110,31 @@ func NewSystemdCollector() (Collector, error) {
 		socketRefusedConnectionsDesc:  socketRefusedConnectionsDesc,
 		unitWhitelistPattern:          unitWhitelistPattern,
 		unitBlacklistPattern:          unitBlacklistPattern,
+		private:                       *systemdPrivate,
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *systemdCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.unitDesc
+	ch <- c.unitStartTimeDesc
+	ch <- c.systemRunningDesc
+	ch <- c.summaryDesc
+	ch <- c.nRestartsDesc
+	ch <- c.timerLastTriggerDesc
+	ch <- c.socketAcceptedConnectionsDesc
+	ch <- c.socketCurrentConnectionsDesc
+	ch <- c.socketRefusedConnectionsDesc
+}
+
 func (c *systemdCollector) Update(ch chan<- prometheus.Metric) error {
-	allUnits, err := c.getAllUnits()
+	return c.UpdateContext(context.Background(), ch)
+}
+
+// UpdateContext implements ContextCollector. The D-Bus connections are closed
+// once ctx is done, so no more units are queried after a timeout.
+func (c *systemdCollector) UpdateContext(ctx context.Context, ch chan<- prometheus.Metric) error {
+	allUnits, err := c.getAllUnits(ctx)
 	if err != nil {
 		return fmt.Errorf("couldn't get units: %s", err)
 	}
@@ -120,7 +148,7 @@ func (c *systemdCollector) Update(ch chan<- prometheus.Metric) error {
 	c.collectTimers(ch, units)
 	c.collectSockets(ch, units)
 
-	systemState, err := c.getSystemState()
+	systemState, err := c.getSystemState(ctx)
 	if err != nil {
 		return fmt.Errorf("couldn't get system state: %s", err)
 	}
@@ -203,11 +231,33 @@ func (c *systemdCollector) collectSystemState(ch chan<- prometheus.Metric, syste
 	ch <- prometheus.MustNewConstMetric(c.systemRunningDesc, prometheus.GaugeValue, isSystemRunning)
 }
 
-func (c *systemdCollector) newDbus() (*dbus.Conn, error) {
-	if *systemdPrivate {
-		return dbus.NewSystemdConnection()
+// newDbus connects to systemd. The connection is closed once ctx is done or
+// the returned function is called.
+func (c *systemdCollector) newDbus(ctx context.Context) (*dbus.Conn, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	var (
+		conn *dbus.Conn
+		err  error
+	)
+	if c.private {
+		conn, err = dbus.NewSystemdConnection()
+	} else {
+		conn, err = dbus.New()
 	}
-	return dbus.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+	return conn, func() { close(done) }, nil
 }
 
 type unit struct {
@@ -220,12 +270,12 @@ type unit struct {
 	refusedConnections  *uint32
 }
 
-func (c *systemdCollector) getAllUnits() ([]unit, error) {
-	conn, err := c.newDbus()
+func (c *systemdCollector) getAllUnits(ctx context.Context) ([]unit, error) {
+	conn, closeConn, err := c.newDbus(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("couldn't get dbus connection: %s", err)
 	}
-	defer conn.Close()
+	defer closeConn()
 
 	// Filter out any units that are not installed and are pulled in only as dependencies.
 	allUnits, err := conn.ListUnits()
@@ -236,6 +286,9 @@ func (c *systemdCollector) getAllUnits() ([]unit, error) {
 
 	result := make([]unit, 0, len(allUnits))
 	for _, status := range allUnits {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		unit := unit{
 			UnitStatus: status,
 		}
@@ -332,12 +385,12 @@ func filterUnits(units []unit, whitelistPattern, blacklistPattern *regexp.Regexp
 	return filtered
 }
 
-func (c *systemdCollector) getSystemState() (state string, err error) {
-	conn, err := c.newDbus()
+func (c *systemdCollector) getSystemState(ctx context.Context) (state string, err error) {
+	conn, closeConn, err := c.newDbus(ctx)
 	if err != nil {
 		return "", fmt.Errorf("couldn't get dbus connection: %s", err)
 	}
 	state, err = conn.GetManagerProperty("SystemState")
-	conn.Close()
+	closeConn()
 	return state, err
 }
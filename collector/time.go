@@ -42,6 +42,11 @@ func NewTimeCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *timeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
 func (c *timeCollector) Update(ch chan<- prometheus.Metric) error {
 	now := float64(time.Now().UnixNano()) / 1e9
 	log.Debugf("Return time: %f", now)
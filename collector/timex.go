@@ -153,6 +153,16 @@ func NewTimexCollector() (Collector, error) {
 	}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c *timexCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []typedDesc{
+		c.offset, c.freq, c.maxerror, c.esterror, c.status, c.constant, c.tick, c.ppsfreq, c.jitter,
+		c.shift, c.stabil, c.jitcnt, c.calcnt, c.errcnt, c.stbcnt, c.tai, c.syncStatus,
+	} {
+		ch <- d.desc
+	}
+}
+
 func (c *timexCollector) Update(ch chan<- prometheus.Metric) error {
 	var syncStatus float64
 	var divisor float64
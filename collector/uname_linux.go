@@ -48,6 +48,11 @@ func newUnameCollector() (Collector, error) {
 	return &unameCollector{}, nil
 }
 
+// Describe implements DescribedCollector.
+func (c unameCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- unameDesc
+}
+
 func (c unameCollector) Update(ch chan<- prometheus.Metric) error {
 	var uname unix.Utsname
 	if err := unix.Uname(&uname); err != nil {
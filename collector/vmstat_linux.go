@@ -45,7 +45,10 @@ func init() {
 
 // NewvmStatCollector returns a new Collector exposing vmstat stats.
 func NewvmStatCollector() (Collector, error) {
-	pattern := regexp.MustCompile(*vmStatFields)
+	pattern, err := regexp.Compile(*vmStatFields)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --collector.vmstat.fields: %s", err)
+	}
 	return &vmStatCollector{
 		fieldPattern: pattern,
 	}, nil
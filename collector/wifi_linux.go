@@ -38,6 +38,8 @@ type wifiCollector struct {
 	stationTransmitRetriesTotal  *prometheus.Desc
 	stationTransmitFailedTotal   *prometheus.Desc
 	stationBeaconLossTotal       *prometheus.Desc
+
+	fixtures string
 }
 
 var (
@@ -69,6 +71,8 @@ func NewWifiCollector() (Collector, error) {
 	)
 
 	return &wifiCollector{
+		fixtures: *collectorWifi,
+
 		interfaceFrequencyHertz: prometheus.NewDesc(
 			prometheus.BuildFQName(namespace, subsystem, "interface_frequency_hertz"),
 			"The current frequency a WiFi interface is operating at, in hertz.",
@@ -142,7 +146,7 @@ func NewWifiCollector() (Collector, error) {
 }
 
 func (c *wifiCollector) Update(ch chan<- prometheus.Metric) error {
-	stat, err := newWifiStater(*collectorWifi)
+	stat, err := newWifiStater(c.fixtures)
 	if err != nil {
 		// Cannot access wifi metrics, report no error.
 		if os.IsNotExist(err) {
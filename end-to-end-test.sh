@@ -51,7 +51,7 @@ cd "$(dirname $0)"
 port="$((10000 + (RANDOM % 10000)))"
 tmpdir=$(mktemp -d /tmp/node_exporter_e2e_test.XXXXXX)
 
-skip_re="^(go_|node_exporter_build_info|node_scrape_collector_duration_seconds|process_|node_textfile_mtime_seconds)"
+skip_re="^(go_|node_exporter_build_info|node_exporter_config_last_reload_success_timestamp_seconds|node_scrape_collector_duration_seconds|process_|node_textfile_mtime_seconds)"
 
 arch="$(uname -m)"
 
@@ -0,0 +1,98 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// The JSON document is an array of metric families in the order gathered,
+// which is sorted by name. Sample values are strings, as JSON numbers can't
+// represent NaN and infinity, like in the Prometheus HTTP API.
+
+type jsonFamily struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help"`
+	Type    string       `json:"type"`
+	Unit    string       `json:"unit,omitempty"`
+	Metrics []jsonMetric `json:"metrics"`
+}
+
+type jsonMetric struct {
+	Labels map[string]string `json:"labels"`
+	// Value is set for counters, gauges and untyped metrics.
+	Value string `json:"value,omitempty"`
+	// Count and Sum are set for summaries and histograms.
+	Count     string            `json:"count,omitempty"`
+	Sum       string            `json:"sum,omitempty"`
+	Quantiles map[string]string `json:"quantiles,omitempty"`
+	Buckets   map[string]string `json:"buckets,omitempty"`
+	// TimestampMs is set for metrics with an explicit timestamp.
+	TimestampMs *int64 `json:"timestamp_ms,omitempty"`
+}
+
+// WriteJSON writes mfs as a JSON document.
+func WriteJSON(w io.Writer, mfs []*dto.MetricFamily) error {
+	families := make([]jsonFamily, 0, len(mfs))
+	for _, mf := range mfs {
+		family := jsonFamily{
+			Name:    mf.GetName(),
+			Help:    mf.GetHelp(),
+			Type:    strings.ToLower(mf.GetType().String()),
+			Unit:    Unit(mf),
+			Metrics: make([]jsonMetric, 0, len(mf.Metric)),
+		}
+		for _, m := range mf.Metric {
+			jm := jsonMetric{
+				Labels:      make(map[string]string, len(m.Label)),
+				TimestampMs: m.TimestampMs,
+			}
+			for _, l := range m.Label {
+				jm.Labels[l.GetName()] = l.GetValue()
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				jm.Value = formatFloat(m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				jm.Value = formatFloat(m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				jm.Count = formatFloat(float64(s.GetSampleCount()))
+				jm.Sum = formatFloat(s.GetSampleSum())
+				jm.Quantiles = make(map[string]string, len(s.GetQuantile()))
+				for _, q := range s.GetQuantile() {
+					jm.Quantiles[formatFloat(q.GetQuantile())] = formatFloat(q.GetValue())
+				}
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				jm.Count = formatFloat(float64(h.GetSampleCount()))
+				jm.Sum = formatFloat(h.GetSampleSum())
+				jm.Buckets = make(map[string]string, len(h.GetBucket())+1)
+				for _, b := range h.GetBucket() {
+					jm.Buckets[formatFloat(b.GetUpperBound())] = formatFloat(float64(b.GetCumulativeCount()))
+				}
+				jm.Buckets["+Inf"] = jm.Count
+			default:
+				jm.Value = formatFloat(m.GetUntyped().GetValue())
+			}
+			family.Metrics = append(family.Metrics, jm)
+		}
+		families = append(families, family)
+	}
+	return json.NewEncoder(w).Encode(families)
+}
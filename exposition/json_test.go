@@ -0,0 +1,36 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, testFamilies(t)); err != nil {
+		t.Fatal(err)
+	}
+	want := `[` +
+		`{"name":"node_test_bytes","help":"Test gauge.","type":"gauge","unit":"bytes","metrics":[{"labels":{},"value":"+Inf"}]},` +
+		`{"name":"node_test_duration_seconds","help":"Test histogram.","type":"histogram","unit":"seconds","metrics":[{"labels":{},"count":"2","sum":"2.25","buckets":{"+Inf":"2","0.5":"1","1":"1"}}]},` +
+		`{"name":"node_test_seconds_total","help":"Test counter\nwith a newline.","type":"counter","unit":"seconds","metrics":[{"labels":{"mode":"a\"b\\c"},"value":"1.5"}]},` +
+		`{"name":"node_test_size","help":"Test summary.","type":"summary","metrics":[{"labels":{},"count":"1","sum":"3","quantiles":{"0.5":"3"}}]},` +
+		`{"name":"node_test_untyped","help":"Test untyped.","type":"untyped","metrics":[{"labels":{},"value":"7"}]}` +
+		"]\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
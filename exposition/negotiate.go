@@ -0,0 +1,94 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exposition encodes gathered metric families in the exposition
+// formats not supported by the Prometheus client library, OpenMetrics and
+// JSON.
+package exposition
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Format is an exposition format.
+type Format string
+
+// The formats that can be requested. Text and protobuf are encoded by the
+// Prometheus client library.
+const (
+	FormatText        Format = "text"
+	FormatProtobuf    Format = "protobuf"
+	FormatOpenMetrics Format = "openmetrics"
+	FormatJSON        Format = "json"
+)
+
+// Content types of the formats encoded by this package.
+const (
+	OpenMetricsContentType = "application/openmetrics-text; version=0.0.1; charset=utf-8"
+	JSONContentType        = "application/json"
+)
+
+// mediaTypes maps the accepted media types to their format.
+var mediaTypes = map[string]Format{
+	"text/plain":                       FormatText,
+	"application/vnd.google.protobuf":  FormatProtobuf,
+	"application/openmetrics-text":     FormatOpenMetrics,
+	"application/json":                 FormatJSON,
+	"*/*":                              FormatText,
+	"text/*":                           FormatText,
+	"application/*":                    FormatText,
+	"application/x-protobuf":           FormatProtobuf,
+	"application/vnd.prometheus.proto": FormatProtobuf,
+}
+
+// ParseFormat parses the name of a format, as given in the format parameter.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatText, FormatProtobuf, FormatOpenMetrics, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown format %q, must be one of text, protobuf, openmetrics or json", s)
+}
+
+// Negotiate returns the format preferred by the Accept header of h, text if
+// there is no header or none of the media types is supported. Media types
+// with the same quality are preferred in the order given.
+func Negotiate(h http.Header) Format {
+	best, bestQ := FormatText, 0.0
+	for _, accept := range h["Accept"] {
+		for _, part := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil {
+				continue
+			}
+			f, ok := mediaTypes[mediaType]
+			if !ok {
+				continue
+			}
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+			if q > bestQ {
+				best, bestQ = f, q
+			}
+		}
+	}
+	return best
+}
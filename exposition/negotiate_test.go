@@ -0,0 +1,51 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	for accept, want := range map[string]Format{
+		"":                 FormatText,
+		"text/html":        FormatText,
+		"application/json": FormatJSON,
+		"*/*":              FormatText,
+		"application/openmetrics-text; version=0.0.1,text/plain;version=0.0.4;q=0.5,*/*;q=0.1":                                            FormatOpenMetrics,
+		"application/vnd.google.protobuf;proto=io.prometheus.client.MetricFamily;encoding=delimited;q=0.7,text/plain;version=0.0.4;q=0.3": FormatProtobuf,
+		"text/plain;q=0.5, application/json;q=0.9": FormatJSON,
+		"application/json;q=invalid, text/plain":   FormatText,
+	} {
+		h := http.Header{}
+		if accept != "" {
+			h.Set("Accept", accept)
+		}
+		if got := Negotiate(h); got != want {
+			t.Errorf("%q: want %s, got %s", accept, want, got)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for _, f := range []string{"text", "protobuf", "openmetrics", "json"} {
+		if _, err := ParseFormat(f); err != nil {
+			t.Errorf("%s: %s", f, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("want an error for an unknown format")
+	}
+}
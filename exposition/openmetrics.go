@@ -0,0 +1,191 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// units are the base units recognized as metric name suffixes, exposed as
+// the unit of a metric family.
+var units = []string{
+	"seconds", "bytes", "joules", "grams", "meters", "volts", "amperes",
+	"celsius", "hertz", "ratio",
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// Unit returns the unit of a metric family, derived from the suffix of its
+// name, or an empty string if it has none.
+func Unit(mf *dto.MetricFamily) string {
+	name := mf.GetName()
+	if mf.GetType() == dto.MetricType_COUNTER {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(name, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// CreatedFunc returns when the series of the metric family name were
+// created, or false if it isn't known.
+type CreatedFunc func(name string) (time.Time, bool)
+
+// WriteOpenMetrics writes mfs in the OpenMetrics text format. The client
+// library doesn't track when series were created, so the counters, summaries
+// and histograms only get _created samples when created knows of their
+// family, created may be nil.
+func WriteOpenMetrics(out io.Writer, mfs []*dto.MetricFamily, created CreatedFunc) error {
+	w := bufio.NewWriter(out)
+	for _, mf := range mfs {
+		writeOpenMetricsFamily(w, mf, created)
+	}
+	w.WriteString("# EOF\n")
+	return w.Flush()
+}
+
+func writeOpenMetricsFamily(w *bufio.Writer, mf *dto.MetricFamily, created CreatedFunc) {
+	name := mf.GetName()
+	// Counter families are named without the _total suffix of their samples.
+	family := name
+	if mf.GetType() == dto.MetricType_COUNTER {
+		family = strings.TrimSuffix(name, "_total")
+	}
+
+	fmt.Fprintf(w, "# TYPE %s %s\n", family, openMetricsType(mf.GetType()))
+	if unit := Unit(mf); unit != "" {
+		fmt.Fprintf(w, "# UNIT %s %s\n", family, unit)
+	}
+	if mf.Help != nil {
+		fmt.Fprintf(w, "# HELP %s %s\n", family, helpEscaper.Replace(mf.GetHelp()))
+	}
+	var createdAt float64
+	hasCreated := false
+	if created != nil && mf.GetType() != dto.MetricType_GAUGE && mf.GetType() != dto.MetricType_UNTYPED {
+		if t, ok := created(name); ok {
+			createdAt, hasCreated = float64(t.UnixNano())/1e9, true
+		}
+	}
+
+	for _, m := range mf.Metric {
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			writeOpenMetricsSample(w, family+"_total", m, "", "", m.GetCounter().GetValue())
+			if hasCreated {
+				writeOpenMetricsSample(w, family+"_created", m, "", "", createdAt)
+			}
+		case dto.MetricType_GAUGE:
+			writeOpenMetricsSample(w, name, m, "", "", m.GetGauge().GetValue())
+		case dto.MetricType_SUMMARY:
+			for _, q := range m.GetSummary().GetQuantile() {
+				writeOpenMetricsSample(w, name, m, "quantile", formatFloat(q.GetQuantile()), q.GetValue())
+			}
+			writeOpenMetricsSample(w, name+"_sum", m, "", "", m.GetSummary().GetSampleSum())
+			writeOpenMetricsSample(w, name+"_count", m, "", "", float64(m.GetSummary().GetSampleCount()))
+			if hasCreated {
+				writeOpenMetricsSample(w, name+"_created", m, "", "", createdAt)
+			}
+		case dto.MetricType_HISTOGRAM:
+			infSeen := false
+			for _, b := range m.GetHistogram().GetBucket() {
+				if math.IsInf(b.GetUpperBound(), +1) {
+					infSeen = true
+				}
+				writeOpenMetricsSample(w, name+"_bucket", m, "le", formatFloat(b.GetUpperBound()), float64(b.GetCumulativeCount()))
+			}
+			// OpenMetrics requires the +Inf bucket, which the client library
+			// leaves out.
+			if !infSeen {
+				writeOpenMetricsSample(w, name+"_bucket", m, "le", "+Inf", float64(m.GetHistogram().GetSampleCount()))
+			}
+			writeOpenMetricsSample(w, name+"_sum", m, "", "", m.GetHistogram().GetSampleSum())
+			writeOpenMetricsSample(w, name+"_count", m, "", "", float64(m.GetHistogram().GetSampleCount()))
+			if hasCreated {
+				writeOpenMetricsSample(w, name+"_created", m, "", "", createdAt)
+			}
+		default:
+			writeOpenMetricsSample(w, name, m, "", "", m.GetUntyped().GetValue())
+		}
+	}
+}
+
+func openMetricsType(t dto.MetricType) string {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return "counter"
+	case dto.MetricType_GAUGE:
+		return "gauge"
+	case dto.MetricType_SUMMARY:
+		return "summary"
+	case dto.MetricType_HISTOGRAM:
+		return "histogram"
+	}
+	return "unknown"
+}
+
+// writeOpenMetricsSample writes a sample of m, with an additional label if
+// extraName is not empty.
+func writeOpenMetricsSample(w *bufio.Writer, name string, m *dto.Metric, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+	if len(m.Label) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, l := range m.Label {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l.GetName(), labelValueEscaper.Replace(l.GetValue()))
+		}
+		if extraName != "" {
+			if len(m.Label) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	if m.TimestampMs != nil {
+		// OpenMetrics timestamps are in seconds.
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatFloat(float64(m.GetTimestampMs())/1000, 'f', -1, 64))
+	}
+	w.WriteByte('\n')
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exposition
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testFamilies returns metric families of every type.
+func testFamilies(t *testing.T) []*dto.MetricFamily {
+	r := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "node_test_seconds_total",
+		Help: "Test counter\nwith a newline.",
+	}, []string{"mode"})
+	counter.WithLabelValues(`a"b\c`).Add(1.5)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_test_bytes", Help: "Test gauge."})
+	gauge.Set(math.Inf(1))
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "node_test_duration_seconds",
+		Help:    "Test histogram.",
+		Buckets: []float64{0.5, 1},
+	})
+	histogram.Observe(0.25)
+	histogram.Observe(2)
+	summary := prometheus.NewSummary(prometheus.SummaryOpts{
+		Name:       "node_test_size",
+		Help:       "Test summary.",
+		Objectives: map[float64]float64{0.5: 0.05},
+	})
+	summary.Observe(3)
+	untyped := prometheus.NewUntypedFunc(prometheus.UntypedOpts{Name: "node_test_untyped", Help: "Test untyped."}, func() float64 { return 7 })
+	r.MustRegister(counter, gauge, histogram, summary, untyped)
+
+	mfs, err := r.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mfs
+}
+
+func TestWriteOpenMetrics(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, testFamilies(t), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := `# TYPE node_test_bytes gauge
+# UNIT node_test_bytes bytes
+# HELP node_test_bytes Test gauge.
+node_test_bytes +Inf
+# TYPE node_test_duration_seconds histogram
+# UNIT node_test_duration_seconds seconds
+# HELP node_test_duration_seconds Test histogram.
+node_test_duration_seconds_bucket{le="0.5"} 1
+node_test_duration_seconds_bucket{le="1"} 1
+node_test_duration_seconds_bucket{le="+Inf"} 2
+node_test_duration_seconds_sum 2.25
+node_test_duration_seconds_count 2
+# TYPE node_test_seconds counter
+# UNIT node_test_seconds seconds
+# HELP node_test_seconds Test counter\nwith a newline.
+node_test_seconds_total{mode="a\"b\\c"} 1.5
+# TYPE node_test_size summary
+# HELP node_test_size Test summary.
+node_test_size{quantile="0.5"} 3
+node_test_size_sum 3
+node_test_size_count 1
+# TYPE node_test_untyped unknown
+# HELP node_test_untyped Test untyped.
+node_test_untyped 7
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestWriteOpenMetricsCreated(t *testing.T) {
+	created := func(name string) (time.Time, bool) {
+		return time.Unix(1500000000, 0), name != "node_test_size"
+	}
+	var buf bytes.Buffer
+	if err := WriteOpenMetrics(&buf, testFamilies(t), created); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if strings.Contains(line, "_created") {
+			got = append(got, line)
+		}
+	}
+	want := []string{
+		`node_test_duration_seconds_created 1.5e+09`,
+		`node_test_seconds_created{mode="a\"b\\c"} 1.5e+09`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("want _created samples\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health serves the liveness and readiness endpoints. node_exporter is live
+// as long as it serves requests. It is ready once the initial configuration
+// loaded and the collectors are initialized, until it shuts down.
+type health struct {
+	// required are the collectors that must have succeeded once.
+	required []string
+
+	mtx          sync.RWMutex
+	h            *handler
+	shuttingDown bool
+}
+
+func newHealth(required []string) *health {
+	return &health{required: required}
+}
+
+// loaded records that the initial configuration loaded into h.
+func (hc *health) loaded(h *handler) {
+	hc.mtx.Lock()
+	defer hc.mtx.Unlock()
+	hc.h = h
+}
+
+// shutdown makes node_exporter unready for good.
+func (hc *health) shutdown() {
+	hc.mtx.Lock()
+	defer hc.mtx.Unlock()
+	hc.shuttingDown = true
+}
+
+// ready returns an error unless node_exporter is ready to be scraped.
+func (hc *health) ready() error {
+	hc.mtx.RLock()
+	h, shuttingDown := hc.h, hc.shuttingDown
+	hc.mtx.RUnlock()
+	switch {
+	case shuttingDown:
+		return errors.New("shutting down")
+	case h == nil:
+		return errors.New("configuration not loaded yet")
+	}
+	h.mtx.RLock()
+	nc := h.nc
+	h.mtx.RUnlock()
+	return nc.Ready(hc.required)
+}
+
+// live returns an error if a collection has been running for longer than
+// max, i.e. node_exporter is wedged.
+func (hc *health) live(max time.Duration) error {
+	hc.mtx.RLock()
+	h := hc.h
+	hc.mtx.RUnlock()
+	if h == nil {
+		return nil
+	}
+	if err := h.limiter.stalled(max); err != nil {
+		return err
+	}
+	h.mtx.RLock()
+	nc := h.nc
+	h.mtx.RUnlock()
+	return nc.Stalled(max)
+}
+
+func (hc *health) serveHealthy(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("node_exporter is Healthy.\n"))
+}
+
+func (hc *health) serveReady(w http.ResponseWriter, r *http.Request) {
+	if err := hc.ready(); err != nil {
+		http.Error(w, "node_exporter is not ready: "+err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("node_exporter is Ready.\n"))
+}
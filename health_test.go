@@ -0,0 +1,49 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/node_exporter/collector"
+)
+
+func TestHealthReady(t *testing.T) {
+	hc := newHealth(nil)
+	status := func() int {
+		w := httptest.NewRecorder()
+		hc.serveReady(w, httptest.NewRequest("GET", "/-/ready", nil))
+		return w.Code
+	}
+
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 before the configuration loaded, got %d", code)
+	}
+	hc.loaded(&handler{nc: &collector.NodeCollector{}})
+	if code := status(); code != http.StatusOK {
+		t.Errorf("want 200 once the configuration loaded, got %d", code)
+	}
+	hc.shutdown()
+	if code := status(); code != http.StatusServiceUnavailable {
+		t.Errorf("want 503 while shutting down, got %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	hc.serveHealthy(w, httptest.NewRequest("GET", "/-/healthy", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("want healthy while shutting down, got %d", w.Code)
+	}
+}
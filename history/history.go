@@ -0,0 +1,256 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package history keeps a short in-memory history of the metrics of the
+// node_exporter, to look at recent values when Prometheus is unavailable.
+package history
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+)
+
+const namespace = "node_exporter"
+
+// The memory use is estimated with these sizes, ignoring the overhead of the
+// maps and slices.
+const (
+	seriesBytes = 64
+	sampleBytes = 16
+)
+
+var (
+	seriesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "history", "series"),
+		"Number of series in the history.",
+		nil, nil,
+	)
+	samplesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "history", "samples"),
+		"Number of samples in the history.",
+		nil, nil,
+	)
+	bytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "history", "bytes"),
+		"Estimated memory used by the history.",
+		nil, nil,
+	)
+)
+
+type series struct {
+	metric model.Metric
+	bytes  int64
+	// refs is the number of snapshots with a sample of the series.
+	refs int
+}
+
+type sample struct {
+	series *series
+	value  model.SampleValue
+}
+
+// snapshot holds the samples gathered at once.
+type snapshot struct {
+	timestamp model.Time
+	samples   []sample
+}
+
+// Store is a ring buffer of snapshots of the gathered metrics. Snapshots are
+// evicted once they are older than the retention or the estimated memory use
+// exceeds the limit.
+type Store struct {
+	retention time.Duration
+	maxBytes  int64
+
+	mtx       sync.RWMutex
+	ring      []snapshot
+	head      int // Index of the oldest snapshot.
+	count     int
+	series    map[model.Fingerprint]*series
+	samples   int
+	bytes     int64
+	overLimit bool
+}
+
+// NewStore returns a Store keeping the snapshots of the last retention, taken
+// every interval, within maxBytes.
+func NewStore(retention, interval time.Duration, maxBytes int64) *Store {
+	return &Store{
+		retention: retention,
+		maxBytes:  maxBytes,
+		ring:      make([]snapshot, int(retention/interval)+1),
+		series:    map[model.Fingerprint]*series{},
+	}
+}
+
+// Run appends a snapshot of the metrics of g every interval until done is
+// closed.
+func (s *Store) Run(g prometheus.Gatherer, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		now := model.Now()
+		mfs, err := g.Gather()
+		if err != nil {
+			log.Warnln("Error gathering metrics, keeping the rest in the history:", err)
+		}
+		samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: now}, mfs...)
+		if err != nil {
+			log.Warnln("Error extracting samples, keeping the rest in the history:", err)
+		}
+		s.Append(now, samples)
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Append adds a snapshot of samples taken at ts, evicting the snapshots that
+// are out of the retention or over the memory limit.
+func (s *Store) Append(ts model.Time, samples model.Vector) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.count == len(s.ring) {
+		s.evict()
+	}
+	snap := snapshot{timestamp: ts, samples: make([]sample, 0, len(samples))}
+	for _, smpl := range samples {
+		fp := smpl.Metric.Fingerprint()
+		ser, ok := s.series[fp]
+		if !ok {
+			ser = &series{metric: smpl.Metric, bytes: seriesBytes}
+			for name, value := range smpl.Metric {
+				ser.bytes += int64(len(name) + len(value))
+			}
+			s.series[fp] = ser
+			s.bytes += ser.bytes
+		}
+		ser.refs++
+		snap.samples = append(snap.samples, sample{series: ser, value: smpl.Value})
+	}
+	s.ring[(s.head+s.count)%len(s.ring)] = snap
+	s.count++
+	s.samples += len(snap.samples)
+	s.bytes += int64(len(snap.samples)) * sampleBytes
+
+	minTime := ts.Add(-s.retention)
+	for s.count > 0 && s.ring[s.head].timestamp.Before(minTime) {
+		s.evict()
+	}
+	overLimit := false
+	for s.count > 0 && s.bytes > s.maxBytes {
+		overLimit = true
+		s.evict()
+	}
+	if overLimit && s.count == 0 && !s.overLimit {
+		log.Warnf("A single snapshot of %d samples exceeds the history memory limit of %d bytes, the history is empty", len(samples), s.maxBytes)
+	}
+	s.overLimit = overLimit && s.count == 0
+}
+
+// evict removes the oldest snapshot.
+func (s *Store) evict() {
+	snap := s.ring[s.head]
+	for _, smpl := range snap.samples {
+		smpl.series.refs--
+		if smpl.series.refs == 0 {
+			delete(s.series, smpl.series.metric.Fingerprint())
+			s.bytes -= smpl.series.bytes
+		}
+	}
+	s.samples -= len(snap.samples)
+	s.bytes -= int64(len(snap.samples)) * sampleBytes
+	s.ring[s.head] = snapshot{}
+	s.head = (s.head + 1) % len(s.ring)
+	s.count--
+}
+
+// Query returns the samples between start and end of the series matching any
+// of the selectors. With a step, samples closer than step to the previous
+// sample of their series are skipped.
+func (s *Store) Query(selectors [][]*Matcher, start, end model.Time, step time.Duration) model.Matrix {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	matches := map[*series]bool{}
+	streams := map[*series]*model.SampleStream{}
+	for i := 0; i < s.count; i++ {
+		snap := s.ring[(s.head+i)%len(s.ring)]
+		if snap.timestamp.Before(start) || snap.timestamp.After(end) {
+			continue
+		}
+		for _, smpl := range snap.samples {
+			match, ok := matches[smpl.series]
+			if !ok {
+				match = matchesAny(selectors, smpl.series.metric)
+				matches[smpl.series] = match
+			}
+			if !match {
+				continue
+			}
+			stream, ok := streams[smpl.series]
+			if !ok {
+				stream = &model.SampleStream{Metric: smpl.series.metric}
+				streams[smpl.series] = stream
+			}
+			if n := len(stream.Values); n > 0 && snap.timestamp.Sub(stream.Values[n-1].Timestamp) < step {
+				continue
+			}
+			stream.Values = append(stream.Values, model.SamplePair{Timestamp: snap.timestamp, Value: smpl.value})
+		}
+	}
+
+	matrix := make(model.Matrix, 0, len(streams))
+	for _, stream := range streams {
+		matrix = append(matrix, stream)
+	}
+	sort.Sort(matrix)
+	return matrix
+}
+
+func matchesAny(selectors [][]*Matcher, m model.Metric) bool {
+	for _, matchers := range selectors {
+		if MatchAll(matchers, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Describe implements prometheus.Collector.
+func (s *Store) Describe(ch chan<- *prometheus.Desc) {
+	ch <- seriesDesc
+	ch <- samplesDesc
+	ch <- bytesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (s *Store) Collect(ch chan<- prometheus.Metric) {
+	s.mtx.RLock()
+	series, samples, bytes := len(s.series), s.samples, s.bytes
+	s.mtx.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(seriesDesc, prometheus.GaugeValue, float64(series))
+	ch <- prometheus.MustNewConstMetric(samplesDesc, prometheus.GaugeValue, float64(samples))
+	ch <- prometheus.MustNewConstMetric(bytesDesc, prometheus.GaugeValue, float64(bytes))
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func testSnapshot(ts model.Time, value model.SampleValue) model.Vector {
+	return model.Vector{
+		{Metric: model.Metric{"__name__": "node_load1"}, Value: value, Timestamp: ts},
+		{Metric: model.Metric{"__name__": "node_cpu_seconds_total", "cpu": "0", "mode": "idle"}, Value: value, Timestamp: ts},
+		{Metric: model.Metric{"__name__": "node_cpu_seconds_total", "cpu": "0", "mode": "user"}, Value: value, Timestamp: ts},
+	}
+}
+
+func mustParseSelector(t *testing.T, s string) []*Matcher {
+	matchers, err := ParseSelector(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matchers
+}
+
+func TestStoreRetention(t *testing.T) {
+	s := NewStore(time.Minute, 15*time.Second, 1<<20)
+	for i := 0; i < 10; i++ {
+		ts := model.Time(i * 15000)
+		s.Append(ts, testSnapshot(ts, model.SampleValue(i)))
+	}
+	if s.count != 5 || s.samples != 15 || len(s.series) != 3 {
+		t.Fatalf("want 5 snapshots of 3 series, got %d snapshots, %d samples and %d series", s.count, s.samples, len(s.series))
+	}
+
+	m := s.Query([][]*Matcher{mustParseSelector(t, "node_load1")}, 0, model.Time(1<<62), 0)
+	if len(m) != 1 || len(m[0].Values) != 5 {
+		t.Fatalf("want 5 samples of node_load1, got %v", m)
+	}
+	if first := m[0].Values[0]; first.Timestamp != 75000 || first.Value != 5 {
+		t.Errorf("want the oldest sample to be 5 at 75000, got %v", first)
+	}
+}
+
+func TestStoreMaxBytes(t *testing.T) {
+	s := NewStore(time.Hour, 15*time.Second, 1<<20)
+	s.Append(0, testSnapshot(0, 0))
+	perSnapshot := s.bytes
+
+	s = NewStore(time.Hour, 15*time.Second, 3*perSnapshot)
+	for i := 0; i < 10; i++ {
+		ts := model.Time(i * 15000)
+		s.Append(ts, testSnapshot(ts, model.SampleValue(i)))
+	}
+	if s.bytes > 3*perSnapshot || s.count == 0 {
+		t.Errorf("want at most %d bytes, got %d bytes in %d snapshots", 3*perSnapshot, s.bytes, s.count)
+	}
+
+	s = NewStore(time.Hour, 15*time.Second, 1)
+	s.Append(0, testSnapshot(0, 0))
+	if s.count != 0 || s.bytes != 0 || len(s.series) != 0 {
+		t.Errorf("want an empty history if a snapshot exceeds the limit, got %d snapshots and %d bytes", s.count, s.bytes)
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s := NewStore(time.Hour, 15*time.Second, 1<<20)
+	for i := 0; i < 8; i++ {
+		ts := model.Time(i * 15000)
+		s.Append(ts, testSnapshot(ts, model.SampleValue(i)))
+	}
+
+	for _, test := range []struct {
+		selectors  []string
+		start, end model.Time
+		step       time.Duration
+		series     int
+		samples    int
+	}{
+		{selectors: []string{`node_cpu_seconds_total`}, end: 105000, series: 2, samples: 16},
+		{selectors: []string{`node_cpu_seconds_total{mode!="idle"}`}, end: 105000, series: 1, samples: 8},
+		{selectors: []string{`{__name__=~"node_.*",mode=~"idle|user"}`, `node_load1`}, end: 105000, series: 3, samples: 24},
+		{selectors: []string{`node_load1`}, start: 30000, end: 60000, series: 1, samples: 3},
+		{selectors: []string{`node_load1`}, end: 105000, step: 30 * time.Second, series: 1, samples: 4},
+		{selectors: []string{`node_memory_MemFree_bytes`}, end: 105000},
+	} {
+		var selectors [][]*Matcher
+		for _, sel := range test.selectors {
+			selectors = append(selectors, mustParseSelector(t, sel))
+		}
+		m := s.Query(selectors, test.start, test.end, test.step)
+		samples := 0
+		for _, stream := range m {
+			samples += len(stream.Values)
+		}
+		if len(m) != test.series || samples != test.samples {
+			t.Errorf("%v from %d to %d: want %d series and %d samples, got %d and %d",
+				test.selectors, test.start, test.end, test.series, test.samples, len(m), samples)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// MatchType is the operator of a Matcher.
+type MatchType string
+
+// The match types of PromQL.
+const (
+	MatchEqual     MatchType = "="
+	MatchNotEqual  MatchType = "!="
+	MatchRegexp    MatchType = "=~"
+	MatchNotRegexp MatchType = "!~"
+)
+
+// Matcher matches the value of a label.
+type Matcher struct {
+	Name  model.LabelName
+	Type  MatchType
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher returns a Matcher, regular expressions are anchored like in
+// PromQL.
+func NewMatcher(t MatchType, name model.LabelName, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Type: t, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Matches returns whether the label value v matches.
+func (m *Matcher) Matches(v model.LabelValue) bool {
+	switch m.Type {
+	case MatchEqual:
+		return string(v) == m.Value
+	case MatchNotEqual:
+		return string(v) != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(string(v))
+	case MatchNotRegexp:
+		return !m.re.MatchString(string(v))
+	}
+	panic("invalid match type " + m.Type)
+}
+
+// MatchAll returns whether m matches all matchers.
+func MatchAll(matchers []*Matcher, m model.Metric) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(m[matcher.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseSelector parses a PromQL series selector like
+// node_cpu_seconds_total{mode!="idle",cpu=~"0|1"}.
+func ParseSelector(s string) ([]*Matcher, error) {
+	p := &selectorParser{input: s}
+	matchers, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector %q: %s", s, err)
+	}
+	return matchers, nil
+}
+
+type selectorParser struct {
+	input string
+	pos   int
+}
+
+func (p *selectorParser) parse() ([]*Matcher, error) {
+	var matchers []*Matcher
+	p.skipSpace()
+	if name := p.name(true); name != "" {
+		m, _ := NewMatcher(MatchEqual, model.MetricNameLabel, name)
+		matchers = append(matchers, m)
+	}
+	p.skipSpace()
+	if p.consume("{") {
+		for {
+			p.skipSpace()
+			if p.consume("}") {
+				break
+			}
+			m, err := p.matcher()
+			if err != nil {
+				return nil, err
+			}
+			matchers = append(matchers, m)
+			p.skipSpace()
+			if p.consume("}") {
+				break
+			}
+			if !p.consume(",") {
+				return nil, fmt.Errorf("expected \",\" or \"}\" at position %d", p.pos)
+			}
+		}
+	}
+	p.skipSpace()
+	if p.pos != len(p.input) {
+		return nil, fmt.Errorf("unexpected %q at position %d", p.input[p.pos:], p.pos)
+	}
+	if len(matchers) == 0 {
+		return nil, fmt.Errorf("selector must contain a metric name or a label matcher")
+	}
+	return matchers, nil
+}
+
+func (p *selectorParser) matcher() (*Matcher, error) {
+	name := p.name(false)
+	if name == "" {
+		return nil, fmt.Errorf("expected label name at position %d", p.pos)
+	}
+	p.skipSpace()
+	var t MatchType
+	for _, op := range []MatchType{MatchRegexp, MatchNotRegexp, MatchNotEqual, MatchEqual} {
+		if p.consume(string(op)) {
+			t = op
+			break
+		}
+	}
+	if t == "" {
+		return nil, fmt.Errorf("expected match operator at position %d", p.pos)
+	}
+	p.skipSpace()
+	value, err := p.quoted()
+	if err != nil {
+		return nil, err
+	}
+	return NewMatcher(t, model.LabelName(name), value)
+}
+
+// name consumes a label name, or a metric name if colons are allowed.
+func (p *selectorParser) name(colons bool) string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || colons && c == ':' ||
+			p.pos > start && c >= '0' && c <= '9' {
+			p.pos++
+			continue
+		}
+		break
+	}
+	return p.input[start:p.pos]
+}
+
+// quoted consumes a double or single quoted string with Go escapes.
+func (p *selectorParser) quoted() (string, error) {
+	if p.pos >= len(p.input) || (p.input[p.pos] != '"' && p.input[p.pos] != '\'') {
+		return "", fmt.Errorf("expected quoted string at position %d", p.pos)
+	}
+	quote := p.input[p.pos]
+	for end := p.pos + 1; end < len(p.input); end++ {
+		switch p.input[end] {
+		case '\\':
+			end++
+		case quote:
+			s := p.input[p.pos+1 : end]
+			if quote == '\'' {
+				s = singleToDoubleQuoted(s)
+			}
+			value, err := strconv.Unquote(`"` + s + `"`)
+			if err != nil {
+				return "", fmt.Errorf("invalid string at position %d: %s", p.pos, err)
+			}
+			p.pos = end + 1
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("unterminated string at position %d", p.pos)
+}
+
+// singleToDoubleQuoted converts the contents of a single quoted string to
+// the contents of the equivalent double quoted one.
+func singleToDoubleQuoted(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		case s[i] == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func (p *selectorParser) consume(s string) bool {
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *selectorParser) skipSpace() {
+	for p.pos < len(p.input) && strings.ContainsRune(" \t\n", rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
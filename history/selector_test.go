@@ -0,0 +1,82 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package history
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestParseSelector(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []Matcher
+	}{
+		{
+			in:   "node_load1",
+			want: []Matcher{{Name: "__name__", Type: MatchEqual, Value: "node_load1"}},
+		},
+		{
+			in: ` node_cpu_seconds_total { mode != "idle", cpu=~'0|1' } `,
+			want: []Matcher{
+				{Name: "__name__", Type: MatchEqual, Value: "node_cpu_seconds_total"},
+				{Name: "mode", Type: MatchNotEqual, Value: "idle"},
+				{Name: "cpu", Type: MatchRegexp, Value: "0|1"},
+			},
+		},
+		{
+			in: `{mountpoint!~"/(sys|proc).*",device="a\"b",fstype='it\'s'}`,
+			want: []Matcher{
+				{Name: "mountpoint", Type: MatchNotRegexp, Value: "/(sys|proc).*"},
+				{Name: "device", Type: MatchEqual, Value: `a"b`},
+				{Name: "fstype", Type: MatchEqual, Value: "it's"},
+			},
+		},
+	} {
+		got, err := ParseSelector(test.in)
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+			continue
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%s: want %d matchers, got %d", test.in, len(test.want), len(got))
+			continue
+		}
+		for i, m := range got {
+			w := test.want[i]
+			if m.Name != w.Name || m.Type != w.Type || m.Value != w.Value {
+				t.Errorf("%s: want matcher %s%s%q, got %s%s%q", test.in, w.Name, w.Type, w.Value, m.Name, m.Type, m.Value)
+			}
+		}
+	}
+
+	for _, in := range []string{"", "{}", "node_load1{", `{mode="idle"`, `{mode=idle}`, `{mode=="idle"}`, `{mode=~"("}`, `node_load1 x`} {
+		if _, err := ParseSelector(in); err == nil {
+			t.Errorf("want an error for %q", in)
+		}
+	}
+}
+
+func TestMatcherAnchored(t *testing.T) {
+	m, err := NewMatcher(MatchRegexp, "mode", "idle|user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for v, want := range map[string]bool{"idle": true, "user": true, "idlex": false, "xuser": false} {
+		if got := m.Matches(model.LabelValue(v)); got != want {
+			t.Errorf("%s: want %v, got %v", v, want, got)
+		}
+	}
+}
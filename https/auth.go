@@ -0,0 +1,140 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package https
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxAuthCacheSize bounds the number of cached password checks.
+const maxAuthCacheSize = 1000
+
+// authCache remembers successful bcrypt comparisons, which are deliberately
+// slow, so clients scraping every few seconds don't pay for them each time.
+type authCache struct {
+	mtx   sync.Mutex
+	valid map[string]bool
+}
+
+func newAuthCache() *authCache {
+	return &authCache{valid: map[string]bool{}}
+}
+
+// compare reports whether secret matches the bcrypt hash.
+func (c *authCache) compare(hash, secret string) bool {
+	sum := sha256.Sum256([]byte(hash + "\x00" + secret))
+	key := hex.EncodeToString(sum[:])
+
+	c.mtx.Lock()
+	ok := c.valid[key]
+	c.mtx.Unlock()
+	if ok {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return false
+	}
+	c.mtx.Lock()
+	if len(c.valid) >= maxAuthCacheSize {
+		c.valid = map[string]bool{}
+	}
+	c.valid[key] = true
+	c.mtx.Unlock()
+	return true
+}
+
+// authenticate checks the credentials of r against cfg and returns the basic
+// auth user, empty for bearer tokens or if no authentication is configured.
+func authenticate(cfg *Config, cache *authCache, r *http.Request) (string, bool) {
+	if len(cfg.Users) == 0 && len(cfg.BearerTokens) == 0 {
+		return "", true
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		hash, exists := cfg.Users[user]
+		if !exists {
+			// Compare anyway, so unknown users take as long as known ones.
+			hash = "$2y$10$QOauhQNbBCuQDKes6eFzPeMqBSjb7Mr5DUmpZ/VcEd00UAV/LDeSi"
+		}
+		return user, cache.compare(hash, pass) && exists
+	}
+	auth := r.Header.Get("Authorization")
+	if token := strings.TrimPrefix(auth, "Bearer "); token != auth {
+		for _, hash := range cfg.BearerTokens {
+			if cache.compare(hash, token) {
+				return "", true
+			}
+		}
+	}
+	return "", false
+}
+
+// Unauthenticated exempts the requests to paths from authentication, for
+// probes that can't authenticate like health checks. It must be called before
+// serving.
+func (s *Server) Unauthenticated(paths ...string) {
+	if s.unauthenticated == nil {
+		s.unauthenticated = map[string]bool{}
+	}
+	for _, path := range paths {
+		s.unauthenticated[path] = true
+	}
+}
+
+// ServeHTTP implements http.Handler, authenticating the request and applying
+// the pprof settings before handing it on.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.unauthenticated[r.URL.Path] {
+		s.handler.ServeHTTP(w, r)
+		return
+	}
+
+	s.mtx.RLock()
+	cfg, cache := s.cfg, s.authCache
+	s.mtx.RUnlock()
+
+	user, ok := authenticate(cfg, cache, r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="node_exporter"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/debug/pprof") {
+		if cfg.Pprof.Disabled {
+			http.NotFound(w, r)
+			return
+		}
+		if len(cfg.Pprof.Users) > 0 && !contains(cfg.Pprof.Users, user) {
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+	}
+	s.handler.ServeHTTP(w, r)
+}
+
+func contains(users []string, user string) bool {
+	for _, u := range users {
+		if u != "" && u == user {
+			return true
+		}
+	}
+	return false
+}
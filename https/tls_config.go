@@ -0,0 +1,204 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package https serves the exporter over TLS and enforces authentication, as
+// configured by a reloadable web configuration file.
+package https
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the web configuration file.
+type Config struct {
+	TLSConfig TLSStruct `yaml:"tls_server_config"`
+	// Basic auth users mapped to the bcrypt hashes of their passwords.
+	Users map[string]string `yaml:"basic_auth_users"`
+	// Bcrypt hashes of accepted bearer tokens.
+	BearerTokens []string    `yaml:"bearer_tokens"`
+	Pprof        PprofStruct `yaml:"pprof"`
+}
+
+// TLSStruct holds the TLS settings of the listener.
+type TLSStruct struct {
+	TLSCertPath string `yaml:"cert_file"`
+	TLSKeyPath  string `yaml:"key_file"`
+	ClientAuth  string `yaml:"client_auth_type"`
+	ClientCAs   string `yaml:"client_ca_file"`
+}
+
+// PprofStruct controls access to the /debug/pprof endpoints.
+type PprofStruct struct {
+	Disabled bool `yaml:"disabled"`
+	// Basic auth users allowed to access pprof, any authenticated client if
+	// empty.
+	Users []string `yaml:"users"`
+}
+
+func (c *TLSStruct) enabled() bool {
+	return c.TLSCertPath != "" || c.TLSKeyPath != ""
+}
+
+func getConfig(configPath string) (*Config, error) {
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(content, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// ConfigToTLSConfig builds the server TLS configuration from c.
+func ConfigToTLSConfig(c *TLSStruct) (*tls.Config, error) {
+	if c.TLSCertPath == "" {
+		return nil, errors.New("missing cert_file")
+	}
+	if c.TLSKeyPath == "" {
+		return nil, errors.New("missing key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLSCertPath, c.TLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load X509KeyPair: %s", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if c.ClientCAs != "" {
+		clientCAPool := x509.NewCertPool()
+		clientCAFile, err := ioutil.ReadFile(c.ClientCAs)
+		if err != nil {
+			return nil, err
+		}
+		if !clientCAPool.AppendCertsFromPEM(clientCAFile) {
+			return nil, fmt.Errorf("no certificates found in client_ca_file %s", c.ClientCAs)
+		}
+		cfg.ClientCAs = clientCAPool
+	}
+
+	switch c.ClientAuth {
+	case "", "NoClientCert":
+		cfg.ClientAuth = tls.NoClientCert
+	case "RequestClientCert":
+		cfg.ClientAuth = tls.RequestClientCert
+	case "RequireAnyClientCert":
+		cfg.ClientAuth = tls.RequireAnyClientCert
+	case "VerifyClientCertIfGiven":
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case "RequireAndVerifyClientCert":
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid client_auth_type: %s", c.ClientAuth)
+	}
+	if cfg.ClientCAs == nil && (cfg.ClientAuth == tls.VerifyClientCertIfGiven || cfg.ClientAuth == tls.RequireAndVerifyClientCert) {
+		return nil, fmt.Errorf("client_auth_type %s requires a client_ca_file", c.ClientAuth)
+	}
+	return cfg, nil
+}
+
+// Server wraps an http.Handler with the settings of a web configuration file.
+// Certificates, users and pprof access can be changed by reloading the file,
+// turning TLS on or off requires a restart.
+type Server struct {
+	configPath string
+	handler    http.Handler
+
+	mtx       sync.RWMutex
+	cfg       *Config
+	tlsConfig *tls.Config
+	authCache *authCache
+
+	// unauthenticated are the paths served without authentication.
+	unauthenticated map[string]bool
+}
+
+// NewServer loads the web configuration file at configPath and returns a
+// Server handing authenticated requests to handler. An empty configPath
+// serves plain HTTP without authentication.
+func NewServer(configPath string, handler http.Handler) (*Server, error) {
+	s := &Server{configPath: configPath, handler: handler}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload reads the web configuration file again. The current configuration
+// is kept if the file is invalid.
+func (s *Server) Reload() error {
+	cfg := &Config{}
+	if s.configPath != "" {
+		var err error
+		cfg, err = getConfig(s.configPath)
+		if err != nil {
+			return fmt.Errorf("couldn't load web configuration file %s: %s", s.configPath, err)
+		}
+	}
+	var tlsConfig *tls.Config
+	if cfg.TLSConfig.enabled() {
+		var err error
+		tlsConfig, err = ConfigToTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return fmt.Errorf("invalid TLS configuration in %s: %s", s.configPath, err)
+		}
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.cfg != nil && (s.tlsConfig == nil) != (tlsConfig == nil) {
+		return errors.New("enabling or disabling TLS requires a restart")
+	}
+	s.cfg = cfg
+	s.tlsConfig = tlsConfig
+	s.authCache = newAuthCache()
+	return nil
+}
+
+func (s *Server) getTLSConfig() *tls.Config {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.tlsConfig
+}
+
+// Serve accepts connections on l and serves them with srv, over TLS if the
+// web configuration enables it. The handler of srv is replaced by s.
+func (s *Server) Serve(srv *http.Server, l net.Listener) error {
+	srv.Handler = s
+	if s.getTLSConfig() == nil {
+		return srv.Serve(l)
+	}
+	// Look up the TLS configuration on every handshake, so reloaded
+	// certificates apply to new connections.
+	srv.TLSConfig = &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.getTLSConfig(), nil
+		},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &s.getTLSConfig().Certificates[0], nil
+		},
+	}
+	return srv.ServeTLS(l, "", "")
+}
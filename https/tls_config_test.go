@@ -0,0 +1,218 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package https
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Bcrypt hashes of "secret" and "token".
+const (
+	secretHash = "$2a$04$P1pxeZa7O0tTULtkESKmR.cHHn6fWwZrQxefDCEztqbNWnBy0YwR6"
+	tokenHash  = "$2a$04$c5XYt8GbEY0q8tHNOjV.W.dgTDOO7JI8.TBbLFLTtkfDFHsLe85MW"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuthentication(t *testing.T) {
+	dir, err := ioutil.TempDir("", "https")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := writeFile(t, dir, "web.yml", fmt.Sprintf(`
+basic_auth_users:
+  alice: %s
+  bob: %s
+bearer_tokens:
+- %s
+pprof:
+  users: [alice]
+`, secretHash, secretHash, tokenHash))
+
+	s, err := NewServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Unauthenticated("/-/healthy")
+
+	for _, test := range []struct {
+		path, user, password, token string
+		code                        int
+	}{
+		{path: "/metrics", code: http.StatusUnauthorized},
+		{path: "/metrics", user: "alice", password: "secret", code: http.StatusOK},
+		{path: "/metrics", user: "alice", password: "wrong", code: http.StatusUnauthorized},
+		{path: "/metrics", user: "eve", password: "secret", code: http.StatusUnauthorized},
+		{path: "/metrics", token: "token", code: http.StatusOK},
+		{path: "/metrics", token: "wrong", code: http.StatusUnauthorized},
+		{path: "/debug/pprof/", user: "alice", password: "secret", code: http.StatusOK},
+		{path: "/debug/pprof/", user: "bob", password: "secret", code: http.StatusForbidden},
+		{path: "/debug/pprof/", token: "token", code: http.StatusForbidden},
+		{path: "/-/healthy", code: http.StatusOK},
+		{path: "/-/healthy/", code: http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest("GET", test.path, nil)
+		if test.user != "" {
+			r.SetBasicAuth(test.user, test.password)
+		}
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s as %q/%q: want status %d, got %d", test.path, test.user, test.token, test.code, w.Code)
+		}
+	}
+
+	writeFile(t, dir, "web.yml", "pprof:\n  disabled: true\n")
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/debug/pprof/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("want disabled pprof to return %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	writeFile(t, dir, "web.yml", "unknown_field: true\n")
+	if err := s.Reload(); err == nil {
+		t.Error("want an error reloading an invalid web configuration")
+	}
+}
+
+// writeCertificate writes a self-signed certificate for 127.0.0.1 and its key.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "node_exporter test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := writeFile(t, dir, "cert.pem", string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	keyFile := writeFile(t, dir, "key.pem", string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})))
+	return cert, keyFile
+}
+
+func TestTLSClientAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "https")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := writeCertificate(t, dir)
+	config := writeFile(t, dir, "web.yml", fmt.Sprintf(`
+tls_server_config:
+  cert_file: %s
+  key_file: %s
+  client_ca_file: %s
+  client_auth_type: RequireAndVerifyClientCert
+`, cert, key, cert))
+
+	s, err := NewServer(config, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{}
+	go s.Serve(srv, l)
+	defer srv.Close()
+
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(mustParse(t, pair))
+
+	url := "https://" + l.Addr().String() + "/metrics"
+	for _, test := range []struct {
+		certs []tls.Certificate
+		ok    bool
+	}{
+		{certs: nil, ok: false},
+		{certs: []tls.Certificate{pair}, ok: true},
+	} {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: test.certs,
+		}}}
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if ok := err == nil && resp.StatusCode == http.StatusOK; ok != test.ok {
+			t.Errorf("with %d client certificates: want success %v, got error %v", len(test.certs), test.ok, err)
+		}
+	}
+
+	writeFile(t, dir, "web.yml", "")
+	if err := s.Reload(); err == nil {
+		t.Error("want an error disabling TLS on reload")
+	}
+}
+
+func mustParse(t *testing.T, pair tls.Certificate) *x509.Certificate {
+	c, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
@@ -0,0 +1,78 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/node_exporter/collector"
+)
+
+var landingTemplate = template.Must(template.New("landing").Parse(`<html>
+<head><title>Node Exporter</title></head>
+<body>
+<h1>Node Exporter</h1>
+<p>{{.Version}}</p>
+<h2>Endpoints</h2>
+<ul>
+<li><a href="{{.MetricsPath}}">{{.MetricsPath}}</a></li>
+{{range .Profiles}}<li><a href="{{.Path}}">{{.Path}}</a> (profile {{.Name}})</li>
+{{end}}<li><a href="/api/v1/collectors">/api/v1/collectors</a></li>
+<li><a href="/api/v1/alerts">/api/v1/alerts</a></li>
+<li><a href="/-/healthy">/-/healthy</a></li>
+<li><a href="/-/ready">/-/ready</a></li>
+</ul>
+<h2>Collectors</h2>
+<table border="1" cellpadding="4">
+<tr><th>Name</th><th>Enabled</th><th>Default</th><th>Last duration</th><th>Last series</th><th>Last error</th></tr>
+{{range .Collectors}}<tr><td>{{.Name}}</td><td>{{.Enabled}}</td><td>{{.DefaultEnabled}}</td><td>{{if .LastRun}}{{printf "%.3fs" .LastDuration}}{{end}}</td><td>{{if .LastRun}}{{.LastSeries}}{{end}}</td><td>{{.LastError}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+type landingProfile struct {
+	Name, Path string
+}
+
+// serveLanding serves the landing page, linking to the metrics endpoints and
+// listing the registered collectors.
+func (h *handler) serveLanding(w http.ResponseWriter, r *http.Request) {
+	h.mtx.RLock()
+	profiles := make([]landingProfile, 0, len(h.profiles))
+	for path, p := range h.profiles {
+		profiles = append(profiles, landingProfile{Name: p.name, Path: path})
+	}
+	h.mtx.RUnlock()
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Path < profiles[j].Path })
+
+	err := landingTemplate.Execute(w, struct {
+		Version     string
+		MetricsPath string
+		Profiles    []landingProfile
+		Collectors  []collector.CollectorStatus
+	}{
+		Version:     version.Info(),
+		MetricsPath: h.metricsPath,
+		Profiles:    profiles,
+		Collectors:  collector.Status(),
+	})
+	if err != nil {
+		log.Errorln("Couldn't render landing page:", err)
+	}
+}
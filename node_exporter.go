@@ -14,64 +14,492 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"net/url"
+	"os"
+	"os/signal"
 	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
 	"github.com/prometheus/common/log"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/node_exporter/alerting"
 	"github.com/prometheus/node_exporter/collector"
+	"github.com/prometheus/node_exporter/exposition"
+	"github.com/prometheus/node_exporter/history"
+	"github.com/prometheus/node_exporter/https"
+	"github.com/prometheus/node_exporter/push"
+	"github.com/prometheus/node_exporter/servicecheck"
+	"github.com/prometheus/node_exporter/utils"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
+var (
+	configSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "node_exporter",
+		Name:      "config_last_reload_successful",
+		Help:      "Whether the last configuration reload attempt was successful.",
+	})
+	configSuccessTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "node_exporter",
+		Name:      "config_last_reload_success_timestamp_seconds",
+		Help:      "Timestamp of the last successful configuration reload.",
+	})
+)
+
 func init() {
 	prometheus.MustRegister(version.NewCollector("node_exporter"))
+	prometheus.MustRegister(configSuccess, configSuccessTime)
+	prometheus.MustRegister(push.SinkMetrics...)
+	prometheus.MustRegister(alerting.Metrics...)
+	prometheus.MustRegister(utils.EmailMetrics...)
+	prometheus.MustRegister(servicecheck.Metrics...)
+	prometheus.MustRegister(scrapesCoalesced, scrapesRejected, scrapesInProgress)
+}
+
+// handler serves the metrics of a NodeCollector, restricted to the collectors
+// selected by the collect[] and exclude[] parameters, and the profiles of the
+// configuration file. The NodeCollector is created once from the configuration
+// file and replaced on every reload, along with the sinks pushing its metrics,
+// the alerting rules evaluated on them and the service checks.
+type handler struct {
+	configFile  string
+	metricsPath string
+	limiter     *scrapeLimiter
+
+	mtx      sync.RWMutex
+	nc       *collector.NodeCollector
+	profiles map[string]*profile
+	sinks    []*push.Pusher
+	// alerts is nil if no alerting rules are configured.
+	alerts *alerting.Manager
+	// checks is nil if no service checks are configured.
+	checks *servicecheck.Manager
+	// targetLabels are added to every series.
+	targetLabels map[string]string
+
+	// instrumented holds the instrumented handler of every profile name seen
+	// so far, as the handler metrics can only be registered once.
+	instrumentedMtx sync.Mutex
+	instrumented    map[string]http.Handler
+}
+
+func newHandler(configFile, metricsPath string, limiter *scrapeLimiter) (*handler, error) {
+	h := &handler{
+		configFile:   configFile,
+		metricsPath:  metricsPath,
+		limiter:      limiter,
+		instrumented: map[string]http.Handler{},
+	}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
 }
 
-func handler(w http.ResponseWriter, r *http.Request) {
-	filters := r.URL.Query()["collect[]"]
+// reload creates new collectors from the configuration file and swaps them
+// in. The running collectors are kept if the configuration is invalid.
+func (h *handler) reload() error {
+	var cfg *utils.Config
+	if h.configFile != "" {
+		var err error
+		cfg, err = utils.ParseConfig(h.configFile)
+		if err != nil {
+			return fmt.Errorf("couldn't load configuration file %s: %s", h.configFile, err)
+		}
+	}
+	nc, err := collector.NewNodeCollectorFromConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("couldn't create collector: %s", err)
+	}
+	profiles, err := newProfiles(cfg, nc, h.metricsPath)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("couldn't create profiles: %s", err)
+	}
+	targetLabels, err := newTargetLabels(cfg)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("couldn't read target labels: %s", err)
+	}
+	var (
+		sinksConfig    *utils.SinksConfig
+		alertingConfig *utils.AlertingConfig
+		checksConfig   *utils.ServiceChecksConfig
+	)
+	if cfg != nil {
+		sinksConfig = cfg.Sinks
+		alertingConfig = cfg.Alerting
+		checksConfig = cfg.ServiceChecks
+	}
+	sinks, err := push.NewSinks(sinksConfig, h)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("couldn't create sinks: %s", err)
+	}
+	alerts, err := alerting.NewManager(alertingConfig, h)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("couldn't load alerting rules: %s", err)
+	}
+	checks, err := servicecheck.NewManager(checksConfig, collector.ProcPath())
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("couldn't load service checks: %s", err)
+	}
+
+	h.mtx.Lock()
+	old, oldSinks, oldAlerts, oldChecks := h.nc, h.sinks, h.alerts, h.checks
+	h.nc = nc
+	h.profiles = profiles
+	h.sinks = sinks
+	h.alerts = alerts
+	h.checks = checks
+	h.targetLabels = targetLabels
+	h.mtx.Unlock()
+	// The old sinks and rules gather from the handler, so they are stopped
+	// without holding the lock.
+	for _, s := range oldSinks {
+		s.Stop()
+	}
+	if oldAlerts != nil {
+		oldAlerts.Stop()
+	}
+	if oldChecks != nil {
+		oldChecks.Stop()
+	}
+	if old != nil {
+		old.Close()
+	}
+	for _, s := range sinks {
+		s.Start()
+	}
+	if alerts != nil {
+		alerts.Start(oldAlerts)
+	}
+	if checks != nil {
+		checks.Start(oldChecks)
+	}
+
+	log.Infof("Enabled collectors:")
+	collectors := []string{}
+	for n := range nc.Collectors {
+		collectors = append(collectors, n)
+	}
+	sort.Strings(collectors)
+	for _, n := range collectors {
+		log.Infof(" - %s", n)
+	}
+	return nil
+}
+
+// Close stops the sinks, the alerting rules, the service checks and the
+// background collectors.
+func (h *handler) Close() {
+	h.mtx.Lock()
+	nc, sinks, alerts, checks := h.nc, h.sinks, h.alerts, h.checks
+	h.sinks, h.alerts, h.checks = nil, nil, nil
+	h.mtx.Unlock()
+	for _, s := range sinks {
+		s.Stop()
+	}
+	if alerts != nil {
+		alerts.Stop()
+	}
+	if checks != nil {
+		checks.Stop()
+	}
+	nc.Close()
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mtx.RLock()
+	nc := h.nc
+	h.mtx.RUnlock()
+	h.serve(w, r, nc)
+}
+
+// serve serves the metrics of nc, filtered by the request parameters, in the
+// format given by the format parameter or the Accept header.
+func (h *handler) serve(w http.ResponseWriter, r *http.Request, nc *collector.NodeCollector) {
+	query := r.URL.Query()
+	filters := query["collect[]"]
 	log.Debugln("collect query:", filters)
+	excludes := query["exclude[]"]
+	log.Debugln("exclude query:", excludes)
 
-	nc, err := collector.NewNodeCollector(filters...)
+	format := exposition.Negotiate(r.Header)
+	if f := query.Get("format"); f != "" {
+		var err error
+		if format, err = exposition.ParseFormat(f); err != nil {
+			log.Warnln("Invalid format:", err)
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid format: %s", err)))
+			return
+		}
+		r = withAccept(r, format)
+	}
+
+	options, err := collectorOptions(query)
 	if err != nil {
-		log.Warnln("Couldn't create", err)
+		log.Warnln("Invalid parameters:", err)
 		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte(fmt.Sprintf("Couldn't create %s", err)))
+		w.Write([]byte(fmt.Sprintf("Invalid parameters: %s", err)))
 		return
 	}
+	key := scrapeKey(nc, filters, excludes, options)
 
-	registry := prometheus.NewRegistry()
-	err = registry.Register(nc)
+	nc, err = nc.Filter(filters...)
+	if err == nil {
+		nc, err = nc.Exclude(excludes...)
+	}
+	if err != nil {
+		log.Warnln("Couldn't filter collectors:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Couldn't filter collectors: %s", err)))
+		return
+	}
+	nc, err = nc.WithOptions(options)
+	if err != nil {
+		log.Warnln("Couldn't apply collector options:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("Couldn't apply collector options: %s", err)))
+		return
+	}
+
+	gatherers, err := h.gatherersFor(nc)
 	if err != nil {
 		log.Errorln("Couldn't register collector:", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(fmt.Sprintf("Couldn't register collector: %s", err)))
 		return
 	}
+	mfs, err := h.limiter.gather(key, gatherers)
+	if err == errTooManyScrapes {
+		log.Warnln("Rejecting scrape:", err)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	gathered := prometheus.GathererFunc(func() ([]*dto.MetricFamily, error) { return mfs, err })
+	if format == exposition.FormatOpenMetrics || format == exposition.FormatJSON {
+		serveFormat(w, r, gathered, format)
+		return
+	}
+	// Delegate http serving to Prometheus client library, which encodes the
+	// gathered metrics.
+	promhttp.HandlerFor(gathered,
+		promhttp.HandlerOpts{
+			ErrorLog:      log.NewErrorLogger(),
+			ErrorHandling: promhttp.ContinueOnError,
+		}).ServeHTTP(w, r)
+}
 
-	gatherers := prometheus.Gatherers{
+// withAccept returns r with the Accept header set to the text or protobuf
+// format, so the client library serves it. The other formats are served by
+// serveFormat and r is returned unchanged.
+func withAccept(r *http.Request, format exposition.Format) *http.Request {
+	var accept string
+	switch format {
+	case exposition.FormatText:
+		accept = string(expfmt.FmtText)
+	case exposition.FormatProtobuf:
+		accept = string(expfmt.FmtProtoDelim)
+	default:
+		return r
+	}
+	header := make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		header[k] = v
+	}
+	header.Set("Accept", accept)
+	r2 := *r
+	r2.Header = header
+	return &r2
+}
+
+// startTime is when the exporter started.
+var startTime = time.Now()
+
+// ownMetricPrefixes are the prefixes of the metrics of the exporter itself,
+// the Go runtime and the process.
+var ownMetricPrefixes = []string{"go_", "process_", "promhttp_", "node_exporter_"}
+
+// ownMetricsCreated implements exposition.CreatedFunc, taking the start of the
+// exporter as the creation time of its own metrics. The creation time of the
+// series of the collectors isn't known.
+func ownMetricsCreated(name string) (time.Time, bool) {
+	for _, prefix := range ownMetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return startTime, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// serveFormat serves the metrics of gatherers in the OpenMetrics or JSON
+// format. Like the client library, errors are logged and the metrics
+// gathered despite them are served.
+func serveFormat(w http.ResponseWriter, r *http.Request, gatherers prometheus.Gatherer, format exposition.Format) {
+	mfs, err := gatherers.Gather()
+	if err != nil {
+		log.Errorln("Error gathering metrics:", err)
+	}
+
+	var out io.Writer = w
+	if gzipAccepted(r.Header) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	if format == exposition.FormatOpenMetrics {
+		w.Header().Set("Content-Type", exposition.OpenMetricsContentType)
+		err = exposition.WriteOpenMetrics(out, mfs, ownMetricsCreated)
+	} else {
+		w.Header().Set("Content-Type", exposition.JSONContentType)
+		err = exposition.WriteJSON(out, mfs)
+	}
+	if err != nil {
+		log.Errorln("Error encoding metrics:", err)
+	}
+}
+
+func gzipAccepted(header http.Header) bool {
+	for _, part := range strings.Split(header.Get("Accept-Encoding"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "gzip" || strings.HasPrefix(part, "gzip;") {
+			return true
+		}
+	}
+	return false
+}
+
+// Gather implements prometheus.Gatherer, gathering all collectors along with
+// the default registry like an unfiltered scrape, which it is coalesced with.
+// It is used to push metrics with remote write and the sinks, and to fill the
+// history. The returned metric families must not be modified.
+func (h *handler) Gather() ([]*dto.MetricFamily, error) {
+	h.mtx.RLock()
+	nc := h.nc
+	h.mtx.RUnlock()
+	gatherers, err := h.gatherersFor(nc)
+	if err != nil {
+		return nil, err
+	}
+	return h.limiter.gather(scrapeKey(nc, nil, nil, nil), gatherers)
+}
+
+// gatherersFor returns the gatherer of a scrape of nc, adding the target
+// labels to every series.
+func (h *handler) gatherersFor(nc *collector.NodeCollector) (prometheus.Gatherer, error) {
+	h.mtx.RLock()
+	targetLabels := h.targetLabels
+	h.mtx.RUnlock()
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(nc); err != nil {
+		return nil, err
+	}
+	if len(targetLabels) > 0 {
+		if err := registry.Register(newTargetInfo(targetLabels)); err != nil {
+			return nil, err
+		}
+	}
+	return newLabelGatherer(prometheus.Gatherers{
 		prometheus.DefaultGatherer,
 		registry,
+	}, targetLabels), nil
+}
+
+// collectorOptions returns the per-request collector options of query, the
+// parameters named <collector>.<option>. Any other parameter but collect[],
+// exclude[] and format is rejected.
+func collectorOptions(query url.Values) (map[string]string, error) {
+	options := map[string]string{}
+	for name, values := range query {
+		switch {
+		case name == "collect[]" || name == "exclude[]" || name == "format":
+			continue
+		case !strings.Contains(name, "."):
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		case len(values) != 1:
+			return nil, fmt.Errorf("parameter %q given %d times", name, len(values))
+		}
+		options[name] = values[0]
+	}
+	return options, nil
+}
+
+// reload reloads the configuration file and the web configuration file.
+func reload(h *handler, ws *https.Server) (err error) {
+	defer func() {
+		if err != nil {
+			log.Errorln("Error reloading configuration:", err)
+			configSuccess.Set(0)
+			return
+		}
+		configSuccess.Set(1)
+		configSuccessTime.Set(float64(time.Now().Unix()))
+	}()
+
+	if err := h.reload(); err != nil {
+		return err
+	}
+	return ws.Reload()
+}
+
+// reloadHandler reloads the configuration on POST requests.
+func reloadHandler(h *handler, ws *https.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("Only POST requests allowed"))
+			return
+		}
+		if err := reload(h, ws); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
 	}
-	// Delegate http serving to Prometheus client library, which will call collector.Collect.
-	h := promhttp.InstrumentMetricHandler(
-		registry,
-		promhttp.HandlerFor(gatherers,
-			promhttp.HandlerOpts{
-				ErrorLog:      log.NewErrorLogger(),
-				ErrorHandling: promhttp.ContinueOnError,
-			}),
-	)
-	h.ServeHTTP(w, r)
 }
 
 func main() {
 	var (
 		listenAddress = kingpin.Flag("web.listen-address", "Address on which to expose metrics and web interface.").Default(":9100").String()
 		metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").String()
+		configFile    = kingpin.Flag("config.file", "Path to the configuration file, reloaded on SIGHUP or a POST to /-/reload.").Default("").String()
+		webConfig     = kingpin.Flag("web.config", "Path to the web configuration file enabling TLS and authentication, reloaded with the configuration file.").Default("").String()
+
+		remoteWriteURL            = kingpin.Flag("push.remote-write.url", "Prometheus remote write endpoint to push metrics to, pushing is disabled if empty.").Default("").String()
+		remoteWriteInterval       = kingpin.Flag("push.remote-write.interval", "Interval between two pushes.").Default("15s").Duration()
+		remoteWriteTimeout        = kingpin.Flag("push.remote-write.timeout", "Timeout of a remote write request.").Default("10s").Duration()
+		remoteWriteBufferDir      = kingpin.Flag("push.remote-write.buffer-dir", "Directory keeping the samples that couldn't be pushed yet.").Default("remote_write_buffer").String()
+		remoteWriteBufferMaxBytes = kingpin.Flag("push.remote-write.buffer-max-bytes", "Maximum size of the remote write buffer, the oldest samples are dropped beyond it.").Default("100MB").Bytes()
+
+		historyRetention = kingpin.Flag("history.retention", "How long to keep the metrics in memory for /api/v1/query_range, the history is disabled if 0.").Default("0s").Duration()
+		historyInterval  = kingpin.Flag("history.interval", "Interval between two snapshots of the metrics kept in the history.").Default("15s").Duration()
+		historyMaxBytes  = kingpin.Flag("history.max-bytes", "Maximum estimated memory used by the history, the oldest snapshots are dropped beyond it.").Default("64MB").Bytes()
+
+		coalesceWindow       = kingpin.Flag("web.coalesce-window", "How long the result of a collection is shared with later scrapes of the same collectors, on top of the scrapes arriving while it runs.").Default("0s").Duration()
+		maxConcurrentScrapes = kingpin.Flag("web.max-concurrent-scrapes", "Maximum number of concurrent collections, 0 for no limit.").Default("0").Int()
+		scrapeQueueTimeout   = kingpin.Flag("web.scrape-queue-timeout", "How long a scrape waits for a collection slot before it is rejected with 503.").Default("5s").Duration()
+
+		requiredCollectors = kingpin.Flag("web.required-collectors", "Comma separated collectors that must have succeeded once before /-/ready reports ready.").Default("").String()
+		shutdownTimeout    = kingpin.Flag("web.shutdown-timeout", "How long in-flight requests are waited for on SIGTERM before exiting.").Default("30s").Duration()
+		systemdSocket      = kingpin.Flag("web.systemd-socket", "Serve on the socket passed by systemd socket activation instead of --web.listen-address.").Bool()
 	)
 
 	log.AddFlags(kingpin.CommandLine)
@@ -82,28 +510,119 @@ func main() {
 	log.Infoln("Starting node_exporter", version.Info())
 	log.Infoln("Build context", version.BuildContext())
 
-	nc, err := collector.NewNodeCollector()
+	// The web configuration applies to all endpoints, including pprof, but the
+	// health endpoints are served without authentication for probes.
+	ws, err := https.NewServer(*webConfig, http.DefaultServeMux)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ws.Unauthenticated("/-/healthy", "/-/ready")
+
+	// The health endpoints are served while the configuration loads, the other
+	// endpoints are added once it loaded.
+	var required []string
+	if *requiredCollectors != "" {
+		required = strings.Split(*requiredCollectors, ",")
+	}
+	health := newHealth(required)
+	http.HandleFunc("/-/healthy", health.serveHealthy)
+	http.HandleFunc("/-/ready", health.serveReady)
 
-	// This instance is only used to check collector creation and logging.
+	fmt.Println("begin to Listen")
+	var l net.Listener
+	if *systemdSocket {
+		l, err = systemdListener()
+	} else {
+		l, err = net.Listen("tcp", *listenAddress)
+	}
 	if err != nil {
-		log.Fatalf("Couldn't create collector: %s", err)
+		log.Fatal(err)
 	}
-	log.Infof("Enabled collectors:")
-	collectors := []string{}
-	for n := range nc.Collectors {
-		collectors = append(collectors, n)
+	log.Infoln("Listening on", l.Addr())
+	srv := &http.Server{}
+	served := make(chan error, 1)
+	go func() {
+		served <- ws.Serve(srv, l)
+	}()
+
+	// The collectors are created once and shared by all scrapes.
+	h, err := newHandler(*configFile, *metricsPath, newScrapeLimiter(*coalesceWindow, *maxConcurrentScrapes, *scrapeQueueTimeout))
+	if err != nil {
+		log.Fatal(err)
 	}
-	sort.Strings(collectors)
-	for _, n := range collectors {
-		log.Infof(" - %s", n)
+	configSuccess.Set(1)
+	configSuccessTime.Set(float64(time.Now().Unix()))
+
+	done := make(chan struct{})
+	if *remoteWriteURL != "" {
+		if *remoteWriteInterval <= 0 {
+			log.Fatal("--push.remote-write.interval must be positive")
+		}
+		rw, err := push.NewRemoteWriter(push.RemoteWriteConfig{
+			URL:            *remoteWriteURL,
+			Interval:       *remoteWriteInterval,
+			Timeout:        *remoteWriteTimeout,
+			BufferDir:      *remoteWriteBufferDir,
+			BufferMaxBytes: int64(*remoteWriteBufferMaxBytes),
+		}, h)
+		if err != nil {
+			log.Fatal(err)
+		}
+		prometheus.MustRegister(rw)
+		log.Infoln("Pushing metrics to", *remoteWriteURL)
+		go rw.Run(done)
 	}
 
-	http.HandleFunc(*metricsPath, handler)
+	var store *history.Store
+	if *historyRetention > 0 {
+		if *historyInterval <= 0 {
+			log.Fatal("--history.interval must be positive")
+		}
+		store = history.NewStore(*historyRetention, *historyInterval, int64(*historyMaxBytes))
+		prometheus.MustRegister(store)
+		log.Infof("Keeping %s of history, snapshotting every %s", *historyRetention, *historyInterval)
+		go store.Run(h, *historyInterval, done)
+	}
 
-	fmt.Println("begin to Listen")
-	log.Infoln("Listening on", *listenAddress)
-	err = http.ListenAndServe(*listenAddress, nil)
-	if err != nil {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload(h, ws)
+		}
+	}()
+
+	// Instrument against the default registry, so the handler metrics are kept
+	// across scrapes. The profiles are told apart by the profile label.
+	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(
+		prometheus.WrapRegistererWith(prometheus.Labels{"profile": defaultProfile}, prometheus.DefaultRegisterer), h,
+	))
+	http.HandleFunc("/", h.serveProfile)
+	http.HandleFunc("/-/reload", reloadHandler(h, ws))
+	http.HandleFunc("/api/v1/collectors", collectorsHandler)
+	http.HandleFunc("/api/v1/query_range", queryRangeHandler(store, *historyRetention))
+	http.HandleFunc("/api/v1/alerts", h.serveAlerts)
+	health.loaded(h)
+	go notifySystemd(health, done)
+
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	select {
+	case err := <-served:
 		log.Fatal(err)
+	case sig := <-term:
+		log.Infof("Received %s, shutting down", sig)
+	}
+
+	// Stop accepting requests and let the scrapes in progress finish.
+	health.shutdown()
+	sdNotify("STOPPING=1")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Warnln("Requests still in progress after the shutdown timeout:", err)
 	}
+	close(done)
+	h.Close()
+	log.Infoln("Shut down")
 }
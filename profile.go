@@ -0,0 +1,128 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/node_exporter/collector"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// defaultProfile is the profile label of the handler metrics of
+// --web.telemetry-path.
+const defaultProfile = "default"
+
+// reservedPaths are path prefixes used by other endpoints.
+var reservedPaths = []string{"/-/", "/debug/", "/api/"}
+
+// profile is a named set of collectors served on its own path.
+type profile struct {
+	name string
+	nc   *collector.NodeCollector
+}
+
+type profileKey struct{}
+
+// newProfiles creates the profiles of cfg from the collectors of nc, keyed by
+// their path. Collectors with options set by a profile are created once per
+// profile.
+func newProfiles(cfg *utils.Config, nc *collector.NodeCollector, metricsPath string) (map[string]*profile, error) {
+	profiles := map[string]*profile{}
+	if cfg == nil {
+		return profiles, nil
+	}
+
+	names := map[string]bool{}
+	for _, pc := range cfg.Profiles {
+		switch {
+		case pc == nil:
+			return nil, fmt.Errorf("empty profile")
+		case pc.Name == "":
+			return nil, fmt.Errorf("profile for path %q has no name", pc.Path)
+		case pc.Name == defaultProfile:
+			return nil, fmt.Errorf("profile name %q is reserved", pc.Name)
+		case names[pc.Name]:
+			return nil, fmt.Errorf("duplicate profile %q", pc.Name)
+		case !strings.HasPrefix(pc.Path, "/") || pc.Path == "/":
+			return nil, fmt.Errorf("invalid path %q for profile %q", pc.Path, pc.Name)
+		case pc.Path == metricsPath || isReservedPath(pc.Path):
+			return nil, fmt.Errorf("path %q of profile %q is used by another endpoint", pc.Path, pc.Name)
+		case profiles[pc.Path] != nil:
+			return nil, fmt.Errorf("path %q of profile %q is already used by profile %q", pc.Path, pc.Name, profiles[pc.Path].name)
+		}
+
+		pnc, err := nc.Filter(pc.Collectors...)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %s", pc.Name, err)
+		}
+		pnc, err = pnc.WithConfigOptions(pc.Options)
+		if err != nil {
+			return nil, fmt.Errorf("profile %q: %s", pc.Name, err)
+		}
+		names[pc.Name] = true
+		profiles[pc.Path] = &profile{name: pc.Name, nc: pnc}
+	}
+	return profiles, nil
+}
+
+func isReservedPath(path string) bool {
+	for _, prefix := range reservedPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// serveProfile serves the profile configured for the request path, or the
+// landing page for "/".
+func (h *handler) serveProfile(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		h.serveLanding(w, r)
+		return
+	}
+	h.mtx.RLock()
+	p, ok := h.profiles[r.URL.Path]
+	h.mtx.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := context.WithValue(r.Context(), profileKey{}, p.nc)
+	h.instrumentedHandler(p.name).ServeHTTP(w, r.WithContext(ctx))
+}
+
+// instrumentedHandler returns the handler of the named profile, instrumented
+// with the profile label. It is created once per name and kept across
+// reloads.
+func (h *handler) instrumentedHandler(name string) http.Handler {
+	h.instrumentedMtx.Lock()
+	defer h.instrumentedMtx.Unlock()
+
+	if ih, ok := h.instrumented[name]; ok {
+		return ih
+	}
+	reg := prometheus.WrapRegistererWith(prometheus.Labels{"profile": name}, prometheus.DefaultRegisterer)
+	ih := promhttp.InstrumentMetricHandler(reg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.serve(w, r, r.Context().Value(profileKey{}).(*collector.NodeCollector))
+	}))
+	h.instrumented[name] = ih
+	return ih
+}
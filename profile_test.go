@@ -0,0 +1,71 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/node_exporter/collector"
+	"github.com/prometheus/node_exporter/utils"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestNewProfiles(t *testing.T) {
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		t.Fatal(err)
+	}
+	nc, err := collector.NewNodeCollectorFromConfig(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+
+	profiles, err := newProfiles(&utils.Config{Profiles: []*utils.ProfileConfig{
+		{Name: "fast", Path: "/metrics/fast", Collectors: []string{"cpu", "meminfo"}},
+		{Name: "slow", Path: "/metrics/slow", Collectors: []string{"textfile"}, Options: map[string]string{"textfile.directory": "/tmp"}},
+	}}, nc, "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(profiles["/metrics/fast"].nc.Collectors); got != 2 {
+		t.Errorf("want 2 collectors in the fast profile, got %d", got)
+	}
+	if got := profiles["/metrics/slow"].nc.Collectors["textfile"]; got == nc.Collectors["textfile"] {
+		t.Error("want a new textfile collector for the profile options")
+	}
+
+	for _, pc := range []*utils.ProfileConfig{
+		nil,
+		{Path: "/metrics/fast"},
+		{Name: defaultProfile, Path: "/metrics/fast"},
+		{Name: "fast", Path: "metrics"},
+		{Name: "fast", Path: "/"},
+		{Name: "fast", Path: "/metrics"},
+		{Name: "fast", Path: "/-/fast"},
+		{Name: "fast", Path: "/metrics/fast", Collectors: []string{"unknown"}},
+		{Name: "fast", Path: "/metrics/fast", Options: map[string]string{"cpu.unknown": ""}},
+	} {
+		if _, err := newProfiles(&utils.Config{Profiles: []*utils.ProfileConfig{pc}}, nc, "/metrics"); err == nil {
+			t.Errorf("want an error for profile %+v", pc)
+		}
+	}
+	for _, pcs := range [][]*utils.ProfileConfig{
+		{{Name: "a", Path: "/a"}, {Name: "a", Path: "/b"}},
+		{{Name: "a", Path: "/a"}, {Name: "b", Path: "/a"}},
+	} {
+		if _, err := newProfiles(&utils.Config{Profiles: pcs}, nc, "/metrics"); err == nil {
+			t.Errorf("want an error for profiles %+v and %+v", pcs[0], pcs[1])
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+)
+
+// diskBuffer keeps encoded requests that couldn't be sent in a directory, one
+// file per request, so they survive restarts. Once the buffer grows over
+// maxBytes, the oldest requests are dropped.
+type diskBuffer struct {
+	dir      string
+	maxBytes int64
+
+	// segments are ordered from oldest to newest.
+	segments []segment
+	size     int64
+	nextSeq  uint64
+}
+
+// segment describes a buffered request. The description is kept in the file
+// name, so the buffer can be reopened without reading the requests.
+type segment struct {
+	seq       uint64
+	timestamp model.Time // of the oldest sample
+	samples   int
+	size      int64
+}
+
+func (s segment) filename() string {
+	return fmt.Sprintf("%020d-%d-%d", s.seq, int64(s.timestamp), s.samples)
+}
+
+func parseSegment(name string) (segment, bool) {
+	var (
+		s  segment
+		ts int64
+	)
+	if _, err := fmt.Sscanf(name, "%020d-%d-%d", &s.seq, &ts, &s.samples); err != nil {
+		return s, false
+	}
+	s.timestamp = model.Time(ts)
+	return s, s.filename() == name
+}
+
+// openDiskBuffer opens the buffer in dir, creating dir if needed, and loads
+// the requests left by a previous run.
+func openDiskBuffer(dir string, maxBytes int64) (*diskBuffer, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &diskBuffer{dir: dir, maxBytes: maxBytes}
+	for _, f := range files {
+		s, ok := parseSegment(f.Name())
+		if !ok || f.IsDir() {
+			log.Warnf("Ignoring unexpected file %s in remote write buffer", filepath.Join(dir, f.Name()))
+			continue
+		}
+		s.size = f.Size()
+		b.segments = append(b.segments, s)
+		b.size += s.size
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i].seq < b.segments[j].seq })
+	if n := len(b.segments); n > 0 {
+		b.nextSeq = b.segments[n-1].seq + 1
+	}
+	return b, nil
+}
+
+// append adds a request with the given number of samples, the oldest taken at
+// timestamp, and returns the number of samples dropped to stay within the
+// size limit. The newest request is never dropped.
+func (b *diskBuffer) append(data []byte, timestamp model.Time, samples int) (int, error) {
+	s := segment{seq: b.nextSeq, timestamp: timestamp, samples: samples, size: int64(len(data))}
+	tmp := filepath.Join(b.dir, s.filename()+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := os.Rename(tmp, filepath.Join(b.dir, s.filename())); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	b.nextSeq++
+	b.segments = append(b.segments, s)
+	b.size += s.size
+
+	dropped := 0
+	for b.size > b.maxBytes && len(b.segments) > 1 {
+		dropped += b.segments[0].samples
+		if err := b.removeOldest(); err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+// oldest returns the oldest request and its description.
+func (b *diskBuffer) oldest() (segment, []byte, error) {
+	s := b.segments[0]
+	data, err := ioutil.ReadFile(filepath.Join(b.dir, s.filename()))
+	return s, data, err
+}
+
+// removeOldest removes the oldest request.
+func (b *diskBuffer) removeOldest() error {
+	s := b.segments[0]
+	if err := os.Remove(filepath.Join(b.dir, s.filename())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	b.segments = b.segments[1:]
+	b.size -= s.size
+	return nil
+}
+
+func (b *diskBuffer) empty() bool {
+	return len(b.segments) == 0
+}
+
+// pending returns the number of buffered samples.
+func (b *diskBuffer) pending() int {
+	n := 0
+	for _, s := range b.segments {
+		n += s.samples
+	}
+	return n
+}
@@ -0,0 +1,121 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// Graphite sends samples in the Graphite plaintext format over TCP.
+type Graphite struct {
+	address    string
+	timeout    time.Duration
+	prefix     string
+	pathLabels []string
+}
+
+// NewGraphite returns a Graphite sink configured by cfg.
+func NewGraphite(cfg *utils.GraphiteConfig) (*Graphite, error) {
+	if _, _, err := net.SplitHostPort(cfg.Address); err != nil {
+		return nil, fmt.Errorf("invalid graphite address %q: %s", cfg.Address, err)
+	}
+	return &Graphite{
+		address:    cfg.Address,
+		timeout:    timeoutOrDefault(cfg.Timeout),
+		prefix:     strings.TrimSuffix(cfg.Prefix, "."),
+		pathLabels: cfg.PathLabels,
+	}, nil
+}
+
+// Send implements Sink, sending all samples over a new connection.
+func (g *Graphite) Send(samples model.Vector) error {
+	conn, err := net.DialTimeout("tcp", g.address, g.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(g.timeout)); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(conn)
+	if err := g.write(w, samples); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// write writes samples as "<path> <value> <timestamp>" lines.
+func (g *Graphite) write(w io.Writer, samples model.Vector) error {
+	for _, s := range samples {
+		_, err := fmt.Fprintf(w, "%s %s %d\n",
+			g.path(s.Metric), strconv.FormatFloat(float64(s.Value), 'g', -1, 64), s.Timestamp.Unix())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// path returns the Graphite path of m: the prefix, the metric name and either
+// the values of the path labels in order, or all labels as name and value.
+func (g *Graphite) path(m model.Metric) string {
+	parts := []string{}
+	if g.prefix != "" {
+		parts = append(parts, g.prefix)
+	}
+	parts = append(parts, graphiteEscape(string(m[model.MetricNameLabel])))
+
+	if g.pathLabels != nil {
+		for _, name := range g.pathLabels {
+			if v := m[model.LabelName(name)]; v != "" {
+				parts = append(parts, graphiteEscape(string(v)))
+			}
+		}
+		return strings.Join(parts, ".")
+	}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		if name != model.MetricNameLabel && m[name] != "" {
+			names = append(names, string(name))
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parts = append(parts, graphiteEscape(name), graphiteEscape(string(m[model.LabelName(name)])))
+	}
+	return strings.Join(parts, ".")
+}
+
+// graphiteEscape replaces the characters that have a meaning in Graphite
+// paths, like dots and whitespace, with underscores.
+func graphiteEscape(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-', r == ':':
+			return r
+		}
+		return '_'
+	}, s)
+}
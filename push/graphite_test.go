@@ -0,0 +1,107 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+var testSamples = model.Vector{
+	{
+		Metric:    model.Metric{"__name__": "node_cpu_seconds_total", "cpu": "0", "mode": "idle"},
+		Value:     1.5,
+		Timestamp: 1500000000000,
+	},
+	{
+		Metric:    model.Metric{"__name__": "node_filesystem_avail_bytes", "mountpoint": "/var/lib", "device": "", "fstype": "ext4"},
+		Value:     1024,
+		Timestamp: 1500000000000,
+	},
+}
+
+func TestGraphitePath(t *testing.T) {
+	for _, test := range []struct {
+		cfg  utils.GraphiteConfig
+		want []string
+	}{
+		{
+			cfg: utils.GraphiteConfig{},
+			want: []string{
+				"node_cpu_seconds_total.cpu.0.mode.idle",
+				"node_filesystem_avail_bytes.fstype.ext4.mountpoint._var_lib",
+			},
+		},
+		{
+			cfg: utils.GraphiteConfig{Prefix: "servers.host1.", PathLabels: []string{"mountpoint", "cpu", "mode"}},
+			want: []string{
+				"servers.host1.node_cpu_seconds_total.0.idle",
+				"servers.host1.node_filesystem_avail_bytes._var_lib",
+			},
+		},
+	} {
+		test.cfg.Address = "localhost:2003"
+		g, err := NewGraphite(&test.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, s := range testSamples {
+			if got := g.path(s.Metric); got != test.want[i] {
+				t.Errorf("want path %q, got %q", test.want[i], got)
+			}
+		}
+	}
+}
+
+func TestGraphiteSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := make(chan string)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		data, _ := ioutil.ReadAll(conn)
+		received <- string(data)
+	}()
+
+	g, err := NewGraphite(&utils.GraphiteConfig{Address: l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Send(testSamples); err != nil {
+		t.Fatal(err)
+	}
+	want := "node_cpu_seconds_total.cpu.0.mode.idle 1.5 1500000000\n" +
+		"node_filesystem_avail_bytes.fstype.ext4.mountpoint._var_lib 1024 1500000000\n"
+	if got := <-received; got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestNewGraphiteInvalidAddress(t *testing.T) {
+	if _, err := NewGraphite(&utils.GraphiteConfig{Address: "localhost"}); err == nil {
+		t.Error("want an error for an address without port")
+	}
+}
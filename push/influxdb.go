@@ -0,0 +1,173 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// maxUDPPayload is the maximum size of a UDP packet sent to InfluxDB, small
+// enough to fit into the MTU of most networks.
+const maxUDPPayload = 1400
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxDB sends samples in the InfluxDB line protocol over HTTP or UDP. The
+// metric name is the measurement, the labels are the tags and the sample
+// value is the field "value".
+type InfluxDB struct {
+	url     *url.URL
+	timeout time.Duration
+	tags    map[string]string
+	client  *http.Client
+}
+
+// NewInfluxDB returns an InfluxDB sink configured by cfg.
+func NewInfluxDB(cfg *utils.InfluxDBConfig) (*InfluxDB, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid influxdb url %q: %s", cfg.URL, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "udp":
+	default:
+		return nil, fmt.Errorf("invalid influxdb url %q: scheme must be http, https or udp", cfg.URL)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid influxdb url %q: missing host", cfg.URL)
+	}
+	timeout := timeoutOrDefault(cfg.Timeout)
+	return &InfluxDB{
+		url:     u,
+		timeout: timeout,
+		tags:    cfg.Tags,
+		client:  &http.Client{Timeout: timeout},
+	}, nil
+}
+
+// Send implements Sink.
+func (i *InfluxDB) Send(samples model.Vector) error {
+	if i.url.Scheme == "udp" {
+		return i.sendUDP(samples)
+	}
+
+	var buf bytes.Buffer
+	for _, s := range samples {
+		buf.WriteString(i.line(s))
+	}
+	req, err := http.NewRequest("POST", i.url.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req.Header.Set("User-Agent", "node_exporter/"+version.Version)
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// sendUDP sends the lines of samples in as few packets as possible. A line
+// is never split across packets.
+func (i *InfluxDB) sendUDP(samples model.Vector) error {
+	conn, err := net.DialTimeout("udp", i.url.Host, i.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(i.timeout)); err != nil {
+		return err
+	}
+
+	var packet []byte
+	for _, s := range samples {
+		line := i.line(s)
+		if len(packet) > 0 && len(packet)+len(line) > maxUDPPayload {
+			if _, err := conn.Write(packet); err != nil {
+				return err
+			}
+			packet = packet[:0]
+		}
+		packet = append(packet, line...)
+	}
+	if len(packet) > 0 {
+		if _, err := conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// line returns the line of s, with the labels renamed to tags and the labels
+// mapped to an empty name dropped. Tags with empty values are left out, as
+// InfluxDB rejects them.
+func (i *InfluxDB) line(s *model.Sample) string {
+	tags := make(map[string]string, len(s.Metric))
+	for name, value := range s.Metric {
+		if name == model.MetricNameLabel || value == "" {
+			continue
+		}
+		tag := string(name)
+		if mapped, ok := i.tags[tag]; ok {
+			tag = mapped
+		}
+		if tag != "" {
+			tags[tag] = string(value)
+		}
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(influxMeasurementEscaper.Replace(string(s.Metric[model.MetricNameLabel])))
+	for _, name := range names {
+		b.WriteByte(',')
+		b.WriteString(influxTagEscaper.Replace(name))
+		b.WriteByte('=')
+		b.WriteString(influxTagEscaper.Replace(tags[name]))
+	}
+	b.WriteString(" value=")
+	b.WriteString(strconv.FormatFloat(float64(s.Value), 'g', -1, 64))
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatInt(int64(s.Timestamp)*int64(time.Millisecond), 10))
+	b.WriteByte('\n')
+	return b.String()
+}
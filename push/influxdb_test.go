@@ -0,0 +1,140 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+func TestInfluxDBLine(t *testing.T) {
+	i, err := NewInfluxDB(&utils.InfluxDBConfig{
+		URL:  "http://localhost:8086/write?db=node",
+		Tags: map[string]string{"mountpoint": "path", "fstype": ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n, want := range []string{
+		"node_cpu_seconds_total,cpu=0,mode=idle value=1.5 1500000000000000000\n",
+		"node_filesystem_avail_bytes,path=/var/lib value=1024 1500000000000000000\n",
+	} {
+		if got := i.line(testSamples[n]); got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+
+	escaped := &model.Sample{
+		Metric: model.Metric{"__name__": "node_test", "label": "a b,c=d"},
+		Value:  1,
+	}
+	if got, want := i.line(escaped), `node_test,label=a\ b\,c\=d value=1 0`+"\n"; got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestInfluxDBSendHTTP(t *testing.T) {
+	received := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("db") != "node" {
+			http.Error(w, "database not found", http.StatusNotFound)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	i, err := NewInfluxDB(&utils.InfluxDBConfig{URL: server.URL + "/write?db=node"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Send(testSamples); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; strings.Count(got, "\n") != len(testSamples) {
+		t.Errorf("want %d lines, got %q", len(testSamples), got)
+	}
+
+	i, err = NewInfluxDB(&utils.InfluxDBConfig{URL: server.URL + "/write?db=missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Send(testSamples); err == nil {
+		t.Error("want an error for a missing database")
+	}
+}
+
+func TestInfluxDBSendUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Enough samples to need more than one packet.
+	samples := model.Vector{}
+	for n := 0; n < 100; n++ {
+		samples = append(samples, &model.Sample{
+			Metric: model.Metric{"__name__": "node_test", "i": model.LabelValue(fmt.Sprint(n))},
+			Value:  1,
+		})
+	}
+	i, err := NewInfluxDB(&utils.InfluxDBConfig{URL: "udp://" + conn.LocalAddr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := i.Send(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, packets := 0, 0
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for lines < len(samples) {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("got %d lines in %d packets: %s", lines, packets, err)
+		}
+		if n > maxUDPPayload {
+			t.Errorf("want packets of at most %d bytes, got %d", maxUDPPayload, n)
+		}
+		if buf[n-1] != '\n' {
+			t.Error("want packets to end with a complete line")
+		}
+		packets++
+		lines += strings.Count(string(buf[:n]), "\n")
+	}
+	if packets < 2 {
+		t.Errorf("want the lines split across packets, got %d packets", packets)
+	}
+}
+
+func TestNewInfluxDBInvalidURL(t *testing.T) {
+	for _, u := range []string{"tcp://localhost:8089", "localhost:8086", "http:///write"} {
+		if _, err := NewInfluxDB(&utils.InfluxDBConfig{URL: u}); err == nil {
+			t.Errorf("want an error for url %q", u)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package push sends the metrics of the node_exporter to remote systems for
+// nodes that can't be scraped.
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/version"
+)
+
+const namespace = "node_exporter"
+
+var (
+	pendingSamplesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "remote_write", "pending_samples"),
+		"Number of samples in the remote write buffer.",
+		nil, nil,
+	)
+	replayLagDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "remote_write", "replay_lag_seconds"),
+		"Age of the oldest sample in the remote write buffer, 0 if it is empty.",
+		nil, nil,
+	)
+)
+
+// RemoteWriteConfig configures a RemoteWriter.
+type RemoteWriteConfig struct {
+	URL      string
+	Interval time.Duration
+	Timeout  time.Duration
+	// BufferDir holds the requests that couldn't be sent yet.
+	BufferDir      string
+	BufferMaxBytes int64
+}
+
+// RemoteWriter gathers metrics at a fixed interval and sends them with the
+// Prometheus remote write protocol. Requests that can't be sent are buffered
+// on disk and sent in order once the endpoint is reachable again.
+type RemoteWriter struct {
+	cfg      RemoteWriteConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+
+	mtx    sync.Mutex
+	buffer *diskBuffer
+
+	sentSamples    prometheus.Counter
+	droppedSamples prometheus.Counter
+	failedSends    prometheus.Counter
+}
+
+// NewRemoteWriter returns a RemoteWriter sending the metrics of g, reopening
+// the buffer left by a previous run.
+func NewRemoteWriter(cfg RemoteWriteConfig, g prometheus.Gatherer) (*RemoteWriter, error) {
+	buffer, err := openDiskBuffer(cfg.BufferDir, cfg.BufferMaxBytes)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't open remote write buffer: %s", err)
+	}
+	return &RemoteWriter{
+		cfg:      cfg,
+		gatherer: g,
+		client:   &http.Client{Timeout: cfg.Timeout},
+		buffer:   buffer,
+		sentSamples: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "remote_write",
+			Name:      "sent_samples_total",
+			Help:      "Total number of samples sent to the remote write endpoint.",
+		}),
+		droppedSamples: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "remote_write",
+			Name:      "dropped_samples_total",
+			Help:      "Total number of samples dropped because the buffer was full or the endpoint rejected them.",
+		}),
+		failedSends: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "remote_write",
+			Name:      "failed_sends_total",
+			Help:      "Total number of remote write requests that failed.",
+		}),
+	}, nil
+}
+
+// Run pushes the metrics every interval until done is closed.
+func (w *RemoteWriter) Run(done <-chan struct{}) {
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.push(); err != nil {
+			log.Errorln("Error sending metrics to remote write endpoint:", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// push gathers the metrics and sends them. While older requests are buffered,
+// the new one is buffered behind them, so samples are always sent in order.
+func (w *RemoteWriter) push() error {
+	now := model.Now()
+	samples := gatherSamples(w.gatherer, now)
+	if len(samples) == 0 {
+		return nil
+	}
+	data, err := encodeWriteRequest(samples)
+	if err != nil {
+		return err
+	}
+
+	// Only Run changes the buffer, the lock keeps Collect consistent while
+	// requests are sent without holding it.
+	w.mtx.Lock()
+	empty := w.buffer.empty()
+	w.mtx.Unlock()
+	var sendErr error
+	if empty {
+		sendErr = w.send(data)
+		switch sendErr.(type) {
+		case nil:
+			w.sentSamples.Add(float64(len(samples)))
+			return nil
+		case unrecoverableError:
+			w.droppedSamples.Add(float64(len(samples)))
+			return sendErr
+		}
+	}
+
+	w.mtx.Lock()
+	dropped, err := w.buffer.append(data, now, len(samples))
+	w.mtx.Unlock()
+	w.droppedSamples.Add(float64(dropped))
+	if err != nil {
+		w.droppedSamples.Add(float64(len(samples)))
+		return fmt.Errorf("couldn't buffer samples: %s", err)
+	}
+	if sendErr != nil {
+		// The request just failed, it is replayed on the next push.
+		return fmt.Errorf("%s, buffering the samples", sendErr)
+	}
+	return w.replay()
+}
+
+// replay sends the buffered requests from oldest to newest, until one can't
+// be sent.
+func (w *RemoteWriter) replay() error {
+	for {
+		w.mtx.Lock()
+		if w.buffer.empty() {
+			w.mtx.Unlock()
+			return nil
+		}
+		s, data, err := w.buffer.oldest()
+		w.mtx.Unlock()
+
+		if err != nil {
+			err = unrecoverableError{fmt.Errorf("couldn't read buffered request: %s", err)}
+		} else {
+			err = w.send(data)
+		}
+		switch err.(type) {
+		case nil:
+			w.sentSamples.Add(float64(s.samples))
+		case unrecoverableError:
+			log.Errorln("Dropping buffered samples:", err)
+			w.droppedSamples.Add(float64(s.samples))
+		default:
+			return err
+		}
+
+		w.mtx.Lock()
+		err = w.buffer.removeOldest()
+		w.mtx.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// gatherSamples returns the samples of g, timestamped with now. Errors are
+// logged and the samples gathered despite them are returned.
+func gatherSamples(g prometheus.Gatherer, now model.Time) model.Vector {
+	mfs, err := g.Gather()
+	if err != nil {
+		log.Warnln("Error gathering metrics, pushing the rest:", err)
+	}
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: now}, mfs...)
+	if err != nil {
+		log.Warnln("Error extracting samples, pushing the rest:", err)
+	}
+	return samples
+}
+
+// unrecoverableError is returned for requests that will never be accepted.
+type unrecoverableError struct {
+	error
+}
+
+func (w *RemoteWriter) send(data []byte) error {
+	req, err := http.NewRequest("POST", w.cfg.URL, bytes.NewReader(data))
+	if err != nil {
+		return unrecoverableError{err}
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("User-Agent", "node_exporter/"+version.Version)
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		w.failedSends.Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	w.failedSends.Inc()
+	err = fmt.Errorf("server returned HTTP status %s: %s", resp.Status, bytes.TrimSpace(body))
+	// Client errors other than rate limiting won't go away by retrying.
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return unrecoverableError{err}
+	}
+	return err
+}
+
+// encodeWriteRequest returns the snappy compressed write request of samples.
+func encodeWriteRequest(samples model.Vector) ([]byte, error) {
+	req := &WriteRequest{Timeseries: make([]*TimeSeries, 0, len(samples))}
+	for _, s := range samples {
+		names := make([]string, 0, len(s.Metric))
+		for name := range s.Metric {
+			names = append(names, string(name))
+		}
+		sort.Strings(names)
+		labels := make([]*Label, 0, len(names))
+		for _, name := range names {
+			labels = append(labels, &Label{Name: name, Value: string(s.Metric[model.LabelName(name)])})
+		}
+		req.Timeseries = append(req.Timeseries, &TimeSeries{
+			Labels:  labels,
+			Samples: []*Sample{{Value: float64(s.Value), Timestamp: int64(s.Timestamp)}},
+		})
+	}
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// Describe implements prometheus.Collector.
+func (w *RemoteWriter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingSamplesDesc
+	ch <- replayLagDesc
+	w.sentSamples.Describe(ch)
+	w.droppedSamples.Describe(ch)
+	w.failedSends.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (w *RemoteWriter) Collect(ch chan<- prometheus.Metric) {
+	w.mtx.Lock()
+	pending := w.buffer.pending()
+	var lag float64
+	if !w.buffer.empty() {
+		lag = time.Since(w.buffer.segments[0].timestamp.Time()).Seconds()
+	}
+	w.mtx.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(pendingSamplesDesc, prometheus.GaugeValue, float64(pending))
+	ch <- prometheus.MustNewConstMetric(replayLagDesc, prometheus.GaugeValue, lag)
+	w.sentSamples.Collect(ch)
+	w.droppedSamples.Collect(ch)
+	w.failedSends.Collect(ch)
+}
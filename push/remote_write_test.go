@@ -0,0 +1,185 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// receiver is a remote write endpoint answering with status.
+type receiver struct {
+	mtx      sync.Mutex
+	status   int
+	attempts int
+	requests []*WriteRequest
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	rc.attempts++
+	if rc.status != http.StatusOK {
+		w.WriteHeader(rc.status)
+		return
+	}
+	compressed, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		panic(err)
+	}
+	req := &WriteRequest{}
+	if err := proto.Unmarshal(data, req); err != nil {
+		panic(err)
+	}
+	rc.requests = append(rc.requests, req)
+}
+
+func (rc *receiver) setStatus(status int) {
+	rc.mtx.Lock()
+	defer rc.mtx.Unlock()
+	rc.status = status
+}
+
+func gaugeValue(t *testing.T, c prometheus.Collector, desc *prometheus.Desc) float64 {
+	ch := make(chan prometheus.Metric, 10)
+	c.Collect(ch)
+	close(ch)
+	for m := range ch {
+		if m.Desc() == desc {
+			pb := &dto.Metric{}
+			if err := m.Write(pb); err != nil {
+				t.Fatal(err)
+			}
+			return pb.GetGauge().GetValue()
+		}
+	}
+	t.Fatalf("metric %s not collected", desc)
+	return 0
+}
+
+func TestRemoteWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote_write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rc := &receiver{status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_value", Help: "Test metric."})
+	reg.MustRegister(gauge)
+	cfg := RemoteWriteConfig{URL: server.URL, Timeout: time.Second, BufferDir: dir, BufferMaxBytes: 1 << 20}
+
+	w, err := NewRemoteWriter(cfg, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		gauge.Set(float64(i))
+		if err := w.push(); err == nil {
+			t.Fatal("want an error while the endpoint is unavailable")
+		}
+		time.Sleep(2 * time.Millisecond)
+	}
+	if got := gaugeValue(t, w, pendingSamplesDesc); got != 2 {
+		t.Errorf("want 2 pending samples, got %v", got)
+	}
+	// The first push doesn't replay the request that just failed, the second
+	// one only replays the buffer.
+	rc.mtx.Lock()
+	attempts := rc.attempts
+	rc.mtx.Unlock()
+	if attempts != 2 {
+		t.Errorf("want 2 attempts while the endpoint is unavailable, got %d", attempts)
+	}
+
+	// The buffer survives restarts.
+	w, err = NewRemoteWriter(cfg, reg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gaugeValue(t, w, replayLagDesc); got <= 0 {
+		t.Errorf("want a positive replay lag, got %v", got)
+	}
+
+	rc.setStatus(http.StatusOK)
+	gauge.Set(2)
+	if err := w.push(); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.requests) != 3 {
+		t.Fatalf("want 3 requests, got %d", len(rc.requests))
+	}
+	var last int64
+	for i, req := range rc.requests {
+		s := req.Timeseries[0]
+		if s.Labels[0].Name != "__name__" || s.Labels[0].Value != "test_value" {
+			t.Errorf("want the test_value series, got %v", s.Labels)
+		}
+		if got := s.Samples[0].Value; got != float64(i) {
+			t.Errorf("want requests in order, request %d has value %v", i, got)
+		}
+		if ts := s.Samples[0].Timestamp; ts <= last {
+			t.Errorf("want increasing timestamps, got %d after %d", ts, last)
+		}
+		last = s.Samples[0].Timestamp
+	}
+	if got := gaugeValue(t, w, pendingSamplesDesc); got != 0 {
+		t.Errorf("want no pending samples after replay, got %v", got)
+	}
+}
+
+func TestDiskBufferLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "remote_write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := openDiskBuffer(dir, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := 0
+	for i := 0; i < 3; i++ {
+		n, err := b.append([]byte("123456"), 0, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dropped += n
+	}
+	if dropped != 10 || b.pending() != 5 {
+		t.Errorf("want 10 samples dropped and 5 pending, got %d and %d", dropped, b.pending())
+	}
+	if s, _, err := b.oldest(); err != nil || s.seq != 2 {
+		t.Errorf("want the newest request kept, got %+v, %v", s, err)
+	}
+}
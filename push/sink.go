@@ -0,0 +1,165 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package push
+
+import (
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+const (
+	defaultSinkInterval = time.Minute
+	defaultSinkTimeout  = 10 * time.Second
+)
+
+var (
+	sinkSentSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sink",
+		Name:      "sent_samples_total",
+		Help:      "Total number of samples sent to the sink.",
+	}, []string{"sink"})
+	sinkFailedSends = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "sink",
+		Name:      "failed_sends_total",
+		Help:      "Total number of pushes to the sink that failed.",
+	}, []string{"sink"})
+
+	// SinkMetrics are the metrics of all sinks, to be registered once.
+	SinkMetrics = []prometheus.Collector{sinkSentSamples, sinkFailedSends}
+)
+
+// Sink sends samples to a remote system. Send is never given NaN or infinite
+// values, as the formats of the sinks can't represent them.
+type Sink interface {
+	Send(samples model.Vector) error
+}
+
+// Pusher gathers metrics at a fixed interval and sends them to a Sink.
+// Samples that can't be sent are dropped.
+type Pusher struct {
+	name     string
+	sink     Sink
+	gatherer prometheus.Gatherer
+	interval time.Duration
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewPusher returns a Pusher sending the metrics of g to s, it is reported as
+// name in the sink metrics.
+func NewPusher(name string, s Sink, g prometheus.Gatherer, interval time.Duration) *Pusher {
+	return &Pusher{
+		name:     name,
+		sink:     s,
+		gatherer: g,
+		interval: interval,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// NewSinks returns a Pusher for every sink configured in cfg, they have to be
+// started. A nil cfg configures no sinks.
+func NewSinks(cfg *utils.SinksConfig, g prometheus.Gatherer) ([]*Pusher, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	var pushers []*Pusher
+	if c := cfg.Graphite; c != nil {
+		s, err := NewGraphite(c)
+		if err != nil {
+			return nil, err
+		}
+		pushers = append(pushers, NewPusher("graphite", s, g, intervalOrDefault(c.Interval)))
+	}
+	if c := cfg.InfluxDB; c != nil {
+		s, err := NewInfluxDB(c)
+		if err != nil {
+			return nil, err
+		}
+		pushers = append(pushers, NewPusher("influxdb", s, g, intervalOrDefault(c.Interval)))
+	}
+	return pushers, nil
+}
+
+func intervalOrDefault(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return defaultSinkInterval
+	}
+	return interval
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return defaultSinkTimeout
+	}
+	return timeout
+}
+
+// Start starts pushing in the background.
+func (p *Pusher) Start() {
+	log.Infof("Pushing metrics to the %s sink every %s", p.name, p.interval)
+	go p.run()
+}
+
+// Stop stops pushing and waits for a push in progress to finish.
+func (p *Pusher) Stop() {
+	close(p.done)
+	<-p.stopped
+}
+
+func (p *Pusher) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		if err := p.push(); err != nil {
+			log.Errorf("Error sending metrics to the %s sink: %s", p.name, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *Pusher) push() error {
+	samples := gatherSamples(p.gatherer, model.Now())
+	finite := samples[:0]
+	for _, s := range samples {
+		v := float64(s.Value)
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		finite = append(finite, s)
+	}
+	if len(finite) == 0 {
+		return nil
+	}
+	if err := p.sink.Send(finite); err != nil {
+		sinkFailedSends.WithLabelValues(p.name).Inc()
+		return err
+	}
+	sinkSentSamples.WithLabelValues(p.name).Add(float64(len(finite)))
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicecheck
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-xmlrpc"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// maxCommandOutput is the length of the output of a failed command kept in
+// its error.
+const maxCommandOutput = 512
+
+// action remediates a failed check.
+type action struct {
+	// kind is the action label of the metrics.
+	kind    string
+	target  string
+	timeout time.Duration
+	do      func(ctx context.Context) error
+}
+
+func newAction(cfg *utils.ServiceCheckActionConfig, supervisordURL string) (*action, error) {
+	a := &action{timeout: cfg.Timeout}
+	if a.timeout <= 0 {
+		a.timeout = defaultActionTimeout
+	}
+	set := 0
+	if cfg.SystemdRestart != "" {
+		set++
+		a.kind, a.target = "systemd_restart", cfg.SystemdRestart
+		a.do = func(ctx context.Context) error { return systemdRestart(ctx, cfg.SystemdRestart) }
+	}
+	if cfg.SupervisordRestart != "" {
+		set++
+		a.kind, a.target = "supervisord_restart", cfg.SupervisordRestart
+		a.do = func(ctx context.Context) error {
+			return supervisordRestart(ctx, supervisordURL, cfg.SupervisordRestart)
+		}
+	}
+	if len(cfg.Command) > 0 {
+		set++
+		a.kind, a.target = "command", strings.Join(cfg.Command, " ")
+		a.do = func(ctx context.Context) error { return command(ctx, cfg.Command) }
+	}
+	if set != 1 {
+		return nil, errors.New("exactly one of systemd_restart, supervisord_restart and command must be set per action")
+	}
+	return a, nil
+}
+
+func (a *action) String() string {
+	return fmt.Sprintf("%s %s", a.kind, a.target)
+}
+
+// run runs the action, interrupting it after its timeout or once ctx is
+// done.
+func (a *action) run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	return a.do(ctx)
+}
+
+// supervisordRestart restarts the program name of the supervisord at url,
+// giving up once ctx is done. The XML-RPC client can't be interrupted, so the
+// calls in progress then finish in the background.
+func supervisordRestart(ctx context.Context, url, name string) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- supervisordStopStart(url, name)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("couldn't restart %s: %s", name, ctx.Err())
+	}
+}
+
+// supervisordStopStart stops and starts the program name, as supervisord has
+// no restart method. Stopping fails for programs not running, which is only
+// logged if the program is still running.
+func supervisordStopStart(url, name string) error {
+	if _, err := xmlrpc.Call(url, "supervisor.stopProcess", name); err != nil && supervisordRunning(url, name) {
+		log.Warnf("Error stopping supervisord program %s before starting it: %s", name, err)
+	}
+	if _, err := xmlrpc.Call(url, "supervisor.startProcess", name); err != nil {
+		return fmt.Errorf("couldn't start %s: %s", name, err)
+	}
+	return nil
+}
+
+// supervisordRunning returns whether the program name is in one of the
+// states stopProcess accepts, starting, running or backoff, or its state is
+// unknown. The fault of stopProcess isn't returned by the XML-RPC client, so
+// the state tells NOT_RUNNING faults apart from the others.
+func supervisordRunning(url, name string) bool {
+	const (
+		starting = 10
+		running  = 20
+		backoff  = 30
+	)
+	res, err := xmlrpc.Call(url, "supervisor.getProcessInfo", name)
+	if err != nil {
+		return true
+	}
+	info, ok := res.(xmlrpc.Struct)
+	if !ok {
+		return true
+	}
+	switch info["state"] {
+	case starting, running, backoff:
+		return true
+	}
+	return false
+}
+
+// command runs args, returning the end of its output along with the error if
+// it fails.
+func command(ctx context.Context, args []string) error {
+	out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+	out = bytes.TrimSpace(out)
+	if err == nil || len(out) == 0 {
+		return err
+	}
+	if len(out) > maxCommandOutput {
+		out = out[len(out)-maxCommandOutput:]
+	}
+	return fmt.Errorf("%s: %s", err, out)
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicecheck
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/node_exporter/utils"
+)
+
+func TestCommandAction(t *testing.T) {
+	for _, c := range []struct {
+		cfg utils.ServiceCheckActionConfig
+		err string
+	}{
+		{utils.ServiceCheckActionConfig{Command: []string{"true"}}, ""},
+		{utils.ServiceCheckActionConfig{Command: []string{"sh", "-c", "echo cleanup failed; exit 1"}}, "exit status 1: cleanup failed"},
+		{utils.ServiceCheckActionConfig{Command: []string{"sleep", "10"}, Timeout: 10 * time.Millisecond}, "killed"},
+	} {
+		a, err := newAction(&c.cfg, defaultSupervisordURL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a.run(context.Background())
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s: %s", a, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("%s: want an error containing %q, got %v", a, c.err, err)
+		}
+	}
+}
+
+// xmlrpcFault is the response of supervisord to a failed call.
+const xmlrpcFault = `<?xml version="1.0"?><methodResponse><fault><value><struct>` +
+	`<member><name>faultCode</name><value><int>%d</int></value></member>` +
+	`<member><name>faultString</name><value><string>%s</string></value></member>` +
+	`</struct></value></fault></methodResponse>`
+
+// supervisord records the XML-RPC calls made to it, failing to start the
+// program failStart and to stop the program stopped, which isn't running.
+// Calls take delay.
+type supervisord struct {
+	mtx       sync.Mutex
+	calls     []string
+	failStart string
+	stopped   string
+	delay     time.Duration
+}
+
+func (s *supervisord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var call struct {
+		MethodName string   `xml:"methodName"`
+		Params     []string `xml:"params>param>value>string"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	time.Sleep(s.delay)
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.calls = append(s.calls, fmt.Sprintf("%s(%s)", call.MethodName, strings.Join(call.Params, ",")))
+	switch {
+	case call.MethodName == "supervisor.startProcess" && call.Params[0] == s.failStart:
+		fmt.Fprintf(w, xmlrpcFault, 50, "SPAWN_ERROR")
+	case call.MethodName == "supervisor.stopProcess" && call.Params[0] == s.stopped:
+		fmt.Fprintf(w, xmlrpcFault, 70, "NOT_RUNNING")
+	case call.MethodName == "supervisor.getProcessInfo":
+		state := 20
+		if call.Params[0] == s.stopped {
+			state = 0
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?><methodResponse><params><param><value><struct>`+
+			`<member><name>name</name><value><string>%s</string></value></member>`+
+			`<member><name>state</name><value><int>%d</int></value></member>`+
+			`</struct></value></param></params></methodResponse>`, call.Params[0], state)
+	default:
+		fmt.Fprint(w, `<?xml version="1.0"?><methodResponse><params><param><value><boolean>1</boolean></value></param></params></methodResponse>`)
+	}
+}
+
+func (s *supervisord) callsString() string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return strings.Join(s.calls, " ")
+}
+
+func TestSupervisordRestartAction(t *testing.T) {
+	s := &supervisord{failStart: "broken", stopped: "cron"}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	a, err := newAction(&utils.ServiceCheckActionConfig{SupervisordRestart: "etcd"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := "supervisor.stopProcess(etcd) supervisor.startProcess(etcd)"
+	if got := s.callsString(); got != want {
+		t.Errorf("want calls %s, got %s", want, got)
+	}
+
+	// A program not running fails to stop and is started.
+	s.calls = nil
+	a, err = newAction(&utils.ServiceCheckActionConfig{SupervisordRestart: "cron"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want = "supervisor.stopProcess(cron) supervisor.getProcessInfo(cron) supervisor.startProcess(cron)"
+	if got := s.callsString(); got != want {
+		t.Errorf("want calls %s, got %s", want, got)
+	}
+
+	a, err = newAction(&utils.ServiceCheckActionConfig{SupervisordRestart: "broken"}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.run(context.Background()); err == nil {
+		t.Error("want an error when the program doesn't start")
+	}
+}
+
+func TestSupervisordRestartTimeout(t *testing.T) {
+	s := &supervisord{delay: 200 * time.Millisecond}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	a, err := newAction(&utils.ServiceCheckActionConfig{SupervisordRestart: "etcd", Timeout: 10 * time.Millisecond}, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := a.run(context.Background()); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("want the action to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("want the action interrupted after its timeout, took %s", elapsed)
+	}
+}
+
+func TestNewActionErrors(t *testing.T) {
+	for _, cfg := range []*utils.ServiceCheckActionConfig{
+		{},
+		{SystemdRestart: "etcd.service", Command: []string{"true"}},
+	} {
+		if _, err := newAction(cfg, defaultSupervisordURL); err == nil {
+			t.Errorf("want an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicecheck
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/node_exporter/utils"
+	"github.com/prometheus/procfs"
+)
+
+// newProbe returns the probe of the service of cfg, returning the PIDs of its
+// processes or why it is missing, and whether the service has processes. The
+// processes are looked up in fs.
+func newProbe(cfg *utils.ServiceCheckConfig, fs procfs.FS) (func() ([]int, error), bool, error) {
+	set := 0
+	for _, s := range []string{cfg.Process, cfg.Pidfile, cfg.TCP, cfg.HTTP, cfg.SystemdUnit} {
+		if s != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, false, errors.New("exactly one of process, pidfile, tcp, http and systemd_unit must be set")
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	switch {
+	case cfg.Process != "":
+		re, err := regexp.Compile(cfg.Process)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid process regex: %s", err)
+		}
+		return func() ([]int, error) { return processProbe(fs, re) }, true, nil
+	case cfg.Pidfile != "":
+		return func() ([]int, error) { return pidfileProbe(fs, cfg.Pidfile) }, true, nil
+	case cfg.TCP != "":
+		if _, _, err := net.SplitHostPort(cfg.TCP); err != nil {
+			return nil, false, fmt.Errorf("invalid TCP address: %s", err)
+		}
+		return func() ([]int, error) { return nil, tcpProbe(cfg.TCP, timeout) }, false, nil
+	case cfg.HTTP != "":
+		u, err := url.Parse(cfg.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, false, fmt.Errorf("invalid HTTP URL %q", cfg.HTTP)
+		}
+		client := &http.Client{Timeout: timeout}
+		return func() ([]int, error) { return nil, httpProbe(client, cfg.HTTP) }, false, nil
+	default:
+		return func() ([]int, error) { return systemdProbe(cfg.SystemdUnit) }, true, nil
+	}
+}
+
+// running returns whether the process is running, zombies are not.
+func running(p procfs.Proc) bool {
+	stat, err := p.NewStat()
+	return err == nil && stat.State != "Z"
+}
+
+// processProbe returns the processes whose command line matches re, other
+// than the node_exporter itself.
+func processProbe(fs procfs.FS, re *regexp.Regexp) ([]int, error) {
+	procs, err := fs.AllProcs()
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	for _, p := range procs {
+		if p.PID == os.Getpid() {
+			continue
+		}
+		cmdline, err := p.CmdLine()
+		if err != nil {
+			continue
+		}
+		line := strings.Join(cmdline, " ")
+		if line == "" {
+			// Kernel threads have no command line.
+			if line, err = p.Comm(); err != nil {
+				continue
+			}
+		}
+		if re.MatchString(line) && running(p) {
+			pids = append(pids, p.PID)
+		}
+	}
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("no process matching %q", re)
+	}
+	return pids, nil
+}
+
+// pidfileProbe returns the process of the PID in path.
+func pidfileProbe(fs procfs.FS, path string) ([]int, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PID in %s: %s", path, err)
+	}
+	p, err := fs.NewProc(pid)
+	if err != nil || !running(p) {
+		return nil, fmt.Errorf("process %d of %s not running", pid, path)
+	}
+	return []int{pid}, nil
+}
+
+func tcpProbe(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func httpProbe(client *http.Client, url string) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicecheck
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/node_exporter/utils"
+	"github.com/prometheus/procfs"
+)
+
+// procFS is the proc filesystem of the host running the tests.
+var procFS = procfs.FS(procfs.DefaultMountPoint)
+
+func TestProcessProbes(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no procfs")
+	}
+	cmd := exec.Command("sleep", "1234.5")
+	if err := cmd.Start(); err != nil {
+		t.Skip(err)
+	}
+	pid := cmd.Process.Pid
+	dir, err := ioutil.TempDir("", "servicecheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "sleep.pid")
+	if err := ioutil.WriteFile(pidfile, []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	probes := map[string]*utils.ServiceCheckConfig{
+		"process": {Process: `^sleep 1234\.5$`},
+		"pidfile": {Pidfile: pidfile},
+	}
+	for name, cfg := range probes {
+		probe, processes, err := newProbe(cfg, procFS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !processes {
+			t.Errorf("%s: want a probe of processes", name)
+		}
+		if pids, err := probe(); err != nil || len(pids) != 1 || pids[0] != pid {
+			t.Errorf("%s: want PID %d, got %v, %v", name, pid, pids, err)
+		}
+	}
+
+	cmd.Process.Kill()
+	cmd.Wait()
+	for name, cfg := range probes {
+		probe, _, _ := newProbe(cfg, procFS)
+		if pids, err := probe(); err == nil {
+			t.Errorf("%s: want the process missing, got %v", name, pids)
+		}
+	}
+}
+
+func TestNetworkProbes(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tcp, processes, err := newProbe(&utils.ServiceCheckConfig{TCP: l.Addr().String(), Timeout: time.Second}, procFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processes {
+		t.Error("want a TCP probe without processes")
+	}
+	if _, err := tcp(); err != nil {
+		t.Errorf("tcp: %s", err)
+	}
+	l.Close()
+	if _, err := tcp(); err == nil {
+		t.Error("tcp: want an error once the port is closed")
+	}
+
+	healthz, _, err := newProbe(&utils.ServiceCheckConfig{HTTP: server.URL + "/healthz"}, procFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := healthz(); err != nil {
+		t.Errorf("http: %s", err)
+	}
+	missing, _, err := newProbe(&utils.ServiceCheckConfig{HTTP: server.URL + "/missing"}, procFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := missing(); err == nil {
+		t.Error("http: want an error for an error status")
+	}
+}
+
+func TestNewProbeErrors(t *testing.T) {
+	for _, cfg := range []*utils.ServiceCheckConfig{
+		{},
+		{Process: "etcd", TCP: "localhost:2379"},
+		{Process: "etcd("},
+		{TCP: "localhost"},
+		{HTTP: "localhost:8080/healthz"},
+	} {
+		if _, _, err := newProbe(cfg, procFS); err == nil {
+			t.Errorf("want an error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package servicecheck checks the services of the node every cycle and
+// remediates the failed ones, e.g. by restarting them, like Monit.
+package servicecheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+	"github.com/prometheus/node_exporter/utils"
+	"github.com/prometheus/procfs"
+)
+
+const (
+	namespace = "node"
+	subsystem = "servicecheck"
+)
+
+const (
+	defaultInterval       = 30 * time.Second
+	defaultTimeout        = 5 * time.Second
+	defaultActionTimeout  = 30 * time.Second
+	defaultMaxActions     = 5
+	defaultActionPeriod   = time.Hour
+	defaultSupervisordURL = "http://localhost:9001/RPC2"
+)
+
+// The states of a check.
+const (
+	stateOK      = "ok"
+	stateFailed  = "failed"
+	stateGivenUp = "given_up"
+)
+
+var states = []string{stateOK, stateFailed, stateGivenUp}
+
+// The conditions of the rules.
+const (
+	conditionMissing = "missing"
+	conditionCPU     = "cpu"
+	conditionMemory  = "memory"
+)
+
+var (
+	stateGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "state",
+		Help:      "Whether the service check is in the state, ok, failed or given_up once its actions were given up.",
+	}, []string{"check", "state"})
+	processesGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "processes",
+		Help:      "Number of processes of the service.",
+	}, []string{"check"})
+	cpuGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "cpu_percent",
+		Help:      "CPU usage of the processes of the service during the last cycle, in percent of a CPU.",
+	}, []string{"check"})
+	memoryGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "resident_memory_bytes",
+		Help:      "Resident memory of the processes of the service.",
+	}, []string{"check"})
+	failuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "failures_total",
+		Help:      "Total number of times the service check failed.",
+	}, []string{"check"})
+	actionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "actions_total",
+		Help:      "Total number of actions run by the service check, by result.",
+	}, []string{"check", "action", "result"})
+	actionsRateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: subsystem,
+		Name:      "actions_rate_limited_total",
+		Help:      "Total number of cycles the actions of the failed service check didn't run because of the rate limit.",
+	}, []string{"check"})
+
+	// Metrics are the metrics of the service checks, to be registered once.
+	Metrics = []prometheus.Collector{
+		stateGauge, processesGauge, cpuGauge, memoryGauge, failuresTotal, actionsTotal, actionsRateLimited,
+	}
+)
+
+// status is the result of probing a service.
+type status struct {
+	// err is why the service is missing, nil if it isn't.
+	err       error
+	processes int
+	// cpu is the CPU usage in percent of a CPU, NaN if unknown.
+	cpu    float64
+	memory float64
+}
+
+type rule struct {
+	condition string
+	threshold float64
+	cycles    int
+	// held is the number of consecutive cycles the condition held.
+	held int
+}
+
+func newRule(cfg *utils.ServiceCheckRuleConfig) (*rule, error) {
+	r := &rule{condition: cfg.Condition, threshold: cfg.Threshold, cycles: cfg.Cycles}
+	switch r.condition {
+	case conditionMissing, conditionCPU, conditionMemory:
+	default:
+		return nil, fmt.Errorf("unknown condition %q", r.condition)
+	}
+	if r.cycles <= 0 {
+		r.cycles = 1
+	}
+	return r, nil
+}
+
+func (r *rule) holds(s *status) bool {
+	switch r.condition {
+	case conditionMissing:
+		return s.err != nil
+	case conditionCPU:
+		return !math.IsNaN(s.cpu) && s.cpu > r.threshold
+	case conditionMemory:
+		return s.processes > 0 && s.memory > r.threshold
+	}
+	return false
+}
+
+// describe returns why the condition holds.
+func (r *rule) describe(s *status) string {
+	var d string
+	switch r.condition {
+	case conditionMissing:
+		d = fmt.Sprintf("service missing: %s", s.err)
+	case conditionCPU:
+		d = fmt.Sprintf("CPU usage %.1f%% above %g%%", s.cpu, r.threshold)
+	case conditionMemory:
+		d = fmt.Sprintf("resident memory %.0f bytes above %g", s.memory, r.threshold)
+	}
+	if r.cycles > 1 {
+		d += fmt.Sprintf(" for %d cycles", r.cycles)
+	}
+	return d
+}
+
+type check struct {
+	name         string
+	fs           procfs.FS
+	probe        func() ([]int, error)
+	rules        []*rule
+	actions      []*action
+	maxActions   int
+	actionPeriod time.Duration
+	giveUpAfter  int
+
+	// cpuTimes are the CPU times of the processes at lastProbe, by PID.
+	cpuTimes  map[int]float64
+	lastProbe time.Time
+
+	state string
+	// actionTimes are when the actions ran within the action period.
+	actionTimes []time.Time
+	// attempts is the number of times the actions ran since the check failed.
+	attempts int
+}
+
+func newCheck(cfg *utils.ServiceCheckConfig, supervisordURL string, fs procfs.FS) (*check, error) {
+	if cfg.Name == "" {
+		return nil, errors.New("missing name of service check")
+	}
+	c := &check{
+		name:         cfg.Name,
+		fs:           fs,
+		maxActions:   cfg.MaxActions,
+		actionPeriod: cfg.ActionPeriod,
+		giveUpAfter:  cfg.GiveUpAfter,
+		state:        stateOK,
+	}
+	if c.maxActions <= 0 {
+		c.maxActions = defaultMaxActions
+	}
+	if c.actionPeriod <= 0 {
+		c.actionPeriod = defaultActionPeriod
+	}
+	var (
+		err       error
+		processes bool
+	)
+	if c.probe, processes, err = newProbe(cfg, fs); err != nil {
+		return nil, fmt.Errorf("service check %s: %s", c.name, err)
+	}
+	missing := false
+	for _, rc := range cfg.Rules {
+		r, err := newRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("service check %s: %s", c.name, err)
+		}
+		if r.condition != conditionMissing && !processes {
+			return nil, fmt.Errorf("service check %s: the %s condition needs a process, pidfile or systemd_unit check", c.name, r.condition)
+		}
+		missing = missing || r.condition == conditionMissing
+		c.rules = append(c.rules, r)
+	}
+	if !missing {
+		c.rules = append(c.rules, &rule{condition: conditionMissing, cycles: 1})
+	}
+	for _, ac := range cfg.Actions {
+		a, err := newAction(ac, supervisordURL)
+		if err != nil {
+			return nil, fmt.Errorf("service check %s: %s", c.name, err)
+		}
+		c.actions = append(c.actions, a)
+	}
+	return c, nil
+}
+
+// status probes the service at now, measuring the CPU usage of its processes
+// since the last cycle.
+func (c *check) status(now time.Time) *status {
+	pids, err := c.probe()
+	s := &status{err: err, processes: len(pids), cpu: math.NaN()}
+	cpuTimes := make(map[int]float64, len(pids))
+	var used float64
+	measured := false
+	for _, pid := range pids {
+		p, err := c.fs.NewProc(pid)
+		if err != nil {
+			continue
+		}
+		stat, err := p.NewStat()
+		if err != nil {
+			continue
+		}
+		cpuTimes[pid] = stat.CPUTime()
+		s.memory += float64(stat.ResidentMemory())
+		if last, ok := c.cpuTimes[pid]; ok && cpuTimes[pid] >= last {
+			used += cpuTimes[pid] - last
+			measured = true
+		}
+	}
+	if elapsed := now.Sub(c.lastProbe).Seconds(); measured && elapsed > 0 {
+		s.cpu = used / elapsed * 100
+	}
+	c.cpuTimes, c.lastProbe = cpuTimes, now
+	return s
+}
+
+// cycle runs the check at now, and its actions if it failed.
+func (c *check) cycle(ctx context.Context, now time.Time) {
+	s := c.status(now)
+	var failed *rule
+	for _, r := range c.rules {
+		if !r.holds(s) {
+			r.held = 0
+			continue
+		}
+		r.held++
+		if failed == nil && r.held >= r.cycles {
+			failed = r
+		}
+	}
+	processesGauge.WithLabelValues(c.name).Set(float64(s.processes))
+	memoryGauge.WithLabelValues(c.name).Set(s.memory)
+	if math.IsNaN(s.cpu) {
+		cpuGauge.DeleteLabelValues(c.name)
+	} else {
+		cpuGauge.WithLabelValues(c.name).Set(s.cpu)
+	}
+
+	if failed == nil {
+		if c.state != stateOK {
+			log.Infof("Service check %s recovered", c.name)
+		}
+		c.state, c.attempts = stateOK, 0
+	} else {
+		if c.state == stateOK {
+			log.Warnf("Service check %s failed: %s", c.name, failed.describe(s))
+			failuresTotal.WithLabelValues(c.name).Inc()
+			c.state = stateFailed
+		}
+		c.remediate(ctx, now)
+	}
+	for _, state := range states {
+		v := 0.0
+		if state == c.state {
+			v = 1
+		}
+		stateGauge.WithLabelValues(c.name, state).Set(v)
+	}
+}
+
+// remediate runs the actions of the failed check, unless they are rate
+// limited or given up.
+func (c *check) remediate(ctx context.Context, now time.Time) {
+	if len(c.actions) == 0 || c.state == stateGivenUp {
+		return
+	}
+	if c.giveUpAfter > 0 && c.attempts >= c.giveUpAfter {
+		log.Errorf("Service check %s still failing after running its actions %d times, giving up until it recovers", c.name, c.attempts)
+		c.state = stateGivenUp
+		return
+	}
+	for len(c.actionTimes) > 0 && now.Sub(c.actionTimes[0]) >= c.actionPeriod {
+		c.actionTimes = c.actionTimes[1:]
+	}
+	if len(c.actionTimes) >= c.maxActions {
+		log.Debugf("Service check %s ran its actions %d times within %s, not running them", c.name, len(c.actionTimes), c.actionPeriod)
+		actionsRateLimited.WithLabelValues(c.name).Inc()
+		return
+	}
+	c.actionTimes = append(c.actionTimes, now)
+	c.attempts++
+	for _, a := range c.actions {
+		log.Infof("Service check %s: running %s", c.name, a)
+		result := "success"
+		if err := a.run(ctx); err != nil {
+			log.Errorf("Service check %s: error running %s: %s", c.name, a, err)
+			result = "error"
+		}
+		actionsTotal.WithLabelValues(c.name, a.kind, result).Inc()
+	}
+}
+
+func (c *check) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.cycle(ctx, time.Now())
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// deleteMetrics deletes the gauges of the check.
+func (c *check) deleteMetrics() {
+	for _, state := range states {
+		stateGauge.DeleteLabelValues(c.name, state)
+	}
+	processesGauge.DeleteLabelValues(c.name)
+	cpuGauge.DeleteLabelValues(c.name)
+	memoryGauge.DeleteLabelValues(c.name)
+}
+
+// Manager runs the service checks, each in its own goroutine.
+type Manager struct {
+	interval time.Duration
+	checks   []*check
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewManager returns a Manager running the checks of cfg, looking up
+// processes in the proc filesystem mounted at procPath. It has to be started.
+// A nil cfg returns a nil Manager.
+func NewManager(cfg *utils.ServiceChecksConfig, procPath string) (*Manager, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	m := &Manager{interval: cfg.Interval}
+	if m.interval <= 0 {
+		m.interval = defaultInterval
+	}
+	supervisordURL := cfg.SupervisordURL
+	if supervisordURL == "" {
+		supervisordURL = defaultSupervisordURL
+	}
+	names := map[string]bool{}
+	for _, cc := range cfg.Checks {
+		c, err := newCheck(cc, supervisordURL, procfs.FS(procPath))
+		if err != nil {
+			return nil, err
+		}
+		if names[c.name] {
+			return nil, fmt.Errorf("duplicate service check %s", c.name)
+		}
+		names[c.name] = true
+		m.checks = append(m.checks, c)
+	}
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	return m, nil
+}
+
+// Start starts running the checks in the background. The checks of old,
+// which must be stopped, pass their state and the times their actions ran
+// to the checks with the same name, so a reload doesn't reset the rate
+// limits. old may be nil.
+func (m *Manager) Start(old *Manager) {
+	if old != nil {
+		for _, c := range m.checks {
+			for _, o := range old.checks {
+				if o.name == c.name {
+					c.state, c.actionTimes, c.attempts = o.state, o.actionTimes, o.attempts
+					break
+				}
+			}
+		}
+	}
+	log.Infof("Running %d service checks every %s", len(m.checks), m.interval)
+	for _, c := range m.checks {
+		m.wg.Add(1)
+		go func(c *check) {
+			defer m.wg.Done()
+			c.run(m.ctx, m.interval)
+		}(c)
+	}
+}
+
+// Stop stops running the checks, interrupting the running actions.
+func (m *Manager) Stop() {
+	m.cancel()
+	m.wg.Wait()
+	for _, c := range m.checks {
+		c.deleteMetrics()
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicecheck
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/node_exporter/utils"
+	"github.com/prometheus/procfs"
+)
+
+func gaugeValue(t *testing.T, labels ...string) float64 {
+	var m dto.Metric
+	if err := stateGauge.WithLabelValues(labels...).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestRules(t *testing.T) {
+	for _, c := range []struct {
+		rule  utils.ServiceCheckRuleConfig
+		s     status
+		holds bool
+	}{
+		{utils.ServiceCheckRuleConfig{Condition: "missing"}, status{err: errors.New("down"), cpu: math.NaN()}, true},
+		{utils.ServiceCheckRuleConfig{Condition: "missing"}, status{processes: 1, cpu: math.NaN()}, false},
+		{utils.ServiceCheckRuleConfig{Condition: "cpu", Threshold: 80}, status{processes: 1, cpu: 90}, true},
+		{utils.ServiceCheckRuleConfig{Condition: "cpu", Threshold: 80}, status{processes: 1, cpu: 50}, false},
+		{utils.ServiceCheckRuleConfig{Condition: "cpu", Threshold: 80}, status{processes: 1, cpu: math.NaN()}, false},
+		{utils.ServiceCheckRuleConfig{Condition: "memory", Threshold: 1e9}, status{processes: 2, cpu: math.NaN(), memory: 2e9}, true},
+		{utils.ServiceCheckRuleConfig{Condition: "memory", Threshold: 1e9}, status{processes: 1, cpu: math.NaN(), memory: 1e8}, false},
+	} {
+		r, err := newRule(&c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.holds(&c.s); got != c.holds {
+			t.Errorf("%+v on %+v: want %t, got %t", c.rule, c.s, c.holds, got)
+		}
+	}
+}
+
+func TestCheckCPU(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no procfs")
+	}
+	c := &check{fs: procFS, probe: func() ([]int, error) { return []int{os.Getpid()}, nil }}
+	now := time.Now()
+	if s := c.status(now); !math.IsNaN(s.cpu) || s.memory <= 0 {
+		t.Errorf("want an unknown CPU usage and the memory, got %+v", s)
+	}
+	for start := time.Now(); time.Since(start) < 200*time.Millisecond; {
+	}
+	if s := c.status(now.Add(time.Second)); math.IsNaN(s.cpu) || s.cpu <= 0 {
+		t.Errorf("want the CPU usage, got %+v", s)
+	}
+}
+
+func TestCheckRemediation(t *testing.T) {
+	c, err := newCheck(&utils.ServiceCheckConfig{
+		Name:         "remediation",
+		TCP:          "localhost:2379",
+		Rules:        []*utils.ServiceCheckRuleConfig{{Condition: "missing", Cycles: 2}},
+		Actions:      []*utils.ServiceCheckActionConfig{{Command: []string{"true"}}},
+		MaxActions:   2,
+		ActionPeriod: time.Hour,
+		GiveUpAfter:  3,
+	}, defaultSupervisordURL, procFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.deleteMetrics()
+	var down error
+	c.probe = func() ([]int, error) { return nil, down }
+	runs := 0
+	c.actions[0].do = func(context.Context) error {
+		runs++
+		return nil
+	}
+
+	now := time.Now()
+	down = errors.New("connection refused")
+	for _, step := range []struct {
+		at    time.Duration
+		state string
+		runs  int
+	}{
+		{0, stateOK, 0},
+		{time.Minute, stateFailed, 1},
+		{2 * time.Minute, stateFailed, 2},
+		// The actions ran twice within the hour.
+		{3 * time.Minute, stateFailed, 2},
+		{63 * time.Minute, stateFailed, 3},
+		// The actions ran three times without the check recovering.
+		{64 * time.Minute, stateGivenUp, 3},
+		{65 * time.Minute, stateGivenUp, 3},
+	} {
+		c.cycle(context.Background(), now.Add(step.at))
+		if c.state != step.state || runs != step.runs {
+			t.Fatalf("at %s: want state %s and %d runs, got %s and %d", step.at, step.state, step.runs, c.state, runs)
+		}
+	}
+	if v := gaugeValue(t, "remediation", stateGivenUp); v != 1 {
+		t.Errorf("want the given_up state exported, got %g", v)
+	}
+
+	down = nil
+	c.cycle(context.Background(), now.Add(66*time.Minute))
+	if c.state != stateOK || c.attempts != 0 {
+		t.Errorf("want the check recovered, got state %s after %d attempts", c.state, c.attempts)
+	}
+	if v := gaugeValue(t, "remediation", stateGivenUp); v != 0 {
+		t.Errorf("want the given_up state cleared, got %g", v)
+	}
+}
+
+func TestManagerStartKeepsHistory(t *testing.T) {
+	cfg := &utils.ServiceChecksConfig{
+		Interval: time.Hour,
+		Checks: []*utils.ServiceCheckConfig{{
+			Name:    "etcd",
+			TCP:     "localhost:2379",
+			Actions: []*utils.ServiceCheckActionConfig{{Command: []string{"true"}}},
+		}},
+	}
+	old, err := NewManager(cfg, procfs.DefaultMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old.checks[0].state = stateFailed
+	old.checks[0].actionTimes = []time.Time{time.Now()}
+	old.checks[0].attempts = 1
+
+	m, err := NewManager(cfg, procfs.DefaultMountPoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.checks[0].probe = func() ([]int, error) { return nil, errors.New("connection refused") }
+	m.checks[0].actions[0].do = func(context.Context) error { return nil }
+	m.Start(old)
+	m.Stop()
+	if c := m.checks[0]; c.state != stateFailed || len(c.actionTimes) != 2 || c.attempts != 2 {
+		t.Errorf("want the history kept, got state %s, %d actions and %d attempts", c.state, len(c.actionTimes), c.attempts)
+	}
+}
+
+func TestManagerProcPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "servicecheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidfile := filepath.Join(dir, "khungtaskd.pid")
+	if err := ioutil.WriteFile(pidfile, []byte("10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewManager(&utils.ServiceChecksConfig{
+		Checks: []*utils.ServiceCheckConfig{{Name: "khungtaskd", Pidfile: pidfile}},
+	}, "../collector/fixtures/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pids, err := m.checks[0].probe(); err != nil || len(pids) != 1 || pids[0] != 10 {
+		t.Errorf("want PID 10 of the fixtures, got %v, %v", pids, err)
+	}
+}
+
+func TestNewManagerErrors(t *testing.T) {
+	for _, cfg := range []*utils.ServiceChecksConfig{
+		{Checks: []*utils.ServiceCheckConfig{{TCP: "localhost:2379"}}},
+		{Checks: []*utils.ServiceCheckConfig{{Name: "etcd", TCP: "localhost:2379"}, {Name: "etcd", Process: "etcd"}}},
+		{Checks: []*utils.ServiceCheckConfig{{Name: "etcd", TCP: "localhost:2379", Rules: []*utils.ServiceCheckRuleConfig{{Condition: "cpu"}}}}},
+		{Checks: []*utils.ServiceCheckConfig{{Name: "etcd", Process: "etcd", Rules: []*utils.ServiceCheckRuleConfig{{Condition: "disk"}}}}},
+		{Checks: []*utils.ServiceCheckConfig{{Name: "etcd", Process: "etcd", Actions: []*utils.ServiceCheckActionConfig{{}}}}},
+	} {
+		if _, err := NewManager(cfg, procfs.DefaultMountPoint); err == nil {
+			t.Errorf("want an error for %+v", cfg.Checks)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package servicecheck
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/coreos/go-systemd/dbus"
+)
+
+// systemdProbe returns the main process of unit, if it is a service, or why
+// it isn't active.
+func systemdProbe(unit string) ([]int, error) {
+	conn, err := dbus.New()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to systemd: %s", err)
+	}
+	defer conn.Close()
+	prop, err := conn.GetUnitProperty(unit, "ActiveState")
+	if err != nil {
+		return nil, fmt.Errorf("couldn't get the state of %s: %s", unit, err)
+	}
+	if state, _ := prop.Value.Value().(string); state != "active" {
+		return nil, fmt.Errorf("unit %s is %s", unit, state)
+	}
+	if !strings.HasSuffix(unit, ".service") {
+		return nil, nil
+	}
+	prop, err = conn.GetServiceProperty(unit, "MainPID")
+	if err != nil {
+		return nil, nil
+	}
+	if pid, _ := prop.Value.Value().(uint32); pid > 0 {
+		return []int{int(pid)}, nil
+	}
+	return nil, nil
+}
+
+// systemdRestart restarts unit and waits for the restart to complete.
+func systemdRestart(ctx context.Context, unit string) error {
+	conn, err := dbus.New()
+	if err != nil {
+		return fmt.Errorf("couldn't connect to systemd: %s", err)
+	}
+	defer conn.Close()
+	done := make(chan string, 1)
+	if _, err := conn.RestartUnit(unit, "replace", done); err != nil {
+		return err
+	}
+	select {
+	case result := <-done:
+		if result != "done" {
+			return fmt.Errorf("restart job %s", result)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/coreos/go-systemd/activation"
+	"github.com/coreos/go-systemd/daemon"
+	"github.com/prometheus/common/log"
+)
+
+// readyPollInterval is how often readiness is checked before READY=1 is sent.
+var readyPollInterval = time.Second
+
+// systemdListener returns the socket passed by systemd socket activation.
+func systemdListener() (net.Listener, error) {
+	listeners, err := activation.Listeners(true)
+	if err != nil {
+		return nil, err
+	}
+	switch len(listeners) {
+	case 0:
+		return nil, errors.New("no socket passed by systemd")
+	case 1:
+	default:
+		return nil, fmt.Errorf("%d sockets passed by systemd, want one", len(listeners))
+	}
+	if listeners[0] == nil {
+		return nil, errors.New("socket passed by systemd is not a stream socket")
+	}
+	return listeners[0], nil
+}
+
+// notifySystemd sends READY=1 to systemd once node_exporter is ready. If the
+// systemd watchdog is enabled, it then sends WATCHDOG=1 at half the watchdog
+// timeout as long as no collection has been running for longer than the
+// timeout, so systemd restarts a wedged node_exporter. It returns at once
+// unless node_exporter runs as a notify service.
+func notifySystemd(hc *health, done <-chan struct{}) {
+	if os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	watchdog, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		log.Errorln("Invalid systemd watchdog settings, not pinging it:", err)
+	}
+
+	ticker := time.NewTicker(readyPollInterval)
+	for hc.ready() != nil {
+		select {
+		case <-ticker.C:
+		case <-done:
+			ticker.Stop()
+			return
+		}
+	}
+	ticker.Stop()
+	sdNotify("READY=1")
+	if watchdog <= 0 {
+		return
+	}
+
+	log.Infof("Pinging the systemd watchdog every %s", watchdog/2)
+	ticker = time.NewTicker(watchdog / 2)
+	defer ticker.Stop()
+	for {
+		if err := hc.live(watchdog); err != nil {
+			log.Errorln("Not pinging the systemd watchdog:", err)
+		} else {
+			sdNotify("WATCHDOG=1")
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// sdNotify sends state to systemd, if node_exporter runs as a notify service.
+func sdNotify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		log.Errorf("Error sending %s to systemd: %s", state, err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/node_exporter/collector"
+)
+
+func TestNotifySystemd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "node_exporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	os.Setenv("NOTIFY_SOCKET", socket)
+	os.Setenv("WATCHDOG_USEC", "100000")
+	defer os.Unsetenv("NOTIFY_SOCKET")
+	defer os.Unsetenv("WATCHDOG_USEC")
+	defer func(interval time.Duration) { readyPollInterval = interval }(readyPollInterval)
+	readyPollInterval = 10 * time.Millisecond
+
+	limiter := newScrapeLimiter(0, 0, 0)
+	hc := newHealth(nil)
+	done := make(chan struct{})
+	defer close(done)
+	go notifySystemd(hc, done)
+
+	receive := func() string {
+		conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if err != nil {
+			return ""
+		}
+		return string(buf[:n])
+	}
+	if state := receive(); state != "" {
+		t.Errorf("want nothing sent before ready, got %q", state)
+	}
+	hc.loaded(&handler{nc: &collector.NodeCollector{}, limiter: limiter})
+	for _, want := range []string{"READY=1", "WATCHDOG=1", "WATCHDOG=1"} {
+		if state := receive(); state != want {
+			t.Fatalf("want %q, got %q", want, state)
+		}
+	}
+
+	// A wedged collection stops the watchdog pings.
+	limiter.mtx.Lock()
+	limiter.calls["stalled"] = &gatherCall{started: time.Now().Add(-time.Minute)}
+	limiter.mtx.Unlock()
+	receive()
+	if state := receive(); state != "" {
+		t.Errorf("want no ping while a collection is stalled, got %q", state)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+// newTargetLabels returns the constant labels added to every series, read
+// from the cluster and target_labels sections of cfg.
+func newTargetLabels(cfg *utils.Config) (map[string]string, error) {
+	labels := map[string]string{}
+	if cfg == nil {
+		return labels, nil
+	}
+	for _, c := range cfg.Cluster {
+		if c != nil && c.ClusterLabel != "" {
+			labels["cluster"] = c.ClusterLabel
+		}
+	}
+
+	if tl := cfg.TargetLabels; tl != nil {
+		for _, file := range tl.MetadataFiles {
+			metadata, err := readMetadataFile(file)
+			if err != nil {
+				return nil, err
+			}
+			for name, value := range metadata {
+				labels[name] = value
+			}
+		}
+		for name, file := range tl.LabelFiles {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't read label file: %s", err)
+			}
+			labels[name] = strings.TrimSpace(string(content))
+		}
+		for name, value := range tl.Labels {
+			labels[name] = value
+		}
+	}
+
+	for name, value := range labels {
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, fmt.Errorf("invalid target label name %q", name)
+		}
+		if !model.LabelValue(value).IsValid() {
+			return nil, fmt.Errorf("invalid value %q of target label %q", value, name)
+		}
+	}
+	return labels, nil
+}
+
+// readMetadataFile reads a file of key=value lines like /etc/os-release.
+// Empty lines and lines starting with # are ignored, and values may be
+// quoted.
+func readMetadataFile(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read metadata file: %s", err)
+	}
+	defer f.Close()
+
+	metadata := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected key=value", file, n)
+		}
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			if value[0] == '"' {
+				if value, err = strconv.Unquote(value); err != nil {
+					return nil, fmt.Errorf("%s:%d: %s", file, n, err)
+				}
+			} else {
+				value = value[1 : len(value)-1]
+			}
+		}
+		metadata[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("couldn't read metadata file: %s", err)
+	}
+	return metadata, nil
+}
+
+// newTargetInfo returns the node_exporter_target_info metric carrying the
+// target labels.
+func newTargetInfo(labels map[string]string) prometheus.Collector {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   "node_exporter",
+		Name:        "target_info",
+		Help:        "Constant metric with the target labels added to every series.",
+		ConstLabels: labels,
+	})
+	g.Set(1)
+	return g
+}
+
+// labelGatherer adds constant labels to every metric gathered from g. Labels
+// already set on a metric are left unchanged.
+type labelGatherer struct {
+	g      prometheus.Gatherer
+	labels []*dto.LabelPair
+}
+
+func newLabelGatherer(g prometheus.Gatherer, labels map[string]string) prometheus.Gatherer {
+	if len(labels) == 0 {
+		return g
+	}
+	lg := &labelGatherer{g: g}
+	for name, value := range labels {
+		lg.labels = append(lg.labels, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	return lg
+}
+
+// Gather implements prometheus.Gatherer.
+func (lg *labelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := lg.g.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			for _, l := range lg.labels {
+				if !hasLabel(m, l.GetName()) {
+					m.Label = append(m.Label, l)
+				}
+			}
+			sort.Slice(m.Label, func(i, j int) bool { return m.Label[i].GetName() < m.Label[j].GetName() })
+		}
+	}
+	return mfs, err
+}
+
+func hasLabel(m *dto.Metric, name string) bool {
+	for _, l := range m.Label {
+		if l.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/node_exporter/utils"
+)
+
+func TestNewTargetLabels(t *testing.T) {
+	dir, err := ioutil.TempDir("", "target_labels")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	machineID := filepath.Join(dir, "machine-id")
+	metadata := filepath.Join(dir, "metadata")
+	if err := ioutil.WriteFile(machineID, []byte("0123456789abcdef\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	content := "# Node metadata.\n\nrack = r12\nrole=\"db \\\"primary\\\"\"\nzone='eu-1'\ncluster=from-file\n"
+	if err := ioutil.WriteFile(metadata, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	labels, err := newTargetLabels(&utils.Config{
+		Cluster: []*utils.ClusterConfig{{ClusterLabel: "from-cluster"}},
+		TargetLabels: &utils.TargetLabelsConfig{
+			Labels:        map[string]string{"zone": "eu-2"},
+			LabelFiles:    map[string]string{"machine_id": machineID},
+			MetadataFiles: []string{metadata},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"cluster":    "from-file",
+		"machine_id": "0123456789abcdef",
+		"rack":       "r12",
+		"role":       `db "primary"`,
+		"zone":       "eu-2",
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("want %v, got %v", want, labels)
+	}
+
+	for _, tl := range []*utils.TargetLabelsConfig{
+		{Labels: map[string]string{"__name__": "x"}},
+		{Labels: map[string]string{"not-valid": "x"}},
+		{LabelFiles: map[string]string{"machine_id": filepath.Join(dir, "missing")}},
+		{MetadataFiles: []string{machineID}},
+	} {
+		if _, err := newTargetLabels(&utils.Config{TargetLabels: tl}); err == nil {
+			t.Errorf("want an error for %+v", tl)
+		}
+	}
+}
+
+func TestLabelGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{Name: "node_test", Help: "Test metric."}))
+	registry.MustRegister(prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "node_test_rack",
+		Help:        "Test metric with a rack.",
+		ConstLabels: prometheus.Labels{"rack": "own"},
+	}))
+	registry.MustRegister(newTargetInfo(map[string]string{"cluster": "c1", "rack": "r12"}))
+
+	mfs, err := newLabelGatherer(registry, map[string]string{"cluster": "c1", "rack": "r12"}).Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]string{
+		"node_exporter_target_info": {"cluster": "c1", "rack": "r12"},
+		"node_test":                 {"cluster": "c1", "rack": "r12"},
+		"node_test_rack":            {"cluster": "c1", "rack": "own"},
+	}
+	if len(mfs) != len(want) {
+		t.Fatalf("want %d metric families, got %d", len(want), len(mfs))
+	}
+	for _, mf := range mfs {
+		labels := map[string]string{}
+		var names []string
+		for _, l := range mf.Metric[0].Label {
+			labels[l.GetName()] = l.GetValue()
+			names = append(names, l.GetName())
+		}
+		if !reflect.DeepEqual(labels, want[mf.GetName()]) {
+			t.Errorf("%s: want labels %v, got %v", mf.GetName(), want[mf.GetName()], labels)
+		}
+		if !reflect.DeepEqual(names, []string{"cluster", "rack"}) {
+			t.Errorf("%s: want sorted labels, got %v", mf.GetName(), names)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
 type ClusterConfig struct {
@@ -13,9 +14,218 @@ type ClusterConfig struct {
 	ConfigFile   string `yaml:"config_file"`
 }
 
+// CollectorConfig holds the settings of a single collector. Each setting
+// overrides the matching --collector.<name> flag given on the command line.
+type CollectorConfig struct {
+	Enabled  *bool          `yaml:"enabled"`
+	Timeout  *time.Duration `yaml:"timeout"`
+	Interval *time.Duration `yaml:"interval"`
+	// SeriesLimit caps the number of series sent by the collector.
+	SeriesLimit *int `yaml:"series_limit"`
+	// Options are the collector specific flags without the
+	// "collector.<name>." prefix, e.g. "unit-whitelist" for systemd.
+	Options map[string]string `yaml:"options"`
+	// RelabelConfigs are applied to the metrics of the collector, before the
+	// global ones.
+	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs"`
+}
+
+// RelabelConfig is a Prometheus style relabeling rule. The unset fields take
+// the defaults of Prometheus.
+type RelabelConfig struct {
+	SourceLabels []string `yaml:"source_labels,flow"`
+	// Separator joins the values of the source labels, ";" if unset.
+	Separator *string `yaml:"separator"`
+	// Regex is matched against the joined values, "(.*)" if unset.
+	Regex       *string `yaml:"regex"`
+	TargetLabel string  `yaml:"target_label"`
+	// Replacement is the value of the target label, "$1" if unset.
+	Replacement *string `yaml:"replacement"`
+	// Action is one of replace, keep, drop, labeldrop and labelmap, replace
+	// if unset.
+	Action string `yaml:"action"`
+}
+
+// ProfileConfig defines a named set of collectors served on its own path.
+type ProfileConfig struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+	// Collectors to run, all enabled collectors if empty.
+	Collectors []string `yaml:"collectors"`
+	// Options override collector options for this profile, using the
+	// "<collector>.<option>" names of the per-request parameters.
+	Options map[string]string `yaml:"options"`
+}
+
+// SinksConfig configures the systems metrics are pushed to, each is disabled
+// if not set.
+type SinksConfig struct {
+	Graphite *GraphiteConfig `yaml:"graphite"`
+	InfluxDB *InfluxDBConfig `yaml:"influxdb"`
+}
+
+// GraphiteConfig configures pushing metrics in the Graphite plaintext format
+// over TCP.
+type GraphiteConfig struct {
+	Address  string        `yaml:"address"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Prefix is prepended to every path.
+	Prefix string `yaml:"prefix"`
+	// PathLabels are the labels whose values are appended to the metric name
+	// in this order, other labels are dropped. By default, all labels are
+	// appended as name and value, sorted by name.
+	PathLabels []string `yaml:"path_labels"`
+}
+
+// InfluxDBConfig configures pushing metrics in the InfluxDB line protocol.
+type InfluxDBConfig struct {
+	// URL is the HTTP write endpoint including the database, e.g.
+	// http://localhost:8086/write?db=node, or udp://host:port.
+	URL      string        `yaml:"url"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+	// Tags renames labels to tags, labels mapped to an empty name are
+	// dropped.
+	Tags map[string]string `yaml:"tags"`
+}
+
+// TargetLabelsConfig sets constant labels added to every exported series.
+// Later sources override earlier ones: the cluster label of the cluster
+// section, metadata files in order, label files and labels.
+type TargetLabelsConfig struct {
+	Labels map[string]string `yaml:"labels"`
+	// LabelFiles set labels to the content of files, e.g. machine_id:
+	// /etc/machine-id.
+	LabelFiles map[string]string `yaml:"label_files"`
+	// MetadataFiles are files of key=value lines, each setting a label.
+	MetadataFiles []string `yaml:"metadata_files"`
+}
+
+// AlertingConfig configures the alerting rules evaluated on the metrics of
+// the node_exporter and the endpoints their alerts are sent to.
+type AlertingConfig struct {
+	// EvaluationInterval is the interval between two evaluations of the
+	// rules, 15s if unset.
+	EvaluationInterval time.Duration      `yaml:"evaluation_interval"`
+	Rules              []*AlertRuleConfig `yaml:"rules"`
+	// Alertmanagers are the base URLs of Alertmanagers, e.g.
+	// http://alertmanager:9093.
+	Alertmanagers []*NotifierConfig `yaml:"alertmanagers"`
+	// Webhooks are URLs receiving a JSON POST for every change of state.
+	Webhooks []*NotifierConfig `yaml:"webhooks"`
+	// Email sends the changes of state by email.
+	Email *EmailConfig `yaml:"email"`
+	// DefaultRules adds rules for failed collectors, failed systemd units,
+	// exited supervisord processes and filesystem device errors.
+	DefaultRules bool `yaml:"default_rules"`
+}
+
+// AlertRuleConfig is an alerting rule. Expr is a series selector compared to
+// a number, e.g. node_load1 > 10, or absent(<selector>). The values of Labels
+// and Annotations are templates, like in Prometheus.
+type AlertRuleConfig struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         time.Duration     `yaml:"for"`
+	Labels      map[string]string `yaml:"labels"`
+	Annotations map[string]string `yaml:"annotations"`
+}
+
+// NotifierConfig configures an endpoint alerts are sent to. Failed sends are
+// retried with an exponential back-off.
+type NotifierConfig struct {
+	URL string `yaml:"url"`
+	// Timeout of a request, 10s if unset.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxBackoff is the longest delay between two retries, 1m if unset.
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+	// QueueCapacity is the number of alerts waiting to be sent beyond which
+	// the oldest are dropped, 1000 if unset.
+	QueueCapacity int `yaml:"queue_capacity"`
+}
+
+// ServiceChecksConfig configures checks of the services of the node, run
+// every cycle, and the actions remediating the failed ones, like Monit.
+type ServiceChecksConfig struct {
+	// Interval is the duration of a cycle, 30s if unset.
+	Interval time.Duration `yaml:"interval"`
+	// SupervisordURL is the XML-RPC endpoint of supervisord used to restart
+	// its programs, http://localhost:9001/RPC2 if unset.
+	SupervisordURL string                `yaml:"supervisord_url"`
+	Checks         []*ServiceCheckConfig `yaml:"checks"`
+}
+
+// ServiceCheckConfig is a check of a service, found by exactly one of
+// Process, Pidfile, TCP, HTTP and SystemdUnit. The check fails when the
+// service is missing or one of its rules fails.
+type ServiceCheckConfig struct {
+	Name string `yaml:"name"`
+	// Process is a regular expression matched against the command lines of
+	// the processes.
+	Process string `yaml:"process"`
+	// Pidfile is a file holding the PID of the process.
+	Pidfile string `yaml:"pidfile"`
+	// TCP is an address accepting connections, e.g. localhost:2379.
+	TCP string `yaml:"tcp"`
+	// HTTP is a URL answering without an error status.
+	HTTP string `yaml:"http"`
+	// SystemdUnit is a unit which must be active.
+	SystemdUnit string `yaml:"systemd_unit"`
+	// Timeout of the TCP and HTTP checks, 5s if unset.
+	Timeout time.Duration               `yaml:"timeout"`
+	Rules   []*ServiceCheckRuleConfig   `yaml:"rules"`
+	Actions []*ServiceCheckActionConfig `yaml:"actions"`
+	// MaxActions is the number of times the actions run per ActionPeriod, 5
+	// per 1h if unset.
+	MaxActions   int           `yaml:"max_actions"`
+	ActionPeriod time.Duration `yaml:"action_period"`
+	// GiveUpAfter is the number of times the actions run without the check
+	// recovering after which they are given up, until it recovers. They are
+	// never given up if unset.
+	GiveUpAfter int `yaml:"give_up_after"`
+}
+
+// ServiceCheckRuleConfig is a condition failing a check once it held for
+// Cycles consecutive cycles.
+type ServiceCheckRuleConfig struct {
+	// Condition is missing, cpu or memory. missing holds while the service is
+	// missing, it fails the check after a cycle if no missing rule is set.
+	Condition string `yaml:"condition"`
+	// Threshold is the CPU usage in percent of a CPU above which cpu holds,
+	// and the resident memory in bytes above which memory holds, of all the
+	// processes of the service.
+	Threshold float64 `yaml:"threshold"`
+	// Cycles is 1 if unset.
+	Cycles int `yaml:"cycles"`
+}
+
+// ServiceCheckActionConfig is an action run when a check fails, exactly one
+// of SystemdRestart, SupervisordRestart and Command.
+type ServiceCheckActionConfig struct {
+	// SystemdRestart is a systemd unit restarted over D-Bus.
+	SystemdRestart string `yaml:"systemd_restart"`
+	// SupervisordRestart is a supervisord program restarted over XML-RPC.
+	SupervisordRestart string `yaml:"supervisord_restart"`
+	// Command is a command and its arguments, run without a shell.
+	Command []string `yaml:"command,flow"`
+	// Timeout of the action, 30s if unset.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
 // Config is the top-level configuration for Metastord.
 type Config struct {
-	Cluster []*ClusterConfig
+	Cluster    []*ClusterConfig
+	Collectors map[string]*CollectorConfig `yaml:"collectors"`
+	Profiles   []*ProfileConfig            `yaml:"profiles"`
+	Sinks      *SinksConfig                `yaml:"sinks"`
+	// TargetLabels are added to every series.
+	TargetLabels *TargetLabelsConfig `yaml:"target_labels"`
+	// RelabelConfigs are applied to the metrics of all collectors.
+	RelabelConfigs []*RelabelConfig `yaml:"relabel_configs"`
+	Alerting       *AlertingConfig  `yaml:"alerting"`
+	// ServiceChecks are the checks of the services of the node.
+	ServiceChecks *ServiceChecksConfig `yaml:"service_checks"`
 }
 
 // fileExists returns true if the path exists and is a file.
@@ -35,7 +245,7 @@ func ParseConfig(p string) (*Config, error) {
 	}
 
 	var cfg Config
-	err = yaml.Unmarshal(cfgData, &cfg)
+	err = yaml.UnmarshalStrict(cfgData, &cfg)
 	if err != nil {
 		return nil, fmt.Errorf("yaml parse: %v", err)
 	}
@@ -1 +1,332 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
 package utils
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-gomail/gomail"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/log"
+)
+
+const (
+	defaultSMTPPort        = 25
+	defaultDigestWindow    = time.Minute
+	defaultRateLimit       = 5
+	defaultRateLimitPeriod = time.Hour
+	// maxPendingEmailEvents caps the events kept while the SMTP server is
+	// unreachable, the oldest are dropped beyond it.
+	maxPendingEmailEvents = 1000
+	// defaultRecipients receive the events of severities without recipients.
+	defaultRecipients = "default"
+)
+
+var (
+	emailsSent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "node_exporter",
+		Subsystem: "email",
+		Name:      "sent_total",
+		Help:      "Total number of notification emails sent.",
+	})
+	emailsFailed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "node_exporter",
+		Subsystem: "email",
+		Name:      "failed_total",
+		Help:      "Total number of notification emails that failed to be sent.",
+	})
+	emailEventsSuppressed = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "node_exporter",
+		Subsystem: "email",
+		Name:      "suppressed_events_total",
+		Help:      "Total number of events held back by the rate limit or dropped because the queue was full.",
+	})
+
+	// EmailMetrics are the metrics of the email notifications, to be
+	// registered once.
+	EmailMetrics = []prometheus.Collector{emailsSent, emailsFailed, emailEventsSuppressed}
+)
+
+// EmailConfig configures the email notifications. Events are collected for
+// DigestWindow and sent in a single email per set of recipients.
+type EmailConfig struct {
+	// SMTPHost and SMTPPort are the SMTP server, port 25 if unset. STARTTLS is
+	// used if the server supports it, port 465 uses TLS from the start.
+	SMTPHost string `yaml:"smtp_host"`
+	SMTPPort int    `yaml:"smtp_port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	// Recipients are the addresses receiving the events of every severity.
+	// The events of other severities go to the "default" recipients.
+	Recipients map[string][]string `yaml:"recipients"`
+	// DigestWindow is how long events are collected before they are sent, 1m
+	// if unset.
+	DigestWindow time.Duration `yaml:"digest_window"`
+	// RateLimit is the number of events of the same thing sent per
+	// RateLimitPeriod, 5 per 1h if unset. Further events are held back, only
+	// the last one is sent once the rate limit allows it, with the number of
+	// events it stands for.
+	RateLimit       int           `yaml:"rate_limit"`
+	RateLimitPeriod time.Duration `yaml:"rate_limit_period"`
+}
+
+// EmailEvent is a change of state sent by email.
+type EmailEvent struct {
+	// Key identifies what changed state, for the rate limit.
+	Key      string
+	Severity string
+	Summary  string
+	Time     time.Time
+}
+
+type pendingEmailEvent struct {
+	EmailEvent
+	// suppressed is the number of events of the same key held back by the
+	// rate limit before this one.
+	suppressed int
+}
+
+type suppressedEmailEvents struct {
+	count int
+	last  EmailEvent
+}
+
+// EmailNotifier sends digests of events by email.
+type EmailNotifier struct {
+	cfg      EmailConfig
+	hostname string
+	send     func(...*gomail.Message) error
+
+	mtx     sync.Mutex
+	pending []pendingEmailEvent
+	// sent holds the times of the events of every key sent within the rate
+	// limit period.
+	sent       map[string][]time.Time
+	suppressed map[string]*suppressedEmailEvents
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// NewEmailNotifier returns an EmailNotifier sending with the settings of cfg,
+// it has to be started.
+func NewEmailNotifier(cfg *EmailConfig) (*EmailNotifier, error) {
+	if cfg.SMTPHost == "" {
+		return nil, errors.New("missing SMTP host")
+	}
+	if cfg.From == "" {
+		return nil, errors.New("missing sender address")
+	}
+	if len(cfg.Recipients) == 0 {
+		return nil, errors.New("missing recipients")
+	}
+	e := &EmailNotifier{
+		cfg:        *cfg,
+		sent:       map[string][]time.Time{},
+		suppressed: map[string]*suppressedEmailEvents{},
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+	if e.cfg.SMTPPort == 0 {
+		e.cfg.SMTPPort = defaultSMTPPort
+	}
+	if e.cfg.DigestWindow <= 0 {
+		e.cfg.DigestWindow = defaultDigestWindow
+	}
+	if e.cfg.RateLimit <= 0 {
+		e.cfg.RateLimit = defaultRateLimit
+	}
+	if e.cfg.RateLimitPeriod <= 0 {
+		e.cfg.RateLimitPeriod = defaultRateLimitPeriod
+	}
+	var err error
+	if e.hostname, err = os.Hostname(); err != nil {
+		return nil, err
+	}
+	dialer := gomail.NewDialer(e.cfg.SMTPHost, e.cfg.SMTPPort, e.cfg.Username, e.cfg.Password)
+	dialer.LocalName = e.hostname
+	e.send = dialer.DialAndSend
+	return e, nil
+}
+
+// Notify queues events to be sent with the next digest, unless their key
+// reached the rate limit.
+func (e *EmailNotifier) Notify(events ...EmailEvent) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+	for _, ev := range events {
+		if !e.allow(ev.Key, ev.Time) {
+			s, ok := e.suppressed[ev.Key]
+			if !ok {
+				s = &suppressedEmailEvents{}
+				e.suppressed[ev.Key] = s
+			}
+			s.count++
+			s.last = ev
+			emailEventsSuppressed.Inc()
+			continue
+		}
+		n := 0
+		if s, ok := e.suppressed[ev.Key]; ok {
+			n = s.count
+			delete(e.suppressed, ev.Key)
+		}
+		e.queue(pendingEmailEvent{EmailEvent: ev, suppressed: n})
+	}
+}
+
+// allow returns whether an event of key at t is within the rate limit, and
+// records it if so.
+func (e *EmailNotifier) allow(key string, t time.Time) bool {
+	sent := e.sent[key]
+	for len(sent) > 0 && t.Sub(sent[0]) >= e.cfg.RateLimitPeriod {
+		sent = sent[1:]
+	}
+	if len(sent) >= e.cfg.RateLimit {
+		e.sent[key] = sent
+		return false
+	}
+	if len(sent) == 0 {
+		// Forget the keys without recent events.
+		sent = nil
+	}
+	e.sent[key] = append(sent, t)
+	return true
+}
+
+func (e *EmailNotifier) queue(ev pendingEmailEvent) {
+	e.pending = append(e.pending, ev)
+	if over := len(e.pending) - maxPendingEmailEvents; over > 0 {
+		e.pending = e.pending[over:]
+		emailEventsSuppressed.Add(float64(over))
+	}
+}
+
+// Start starts sending a digest every digest window.
+func (e *EmailNotifier) Start() {
+	go e.run()
+}
+
+// Stop stops sending digests, after sending the pending events.
+func (e *EmailNotifier) Stop() {
+	close(e.done)
+	<-e.stopped
+}
+
+func (e *EmailNotifier) run() {
+	defer close(e.stopped)
+	ticker := time.NewTicker(e.cfg.DigestWindow)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.done:
+			if err := e.flush(time.Now()); err != nil {
+				log.Errorln("Error sending notification email:", err)
+			}
+			return
+		}
+		if err := e.flush(time.Now()); err != nil {
+			log.Errorln("Error sending notification email, retrying with the next digest:", err)
+		}
+	}
+}
+
+// flush sends the pending events, one email per set of recipients, along with
+// the last events held back by the rate limit if it allows them at now. The
+// events that couldn't be sent are kept for the next digest.
+func (e *EmailNotifier) flush(now time.Time) error {
+	e.mtx.Lock()
+	for key, s := range e.suppressed {
+		if e.allow(key, now) {
+			e.queue(pendingEmailEvent{EmailEvent: s.last, suppressed: s.count - 1})
+			delete(e.suppressed, key)
+		}
+	}
+	pending := e.pending
+	e.pending = nil
+	for key, sent := range e.sent {
+		if len(sent) > 0 && now.Sub(sent[len(sent)-1]) >= e.cfg.RateLimitPeriod {
+			delete(e.sent, key)
+		}
+	}
+	e.mtx.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+
+	groups := map[string][]pendingEmailEvent{}
+	for _, ev := range pending {
+		to, ok := e.cfg.Recipients[ev.Severity]
+		if !ok {
+			to = e.cfg.Recipients[defaultRecipients]
+		}
+		if len(to) == 0 {
+			continue
+		}
+		key := strings.Join(to, ",")
+		groups[key] = append(groups[key], ev)
+	}
+	var failed []pendingEmailEvent
+	var lastErr error
+	for key, events := range groups {
+		if err := e.send(e.message(strings.Split(key, ","), events)); err != nil {
+			emailsFailed.Inc()
+			failed = append(failed, events...)
+			lastErr = err
+			continue
+		}
+		emailsSent.Inc()
+	}
+
+	if len(failed) > 0 {
+		e.mtx.Lock()
+		pending, e.pending = e.pending, failed
+		for _, ev := range pending {
+			e.queue(ev)
+		}
+		e.mtx.Unlock()
+	}
+	return lastErr
+}
+
+// message returns the email of events.
+func (e *EmailNotifier) message(to []string, events []pendingEmailEvent) *gomail.Message {
+	sort.Slice(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	var body strings.Builder
+	for _, ev := range events {
+		fmt.Fprintf(&body, "%s [%s] %s\n", ev.Time.UTC().Format(time.RFC3339), ev.Severity, ev.Summary)
+		if ev.suppressed > 0 {
+			fmt.Fprintf(&body, "    %d earlier events held back by the rate limit\n", ev.suppressed)
+		}
+	}
+
+	subject := fmt.Sprintf("[node_exporter] %s: %d events", e.hostname, len(events))
+	if len(events) == 1 {
+		subject = fmt.Sprintf("[node_exporter] %s: %s", e.hostname, events[0].Summary)
+	}
+	m := gomail.NewMessage()
+	m.SetHeader("From", e.cfg.From)
+	m.SetHeader("To", to...)
+	m.SetHeader("Subject", subject)
+	m.SetBody("text/plain", body.String())
+	return m
+}
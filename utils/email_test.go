@@ -0,0 +1,252 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package utils
+
+import (
+	"errors"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net"
+	"net/textproto"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gomail/gomail"
+)
+
+// smtpMessage is an email received by smtpServer.
+type smtpMessage struct {
+	to   []string
+	data string
+}
+
+// body returns the decoded body of the message.
+func (m smtpMessage) body(t *testing.T) string {
+	i := strings.Index(m.data, "\n\n")
+	if i < 0 {
+		t.Fatalf("no body in %q", m.data)
+	}
+	b, err := ioutil.ReadAll(quotedprintable.NewReader(strings.NewReader(m.data[i+2:])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// smtpServer is a minimal SMTP server accepting every email.
+type smtpServer struct {
+	listener net.Listener
+	messages chan smtpMessage
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{listener: l, messages: make(chan smtpMessage, 100)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *smtpServer) serve(conn net.Conn) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 localhost ESMTP")
+	var msg smtpMessage
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+		case "EHLO", "HELO", "MAIL", "RSET", "NOOP":
+			c.PrintfLine("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			c.PrintfLine("250 OK")
+		case "DATA":
+			c.PrintfLine("354 Go ahead")
+			data, err := c.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.messages <- msg
+			msg = smtpMessage{}
+			c.PrintfLine("250 OK")
+		case "QUIT":
+			c.PrintfLine("221 Bye")
+			return
+		default:
+			c.PrintfLine("502 Command not implemented")
+		}
+	}
+}
+
+// receive returns the emails received, sorted by their first recipient.
+func (s *smtpServer) receive(t *testing.T, n int) []smtpMessage {
+	var msgs []smtpMessage
+	for len(msgs) < n {
+		select {
+		case m := <-s.messages:
+			msgs = append(msgs, m)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("want %d emails, got %d", n, len(msgs))
+		}
+	}
+	select {
+	case m := <-s.messages:
+		t.Fatalf("unexpected email %+v", m)
+	default:
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].to[0] < msgs[j].to[0] })
+	return msgs
+}
+
+func newTestEmailNotifier(t *testing.T, s *smtpServer) *EmailNotifier {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	e, err := NewEmailNotifier(&EmailConfig{
+		SMTPHost: addr.IP.String(),
+		SMTPPort: addr.Port,
+		From:     "node@example.com",
+		Recipients: map[string][]string{
+			"critical": {"ops@example.com", "oncall@example.com"},
+			"default":  {"team@example.com"},
+		},
+		DigestWindow:    time.Hour,
+		RateLimit:       2,
+		RateLimitPeriod: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEmailNotifierDigest(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.listener.Close()
+	e := newTestEmailNotifier(t, s)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	e.Notify(
+		EmailEvent{Key: "a", Severity: "critical", Summary: "unit a failed", Time: now.Add(time.Second)},
+		EmailEvent{Key: "b", Severity: "warning", Summary: "collector b failed", Time: now},
+		EmailEvent{Key: "c", Severity: "critical", Summary: "unit c failed", Time: now},
+	)
+	if err := e.flush(now); err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.receive(t, 2)
+	if to := strings.Join(msgs[0].to, ","); to != "ops@example.com,oncall@example.com" {
+		t.Errorf("want the critical events sent to the critical recipients, got %s", to)
+	}
+	want := "2018-03-01T12:00:00Z [critical] unit c failed\n2018-03-01T12:00:01Z [critical] unit a failed\n"
+	if body := msgs[0].body(t); body != want {
+		t.Errorf("want body %q, got %q", want, body)
+	}
+	if !strings.Contains(msgs[0].data, ": 2 events") {
+		t.Errorf("want the number of events in the subject, got %q", msgs[0].data)
+	}
+	if to := strings.Join(msgs[1].to, ","); to != "team@example.com" {
+		t.Errorf("want the warning sent to the default recipients, got %s", to)
+	}
+	if !strings.Contains(msgs[1].data, ": collector b failed") {
+		t.Errorf("want the summary of a single event in the subject, got %q", msgs[1].data)
+	}
+
+	// Nothing is sent without events.
+	if err := e.flush(now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	s.receive(t, 0)
+}
+
+func TestEmailNotifierRateLimit(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.listener.Close()
+	e := newTestEmailNotifier(t, s)
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, summary := range []string{"failed", "active", "failed", "active", "failed"} {
+		e.Notify(EmailEvent{Key: "a", Severity: "critical", Summary: summary, Time: now.Add(time.Duration(i) * time.Second)})
+	}
+	if err := e.flush(now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	want := "2018-03-01T12:00:00Z [critical] failed\n2018-03-01T12:00:01Z [critical] active\n"
+	if body := s.receive(t, 1)[0].body(t); body != want {
+		t.Errorf("want body %q, got %q", want, body)
+	}
+
+	// The last event held back is sent once the rate limit allows it.
+	if err := e.flush(now.Add(30 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	s.receive(t, 0)
+	if err := e.flush(now.Add(time.Hour + time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	want = "2018-03-01T12:00:04Z [critical] failed\n    2 earlier events held back by the rate limit\n"
+	if body := s.receive(t, 1)[0].body(t); body != want {
+		t.Errorf("want body %q, got %q", want, body)
+	}
+}
+
+func TestEmailNotifierRetry(t *testing.T) {
+	s := newSMTPServer(t)
+	defer s.listener.Close()
+	e := newTestEmailNotifier(t, s)
+	send := e.send
+	e.send = func(...*gomail.Message) error { return errors.New("unavailable") }
+
+	now := time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC)
+	e.Notify(EmailEvent{Key: "a", Severity: "critical", Summary: "unit a failed", Time: now})
+	if err := e.flush(now); err == nil {
+		t.Fatal("want an error")
+	}
+	e.Notify(EmailEvent{Key: "b", Severity: "critical", Summary: "unit b failed", Time: now.Add(time.Second)})
+
+	e.send = send
+	if err := e.flush(now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	want := "2018-03-01T12:00:00Z [critical] unit a failed\n2018-03-01T12:00:01Z [critical] unit b failed\n"
+	if body := s.receive(t, 1)[0].body(t); body != want {
+		t.Errorf("want body %q, got %q", want, body)
+	}
+}
+
+func TestNewEmailNotifierErrors(t *testing.T) {
+	for _, cfg := range []*EmailConfig{
+		{From: "node@example.com", Recipients: map[string][]string{"default": {"team@example.com"}}},
+		{SMTPHost: "localhost", Recipients: map[string][]string{"default": {"team@example.com"}}},
+		{SMTPHost: "localhost", From: "node@example.com"},
+	} {
+		if _, err := NewEmailNotifier(cfg); err == nil {
+			t.Errorf("want an error for %+v", cfg)
+		}
+	}
+}